@@ -0,0 +1,4 @@
+// Package api is the protobuf/gRPC API of the stream node.
+package api
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. stream.proto
@@ -0,0 +1,577 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: stream.proto
+
+package api
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type PushRequest struct {
+	Value                string   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PushRequest) Reset()         { *m = PushRequest{} }
+func (m *PushRequest) String() string { return proto.CompactTextString(m) }
+func (*PushRequest) ProtoMessage()    {}
+func (*PushRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{0}
+}
+
+func (m *PushRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PushRequest.Unmarshal(m, b)
+}
+func (m *PushRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PushRequest.Marshal(b, m, deterministic)
+}
+func (m *PushRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PushRequest.Merge(m, src)
+}
+func (m *PushRequest) XXX_Size() int {
+	return xxx_messageInfo_PushRequest.Size(m)
+}
+func (m *PushRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PushRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PushRequest proto.InternalMessageInfo
+
+func (m *PushRequest) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+type PushReply struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PushReply) Reset()         { *m = PushReply{} }
+func (m *PushReply) String() string { return proto.CompactTextString(m) }
+func (*PushReply) ProtoMessage()    {}
+func (*PushReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{1}
+}
+
+func (m *PushReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PushReply.Unmarshal(m, b)
+}
+func (m *PushReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PushReply.Marshal(b, m, deterministic)
+}
+func (m *PushReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PushReply.Merge(m, src)
+}
+func (m *PushReply) XXX_Size() int {
+	return xxx_messageInfo_PushReply.Size(m)
+}
+func (m *PushReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_PushReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PushReply proto.InternalMessageInfo
+
+type PushBatchRequest struct {
+	Values               []string `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PushBatchRequest) Reset()         { *m = PushBatchRequest{} }
+func (m *PushBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*PushBatchRequest) ProtoMessage()    {}
+func (*PushBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{2}
+}
+
+func (m *PushBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PushBatchRequest.Unmarshal(m, b)
+}
+func (m *PushBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PushBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *PushBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PushBatchRequest.Merge(m, src)
+}
+func (m *PushBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_PushBatchRequest.Size(m)
+}
+func (m *PushBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PushBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PushBatchRequest proto.InternalMessageInfo
+
+func (m *PushBatchRequest) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+type PushBatchReply struct {
+	Pushed               int32    `protobuf:"varint,1,opt,name=pushed,proto3" json:"pushed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PushBatchReply) Reset()         { *m = PushBatchReply{} }
+func (m *PushBatchReply) String() string { return proto.CompactTextString(m) }
+func (*PushBatchReply) ProtoMessage()    {}
+func (*PushBatchReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{3}
+}
+
+func (m *PushBatchReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PushBatchReply.Unmarshal(m, b)
+}
+func (m *PushBatchReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PushBatchReply.Marshal(b, m, deterministic)
+}
+func (m *PushBatchReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PushBatchReply.Merge(m, src)
+}
+func (m *PushBatchReply) XXX_Size() int {
+	return xxx_messageInfo_PushBatchReply.Size(m)
+}
+func (m *PushBatchReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_PushBatchReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PushBatchReply proto.InternalMessageInfo
+
+func (m *PushBatchReply) GetPushed() int32 {
+	if m != nil {
+		return m.Pushed
+	}
+	return 0
+}
+
+type GetRequest struct {
+	From                 int64    `protobuf:"varint,1,opt,name=from,proto3" json:"from,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetRequest) Reset()         { *m = GetRequest{} }
+func (m *GetRequest) String() string { return proto.CompactTextString(m) }
+func (*GetRequest) ProtoMessage()    {}
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{4}
+}
+
+func (m *GetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetRequest.Unmarshal(m, b)
+}
+func (m *GetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetRequest.Marshal(b, m, deterministic)
+}
+func (m *GetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetRequest.Merge(m, src)
+}
+func (m *GetRequest) XXX_Size() int {
+	return xxx_messageInfo_GetRequest.Size(m)
+}
+func (m *GetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetRequest proto.InternalMessageInfo
+
+func (m *GetRequest) GetFrom() int64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+type PullRequest struct {
+	From                 int64    `protobuf:"varint,1,opt,name=from,proto3" json:"from,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PullRequest) Reset()         { *m = PullRequest{} }
+func (m *PullRequest) String() string { return proto.CompactTextString(m) }
+func (*PullRequest) ProtoMessage()    {}
+func (*PullRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{5}
+}
+
+func (m *PullRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PullRequest.Unmarshal(m, b)
+}
+func (m *PullRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PullRequest.Marshal(b, m, deterministic)
+}
+func (m *PullRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PullRequest.Merge(m, src)
+}
+func (m *PullRequest) XXX_Size() int {
+	return xxx_messageInfo_PullRequest.Size(m)
+}
+func (m *PullRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PullRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PullRequest proto.InternalMessageInfo
+
+func (m *PullRequest) GetFrom() int64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+type Entry struct {
+	Value                string   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Entry) Reset()         { *m = Entry{} }
+func (m *Entry) String() string { return proto.CompactTextString(m) }
+func (*Entry) ProtoMessage()    {}
+func (*Entry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bb17ef3f514bfe54, []int{6}
+}
+
+func (m *Entry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Entry.Unmarshal(m, b)
+}
+func (m *Entry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Entry.Marshal(b, m, deterministic)
+}
+func (m *Entry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Entry.Merge(m, src)
+}
+func (m *Entry) XXX_Size() int {
+	return xxx_messageInfo_Entry.Size(m)
+}
+func (m *Entry) XXX_DiscardUnknown() {
+	xxx_messageInfo_Entry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Entry proto.InternalMessageInfo
+
+func (m *Entry) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*PushRequest)(nil), "stream.PushRequest")
+	proto.RegisterType((*PushReply)(nil), "stream.PushReply")
+	proto.RegisterType((*PushBatchRequest)(nil), "stream.PushBatchRequest")
+	proto.RegisterType((*PushBatchReply)(nil), "stream.PushBatchReply")
+	proto.RegisterType((*GetRequest)(nil), "stream.GetRequest")
+	proto.RegisterType((*PullRequest)(nil), "stream.PullRequest")
+	proto.RegisterType((*Entry)(nil), "stream.Entry")
+}
+
+func init() { proto.RegisterFile("stream.proto", fileDescriptor_bb17ef3f514bfe54) }
+
+var fileDescriptor_bb17ef3f514bfe54 = []byte{
+	// 282 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x91, 0x41, 0x4b, 0xc4, 0x30,
+	0x10, 0x85, 0x29, 0xdd, 0x16, 0x3a, 0xab, 0xa2, 0x51, 0x96, 0x52, 0x10, 0x6a, 0xf4, 0x50, 0x16,
+	0x6d, 0x45, 0x8f, 0xe2, 0x65, 0x41, 0xf6, 0x2a, 0xf5, 0xe6, 0x2d, 0xbb, 0x46, 0x5b, 0x98, 0xda,
+	0xd8, 0x26, 0x62, 0x7f, 0xa6, 0xff, 0x48, 0x92, 0xb6, 0x6b, 0x84, 0x75, 0x6f, 0x79, 0x33, 0xdf,
+	0x24, 0xef, 0x4d, 0x60, 0xaf, 0x95, 0x0d, 0x67, 0x55, 0x2a, 0x9a, 0x5a, 0xd6, 0xc4, 0xef, 0x15,
+	0x3d, 0x87, 0xe9, 0xa3, 0x6a, 0x8b, 0x9c, 0x7f, 0x28, 0xde, 0x4a, 0x72, 0x02, 0xde, 0x27, 0x43,
+	0xc5, 0x43, 0x27, 0x76, 0x92, 0x20, 0xef, 0x05, 0x9d, 0x42, 0xd0, 0x43, 0x02, 0x3b, 0x3a, 0x87,
+	0x43, 0x2d, 0x16, 0x4c, 0xae, 0x37, 0x63, 0x33, 0xf0, 0x0d, 0xd9, 0x86, 0x4e, 0xec, 0x26, 0x41,
+	0x3e, 0x28, 0x9a, 0xc0, 0x81, 0xc5, 0x0a, 0xec, 0x34, 0x29, 0x54, 0x5b, 0xf0, 0x17, 0xf3, 0x82,
+	0x97, 0x0f, 0x8a, 0xc6, 0x00, 0x4b, 0x2e, 0xc7, 0xfb, 0x08, 0x4c, 0x5e, 0x9b, 0xba, 0x32, 0x8c,
+	0x9b, 0x9b, 0x33, 0x3d, 0xd3, 0x4e, 0x11, 0x77, 0x21, 0xa7, 0xe0, 0x3d, 0xbc, 0xcb, 0xa6, 0xdb,
+	0x1e, 0xe3, 0xe6, 0xdb, 0x01, 0xff, 0xc9, 0xc4, 0x26, 0x29, 0x4c, 0xb4, 0x31, 0x72, 0x9c, 0x0e,
+	0x5b, 0xb1, 0x96, 0x10, 0x1d, 0xfd, 0x2d, 0x6a, 0xdb, 0xf7, 0x10, 0x6c, 0x82, 0x90, 0xd0, 0xee,
+	0xdb, 0x7b, 0x88, 0x66, 0x5b, 0x3a, 0x7a, 0x7c, 0x0e, 0xee, 0x92, 0x4b, 0x42, 0xc6, 0xf6, 0x6f,
+	0xd4, 0x68, 0x7f, 0xac, 0x19, 0xe7, 0xd7, 0x0e, 0xb9, 0xd4, 0xd6, 0x10, 0x6d, 0x6b, 0x88, 0xff,
+	0xd1, 0x8b, 0x8b, 0x67, 0xfa, 0x56, 0xca, 0x42, 0xad, 0xd2, 0x75, 0x5d, 0x65, 0x92, 0x35, 0x25,
+	0xc7, 0xab, 0xaf, 0xac, 0xa7, 0x32, 0x26, 0xca, 0x3b, 0x26, 0xca, 0x95, 0x6f, 0x3e, 0xfd, 0xf6,
+	0x67, 0x00, 0xb6, 0x1b, 0x9c, 0x40, 0x04, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// StreamClient is the client API for Stream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type StreamClient interface {
+	// Push commits the value to the cluster.
+	Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushReply, error)
+	// PushBatch commits values one by one in the order, it stops on the
+	// first failed value.
+	PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchReply, error)
+	// Get streams the log from the epoch to the end.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Stream_GetClient, error)
+	// Pull streams the log from the epoch and follows new values.
+	Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (Stream_PullClient, error)
+}
+
+type streamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStreamClient(cc grpc.ClientConnInterface) StreamClient {
+	return &streamClient{cc}
+}
+
+func (c *streamClient) Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushReply, error) {
+	out := new(PushReply)
+	err := c.cc.Invoke(ctx, "/stream.Stream/Push", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *streamClient) PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchReply, error) {
+	out := new(PushBatchReply)
+	err := c.cc.Invoke(ctx, "/stream.Stream/PushBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *streamClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Stream_GetClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Stream_serviceDesc.Streams[0], "/stream.Stream/Get", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &streamGetClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Stream_GetClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type streamGetClient struct {
+	grpc.ClientStream
+}
+
+func (x *streamGetClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *streamClient) Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (Stream_PullClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Stream_serviceDesc.Streams[1], "/stream.Stream/Pull", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &streamPullClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Stream_PullClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type streamPullClient struct {
+	grpc.ClientStream
+}
+
+func (x *streamPullClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StreamServer is the server API for Stream service.
+type StreamServer interface {
+	// Push commits the value to the cluster.
+	Push(context.Context, *PushRequest) (*PushReply, error)
+	// PushBatch commits values one by one in the order, it stops on the
+	// first failed value.
+	PushBatch(context.Context, *PushBatchRequest) (*PushBatchReply, error)
+	// Get streams the log from the epoch to the end.
+	Get(*GetRequest, Stream_GetServer) error
+	// Pull streams the log from the epoch and follows new values.
+	Pull(*PullRequest, Stream_PullServer) error
+}
+
+// UnimplementedStreamServer can be embedded to have forward compatible implementations.
+type UnimplementedStreamServer struct {
+}
+
+func (*UnimplementedStreamServer) Push(ctx context.Context, req *PushRequest) (*PushReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (*UnimplementedStreamServer) PushBatch(ctx context.Context, req *PushBatchRequest) (*PushBatchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushBatch not implemented")
+}
+func (*UnimplementedStreamServer) Get(req *GetRequest, srv Stream_GetServer) error {
+	return status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (*UnimplementedStreamServer) Pull(req *PullRequest, srv Stream_PullServer) error {
+	return status.Errorf(codes.Unimplemented, "method Pull not implemented")
+}
+
+func RegisterStreamServer(s *grpc.Server, srv StreamServer) {
+	s.RegisterService(&_Stream_serviceDesc, srv)
+}
+
+func _Stream_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stream.Stream/Push",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamServer).Push(ctx, req.(*PushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Stream_PushBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamServer).PushBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stream.Stream/PushBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamServer).PushBatch(ctx, req.(*PushBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Stream_Get_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StreamServer).Get(m, &streamGetServer{stream})
+}
+
+type Stream_GetServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type streamGetServer struct {
+	grpc.ServerStream
+}
+
+func (x *streamGetServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Stream_Pull_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PullRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StreamServer).Pull(m, &streamPullServer{stream})
+}
+
+type Stream_PullServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type streamPullServer struct {
+	grpc.ServerStream
+}
+
+func (x *streamPullServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Stream_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "stream.Stream",
+	HandlerType: (*StreamServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _Stream_Push_Handler,
+		},
+		{
+			MethodName: "PushBatch",
+			Handler:    _Stream_PushBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Get",
+			Handler:       _Stream_Get_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Pull",
+			Handler:       _Stream_Pull_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "stream.proto",
+}
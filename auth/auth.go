@@ -0,0 +1,229 @@
+// Package auth authenticates clients by the credentials file and checks
+// their permissions.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/tariel-x/stream/client"
+)
+
+// Anonymous is the user of requests without credentials.
+const Anonymous = "anonymous"
+
+// bcryptPrefixes mark the bcrypt hash of the password or the token instead
+// of the plain value in the file.
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// maxVerified limits remembered verified credentials, bcrypt is too slow to
+// check credentials of every request.
+const maxVerified = 1024
+
+// User is the client with the password or the token, plain or hashed by
+// bcrypt. The token hashed by bcrypt is sent as name:token, so only the
+// hash of the named user is checked. Permissions are commands the user may run: push, get, pull and
+// status.
+type User struct {
+	Name        string   `yaml:"name"`
+	Password    string   `yaml:"password"`
+	Token       string   `yaml:"token"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// Credentials are users of the credentials file. Anonymous are
+// permissions of requests without credentials, none by default.
+type Credentials struct {
+	Users     []User   `yaml:"users"`
+	Anonymous []string `yaml:"anonymous"`
+
+	permissions map[string]map[string]struct{}
+	users       map[string]User
+	// tokens are users by SHA-256 of plain tokens.
+	tokens map[[sha256.Size]byte]string
+	m      sync.Mutex
+	// verified are users by SHA-256 of credentials which matched hashes.
+	verified map[[sha256.Size]byte]string
+}
+
+// Load reads the credentials file, e.g.
+//
+//	users:
+//	  - name: producer
+//	    password: $2a$10$8QB69lsDMhEL56FCmTzpOudnlMJwUsJyp2k7xM4aW90dFJ0LQxPdm
+//	    permissions: [push]
+//	  - name: dashboard
+//	    token: secret-token
+//	    permissions: [get, pull]
+//	  - name: exporter
+//	    token: $2a$10$...
+//	    permissions: [pull]
+//	anonymous: [get]
+func Load(path string) (*Credentials, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	credentials := &Credentials{}
+	if err := yaml.UnmarshalStrict(data, credentials); err != nil {
+		return nil, fmt.Errorf("credentials %s: %v", path, err)
+	}
+	if err := credentials.init(); err != nil {
+		return nil, fmt.Errorf("credentials %s: %v", path, err)
+	}
+	return credentials, nil
+}
+
+func (c *Credentials) init() error {
+	c.permissions = map[string]map[string]struct{}{}
+	c.users = map[string]User{}
+	c.tokens = map[[sha256.Size]byte]string{}
+	c.verified = map[[sha256.Size]byte]string{}
+	add := func(name string, permissions []string) error {
+		if _, ok := c.permissions[name]; ok {
+			return fmt.Errorf("duplicate user %s", name)
+		}
+		c.permissions[name] = map[string]struct{}{}
+		for _, permission := range permissions {
+			cmd := strings.ToUpper(permission)
+			switch cmd {
+			case client.CmdPush, client.CmdGet, client.CmdPull, client.CmdStatus:
+			default:
+				return fmt.Errorf("unknown permission %s of %s", permission, name)
+			}
+			c.permissions[name][cmd] = struct{}{}
+		}
+		return nil
+	}
+	if err := add(Anonymous, c.Anonymous); err != nil {
+		return err
+	}
+	for _, user := range c.Users {
+		if user.Name == "" {
+			return errors.New("user without name")
+		}
+		if user.Password == "" && user.Token == "" {
+			return fmt.Errorf("user %s has neither password nor token", user.Name)
+		}
+		for _, secret := range []string{user.Password, user.Token} {
+			if strings.HasPrefix(secret, "sha256:") {
+				return fmt.Errorf("user %s: SHA-256 hashes are not supported, hash the secret by bcrypt", user.Name)
+			}
+		}
+		if err := add(user.Name, user.Permissions); err != nil {
+			return err
+		}
+		c.users[user.Name] = user
+		switch {
+		case user.Token == "":
+		case hashed(user.Token):
+			if strings.Contains(user.Name, ":") {
+				return fmt.Errorf("user %s with the hashed token has : in the name", user.Name)
+			}
+		default:
+			key := sha256.Sum256([]byte(user.Token))
+			if other, ok := c.tokens[key]; ok {
+				return fmt.Errorf("users %s and %s have the same token", other, user.Name)
+			}
+			c.tokens[key] = user.Name
+		}
+	}
+	return nil
+}
+
+func hashed(secret string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(secret, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(expected, actual string) bool {
+	if expected == "" || actual == "" {
+		return false
+	}
+	if hashed(expected) {
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(actual)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+// verify checks the secret of the user, users of credentials verified
+// before are remembered.
+func (c *Credentials) verify(kind, name, secret string, match func() bool) (string, bool) {
+	key := sha256.Sum256([]byte(kind + "\x00" + name + "\x00" + secret))
+	c.m.Lock()
+	user, ok := c.verified[key]
+	c.m.Unlock()
+	if ok {
+		return user, true
+	}
+	if !match() {
+		return "", false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.verified) >= maxVerified {
+		c.verified = map[[sha256.Size]byte]string{}
+	}
+	c.verified[key] = name
+	return name, true
+}
+
+// token finds the user of the plain token by the index or the user named
+// in the name:token form, so a wrong token is checked against one hash at
+// most.
+func (c *Credentials) token(token string) (string, bool) {
+	if name, ok := c.tokens[sha256.Sum256([]byte(token))]; ok {
+		return name, true
+	}
+	i := strings.Index(token, ":")
+	if i < 0 {
+		return "", false
+	}
+	name, secret := token[:i], token[i+1:]
+	user, ok := c.users[name]
+	if !ok || !hashed(user.Token) {
+		return "", false
+	}
+	return c.verify(client.MetaKeyToken, name, secret, func() bool {
+		return matches(user.Token, secret)
+	})
+}
+
+// Authenticate returns the user by the token or the user and password
+// meta, false is returned if they are wrong. Requests without credentials
+// are anonymous.
+func (c *Credentials) Authenticate(meta map[string]string) (string, bool) {
+	token, name, password := meta[client.MetaKeyToken], meta[client.MetaKeyUser], meta[client.MetaKeyPassword]
+	switch {
+	case token != "":
+		return c.token(token)
+	case name != "":
+		user, ok := c.users[name]
+		if !ok {
+			return "", false
+		}
+		return c.verify(client.MetaKeyPassword, name, password, func() bool {
+			return matches(user.Password, password)
+		})
+	default:
+		return Anonymous, true
+	}
+}
+
+// Allowed checks that the user may run the command.
+func (c *Credentials) Allowed(user, cmd string) bool {
+	_, ok := c.permissions[user][cmd]
+	return ok
+}
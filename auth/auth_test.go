@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tariel-x/stream/client"
+)
+
+const testCredentials = `
+users:
+  - name: producer
+    password: $2a$10$8QB69lsDMhEL56FCmTzpOudnlMJwUsJyp2k7xM4aW90dFJ0LQxPdm
+    permissions: [push]
+  - name: dashboard
+    token: secret-token
+    permissions: [get, pull, status]
+  - name: exporter
+    token: $2a$10$xNUHxvwRsfrxlAyO/LdK7uTF1HqXFQtnGJAllQnxvg1nKP3z7VmjK
+    permissions: [pull]
+anonymous: [get]
+`
+
+func load(t *testing.T, content string) (*Credentials, error) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestCredentials(t *testing.T) {
+	credentials, err := load(t, testCredentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		meta    map[string]string
+		user    string
+		ok      bool
+		allowed []string
+		denied  []string
+	}{
+		{
+			meta:    map[string]string{client.MetaKeyUser: "producer", client.MetaKeyPassword: "password"},
+			user:    "producer",
+			ok:      true,
+			allowed: []string{client.CmdPush},
+			denied:  []string{client.CmdGet, client.CmdPull},
+		},
+		{
+			meta: map[string]string{client.MetaKeyUser: "producer", client.MetaKeyPassword: "wrong"},
+		},
+		{
+			meta:    map[string]string{client.MetaKeyToken: "secret-token"},
+			user:    "dashboard",
+			ok:      true,
+			allowed: []string{client.CmdGet, client.CmdPull, client.CmdStatus},
+			denied:  []string{client.CmdPush},
+		},
+		{
+			meta: map[string]string{client.MetaKeyToken: "wrong"},
+		},
+		{
+			meta: map[string]string{client.MetaKeyToken: "dashboard:secret-token"},
+		},
+		{
+			meta:    map[string]string{client.MetaKeyToken: "exporter:exporter-token"},
+			user:    "exporter",
+			ok:      true,
+			allowed: []string{client.CmdPull},
+			denied:  []string{client.CmdPush, client.CmdGet},
+		},
+		{
+			// The hashed token is checked only with the name.
+			meta: map[string]string{client.MetaKeyToken: "exporter-token"},
+		},
+		{
+			meta: map[string]string{client.MetaKeyToken: "exporter:wrong"},
+		},
+		{
+			meta: map[string]string{client.MetaKeyUser: "nobody", client.MetaKeyPassword: "password"},
+		},
+		{
+			meta:    map[string]string{},
+			user:    Anonymous,
+			ok:      true,
+			allowed: []string{client.CmdGet},
+			denied:  []string{client.CmdPush, client.CmdPull, client.CmdStatus},
+		},
+	}
+	for _, test := range tests {
+		user, ok := credentials.Authenticate(test.meta)
+		if user != test.user || ok != test.ok {
+			t.Errorf("%v: expected %q %t, got %q %t", test.meta, test.user, test.ok, user, ok)
+		}
+		for _, cmd := range test.allowed {
+			if !credentials.Allowed(user, cmd) {
+				t.Errorf("%s: %s is not allowed", user, cmd)
+			}
+		}
+		for _, cmd := range test.denied {
+			if credentials.Allowed(user, cmd) {
+				t.Errorf("%s: %s is allowed", user, cmd)
+			}
+		}
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for _, content := range []string{
+		"users:\n  - name: a\n    permissions: [get]\n",
+		"users:\n  - name: a\n    token: t\n    permissions: [set]\n",
+		"users:\n  - name: a\n    token: t\n  - name: a\n    token: u\n",
+		"users:\n  - name: a\n    token: t\n  - name: b\n    token: t\n",
+		"users:\n  - name: a:b\n    token: $2a$10$xNUHxvwRsfrxlAyO/LdK7uTF1HqXFQtnGJAllQnxvg1nKP3z7VmjK\n",
+		"user: []\n",
+		"users:\n  - name: a\n    password: sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8\n",
+	} {
+		if _, err := load(t, content); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/backup"
+	"github.com/tariel-x/stream/client"
+	storage "github.com/tariel-x/stream/log"
+)
+
+var backupCommands = []cli.Command{
+	{
+		Name:   "backup",
+		Usage:  "write the snapshot of the log of the running node with epochs to the backup file",
+		Action: Backup,
+		Flags: append([]cli.Flag{
+			nodeFlag,
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "-",
+				Usage: "Backup file, stdout if '-'",
+			},
+		}, connectionFlags...),
+	},
+	{
+		Name:      "restore",
+		Usage:     "restore the backup to the storage directory of the stopped node or push it to the fresh cluster",
+		ArgsUsage: "file",
+		Action:    Restore,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "dir, d",
+				Usage: "Storage directory of the file backend to restore entries with their epochs, the node must be stopped",
+			},
+			cli.StringFlag{
+				Name:  "node, n",
+				Usage: "Node of the running cluster to push values to, epochs are given by the cluster",
+			},
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Continue the interrupted restore to the node, values of the node must be the beginning of the backup",
+			},
+		}, connectionFlags...),
+	},
+}
+
+// Backup dumps the log of the node. The file is written to the temporary
+// file first, so the incomplete backup does not replace the file.
+func Backup(c *cli.Context) error {
+	nodeClient, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	hello, err := nodeClient.Hello()
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", nodeClient.Address, err), 1)
+	}
+
+	output := c.String("output")
+	var out io.Writer = os.Stdout
+	var tmp *os.File
+	if output != "-" {
+		if tmp, err = ioutil.TempFile(filepath.Dir(output), filepath.Base(output)+".tmp"); err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		out = tmp
+	}
+
+	writer, err := backup.NewWriter(out, backup.Header{
+		Created:   time.Now().UTC(),
+		Node:      hello.Node,
+		ClusterID: hello.ClusterID,
+	})
+	if err != nil {
+		return err
+	}
+	connection, err := nodeClient.Connect()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	responses, err := connection.QueryMany(&client.Dump{N: 0})
+	if err != nil {
+		return err
+	}
+	// The dump ends with OK and the number of entries.
+	entries, complete := 0, false
+	for response := responses.Next(); response != nil; response = responses.Next() {
+		if cmd, args := response.Cmd(); cmd == client.CmdOK {
+			count, err := strconv.Atoi(args)
+			if err != nil || count != entries {
+				return cli.NewExitError(fmt.Sprintf("the dump has %s entries, %d are read", args, entries), 1)
+			}
+			complete = true
+			break
+		}
+		n, v, err := response.Entry()
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(n, v); err != nil {
+			return err
+		}
+		entries++
+	}
+	if err := responses.Err(); err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", nodeClient.Address, err), 1)
+	}
+	if !complete {
+		return cli.NewExitError("the dump is not complete, the connection is closed", 1)
+	}
+	trailer, err := writer.Close()
+	if err != nil {
+		return err
+	}
+	if tmp != nil {
+		if err := tmp.Sync(); err != nil {
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), output); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "backup of %d entries, epochs %d-%d, sha256 %s\n",
+		trailer.Entries, trailer.First, trailer.Last, trailer.SHA256)
+	return nil
+}
+
+// Restore checks the whole backup first and then restores it, so the
+// corrupted backup is not restored partially.
+func Restore(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("backup file is required", 1)
+	}
+	path := c.Args().First()
+	header, trailer, err := readBackup(path, nil)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", path, err), 1)
+	}
+	source := header.Node
+	if header.ClusterID != "" {
+		source += " of cluster " + header.ClusterID
+	}
+	fmt.Fprintf(os.Stderr, "backup of %s created %s, %d entries\n",
+		source, header.Created.Format(time.RFC3339), trailer.Entries)
+
+	switch {
+	case c.String("dir") != "" && c.String("node") != "":
+		return cli.NewExitError("either dir or node is restored", 1)
+	case c.String("dir") != "":
+		err = restoreDir(path, c.String("dir"))
+	case c.String("node") != "":
+		err = restoreNode(c, path)
+	default:
+		return cli.NewExitError("dir or node is required", 1)
+	}
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+func readBackup(path string, each func(backup.Entry) error) (*backup.Header, *backup.Trailer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return backup.Read(file, each)
+}
+
+// restoreDir writes entries with their epochs by the file backend. The log
+// must be empty.
+func restoreDir(path, dir string) error {
+	lg, err := storage.OpenLog(dir, storage.FsyncNever, 0)
+	if err != nil {
+		return err
+	}
+	if count := lg.Count(); count > 0 {
+		lg.Close()
+		return fmt.Errorf("%s has %d entries, the backup is restored to the empty directory", dir, count)
+	}
+	ctx := context.Background()
+	_, trailer, err := readBackup(path, func(entry backup.Entry) error {
+		return lg.Set(ctx, entry.N, entry.Value)
+	})
+	if err != nil {
+		lg.Close()
+		return err
+	}
+	if err := lg.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d entries to %s\n", trailer.Entries, dir)
+	return nil
+}
+
+// restoreNode pushes values in the order of epochs to the cluster without
+// entries. Epochs of the backup are not kept, the cluster gives new epochs
+// to pushed values. The interrupted restore is resumed after values of the
+// node, they must be the beginning of the backup, so values pushed by
+// others in between are not mistaken for restored ones.
+func restoreNode(c *cli.Context, path string) error {
+	node := c.String("node")
+	nodeClient, err := dialNode(c, node)
+	if err != nil {
+		return err
+	}
+	response, err := nodeClient.QueryOne(&client.Status{Scope: client.StatusNode})
+	if err != nil {
+		return err
+	}
+	status, err := response.NodeStatus()
+	if err != nil {
+		return err
+	}
+	var restored []string
+	if status.Entries > 0 {
+		if !c.Bool("resume") {
+			return fmt.Errorf("%s has %d entries, the backup is restored to the fresh cluster or the interrupted restore is continued with --resume", node, status.Entries)
+		}
+		responses, err := nodeClient.QueryMany(&client.Get{N: 0})
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			restored = append(restored, strings.TrimSpace(response.Message))
+		}
+	}
+	skipped, pushed := 0, 0
+	var differs error
+	_, _, err = readBackup(path, func(entry backup.Entry) error {
+		select {
+		case <-backgroundContext.Done():
+			return errors.New("restore is interrupted")
+		default:
+		}
+		if skipped < len(restored) {
+			if restored[skipped] != entry.Value {
+				differs = fmt.Errorf("epoch %d: value %d of %s differs from the backup, it is not restored from this backup", entry.N, skipped+1, node)
+				return differs
+			}
+			skipped++
+			return nil
+		}
+		if err := validateValue(entry.Value); err != nil {
+			return fmt.Errorf("epoch %d: %v", entry.N, err)
+		}
+		if err := pushValue(nodeClient, entry.Value); err != nil {
+			return fmt.Errorf("epoch %d: %v", entry.N, err)
+		}
+		pushed++
+		return nil
+	})
+	if differs != nil {
+		return differs
+	}
+	if err != nil {
+		return fmt.Errorf("%v, %d entries are pushed, continue with --resume", err, skipped+pushed)
+	}
+	if skipped < len(restored) {
+		return fmt.Errorf("%s has %d entries, more than the backup", node, len(restored))
+	}
+	fmt.Fprintf(os.Stderr, "pushed %d entries to %s, %d entries were restored before\n", pushed, node, skipped)
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package backup writes and reads backups of the log. The backup is JSON
+// lines: the header, entries with their positions and checksums and the
+// trailer with the number of entries and the SHA-256 of entry lines, e.g.
+//
+//	{"format":"stream-backup","version":1,"created":"2020-02-01T10:00:00Z","node":"n1","cluster_id":"577e6ef1"}
+//	{"n":400,"v":"a","crc32c":3251651376}
+//	{"n":657,"v":"b","crc32c":1255491436}
+//	{"entries":2,"first":400,"last":657,"sha256":"5c6d..."}
+package backup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	storage "github.com/tariel-x/stream/log"
+)
+
+const (
+	Format  = "stream-backup"
+	Version = 1
+	// maxLine limits lines of the backup, values are limited by the line
+	// protocol anyway.
+	maxLine = 64 * 1024 * 1024
+)
+
+var (
+	ErrFormat    = errors.New("not a stream backup")
+	ErrTruncated = errors.New("backup is truncated, the trailer is missing")
+)
+
+// Header is the metadata of the backup.
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Node      string    `json:"node,omitempty"`
+	ClusterID string    `json:"cluster_id,omitempty"`
+}
+
+// Entry is the value of the log at the position.
+type Entry struct {
+	N        int    `json:"n"`
+	Value    string `json:"v"`
+	Checksum uint32 `json:"crc32c"`
+}
+
+// Trailer ends the backup, the backup without it is truncated.
+type Trailer struct {
+	Entries int    `json:"entries"`
+	First   int    `json:"first"`
+	Last    int    `json:"last"`
+	SHA256  string `json:"sha256"`
+}
+
+type Writer struct {
+	w       *bufio.Writer
+	hash    hash.Hash
+	trailer Trailer
+}
+
+// NewWriter writes the header. Format and version of the header are set
+// by the writer.
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Format, header.Version = Format, Version
+	writer := &Writer{w: bufio.NewWriter(w), hash: sha256.New()}
+	if err := writer.writeLine(header, nil); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *Writer) writeLine(v interface{}, h hash.Hash) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if h != nil {
+		h.Write(line)
+	}
+	_, err = w.w.Write(line)
+	return err
+}
+
+// Write writes the entry, positions must increase.
+func (w *Writer) Write(n int, v string) error {
+	if w.trailer.Entries > 0 && n <= w.trailer.Last {
+		return fmt.Errorf("position %d is not after %d", n, w.trailer.Last)
+	}
+	if err := w.writeLine(Entry{N: n, Value: v, Checksum: storage.Checksum(n, v)}, w.hash); err != nil {
+		return err
+	}
+	if w.trailer.Entries == 0 {
+		w.trailer.First = n
+	}
+	w.trailer.Last = n
+	w.trailer.Entries++
+	return nil
+}
+
+// Close writes the trailer and flushes the backup, the underlying writer
+// is not closed.
+func (w *Writer) Close() (*Trailer, error) {
+	trailer := w.trailer
+	trailer.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	if err := w.writeLine(trailer, nil); err != nil {
+		return nil, err
+	}
+	return &trailer, w.w.Flush()
+}
+
+// Read reads the backup and checks checksums of entries, their order and
+// the trailer. Entries are passed to each as they are read, so the backup
+// is read twice to use entries only if the whole backup is valid.
+func Read(r io.Reader, each func(Entry) error) (*Header, *Trailer, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrFormat
+	}
+	header := &Header{}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil || header.Format != Format {
+		return nil, nil, ErrFormat
+	}
+	if header.Version != Version {
+		return header, nil, fmt.Errorf("backup version %d is not supported", header.Version)
+	}
+
+	h := sha256.New()
+	var read Trailer
+	for line := 2; scanner.Scan(); line++ {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return header, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, ok := fields["sha256"]; ok {
+			trailer := &Trailer{}
+			if err := json.Unmarshal(scanner.Bytes(), trailer); err != nil {
+				return header, nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			read.SHA256 = hex.EncodeToString(h.Sum(nil))
+			if *trailer != read {
+				return header, trailer, fmt.Errorf("line %d: trailer %+v does not match entries %+v", line, *trailer, read)
+			}
+			if scanner.Scan() {
+				return header, trailer, fmt.Errorf("line %d: data after the trailer", line+1)
+			}
+			return header, trailer, scanner.Err()
+		}
+
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return header, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if entry.Checksum != storage.Checksum(entry.N, entry.Value) {
+			return header, nil, fmt.Errorf("line %d: checksum mismatch at position %d", line, entry.N)
+		}
+		if read.Entries > 0 && entry.N <= read.Last {
+			return header, nil, fmt.Errorf("line %d: position %d is not after %d", line, entry.N, read.Last)
+		}
+		h.Write(scanner.Bytes())
+		h.Write([]byte{'\n'})
+		if read.Entries == 0 {
+			read.First = entry.N
+		}
+		read.Last = entry.N
+		read.Entries++
+		if each != nil {
+			if err := each(entry); err != nil {
+				return header, nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, err
+	}
+	return header, nil, ErrTruncated
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	storage "github.com/tariel-x/stream/log"
+)
+
+func write(t *testing.T, entries []Entry) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, Header{Created: time.Now(), Node: "n1", ClusterID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := w.Write(entry.N, entry.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	expected := []Entry{
+		{N: 400, Value: "a", Checksum: storage.Checksum(400, "a")},
+		{N: 657, Value: "b c;d", Checksum: storage.Checksum(657, "b c;d")},
+	}
+	data := write(t, expected)
+
+	var actual []Entry
+	header, trailer, err := Read(bytes.NewReader(data), func(entry Entry) error {
+		actual = append(actual, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Node != "n1" || header.ClusterID != "c1" || header.Version != Version {
+		t.Errorf("unexpected header %+v", header)
+	}
+	if trailer.Entries != 2 || trailer.First != 400 || trailer.Last != 657 {
+		t.Errorf("unexpected trailer %+v", trailer)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRead_Corrupted(t *testing.T) {
+	data := string(write(t, []Entry{{N: 1, Value: "a"}, {N: 2, Value: "b"}}))
+	lines := strings.SplitAfter(data, "\n")
+
+	tests := map[string]string{
+		"empty":     "",
+		"truncated": strings.Join(lines[:3], ""),
+		"value":     strings.Replace(data, `"v":"b"`, `"v":"x"`, 1),
+		"dropped":   lines[0] + lines[2] + lines[3],
+		"order":     lines[0] + lines[2] + lines[1] + lines[3],
+	}
+	for name, corrupted := range tests {
+		if _, _, err := Read(strings.NewReader(corrupted), nil); err == nil {
+			t.Errorf("%s: the corrupted backup is read", name)
+		}
+	}
+	if _, _, err := Read(strings.NewReader(strings.Join(lines[:3], "")), nil); err != ErrTruncated {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/backup"
+	"github.com/tariel-x/stream/client"
+	storage "github.com/tariel-x/stream/log"
+)
+
+// writeBackup writes the backup of entries a, b and c at epochs 2, 5 and 9.
+func writeBackup(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "backup.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := backup.NewWriter(file, backup.Header{Created: time.Now(), Node: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []backup.Entry{{N: 2, Value: "a"}, {N: 5, Value: "b"}, {N: 9, Value: "c"}} {
+		if err := w.Write(entry.N, entry.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRestore_Dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeBackup(t, dir)
+	storageDir := filepath.Join(dir, "storage")
+
+	if _, _, err := runCommands(backupCommands, "restore", "--dir", storageDir, path); err != nil {
+		t.Fatal(err)
+	}
+	// Entries keep their epochs.
+	lg, err := storage.OpenLog(storageDir, storage.FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []backup.Entry
+	lg.Entries(context.Background(), 0, math.MaxInt64, func(n int, v string) error {
+		entries = append(entries, backup.Entry{N: n, Value: v})
+		return nil
+	})
+	lg.Close()
+	if expected := []backup.Entry{{N: 2, Value: "a"}, {N: 5, Value: "b"}, {N: 9, Value: "c"}}; !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+
+	// The log with entries is not overwritten.
+	if _, _, err := runCommands(backupCommands, "restore", "--dir", storageDir, path); err == nil || !strings.Contains(err.Error(), "empty directory") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRestore_Node(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeBackup(t, dir)
+
+	var m sync.Mutex
+	var values []string
+	node, stop := fakeNode(t, func(cmd string) []string {
+		m.Lock()
+		defer m.Unlock()
+		switch {
+		case strings.HasPrefix(cmd, client.CmdStatus):
+			return []string{`OK {"name":"n1","entries":` + strconv.Itoa(len(values)) + `}`}
+		case cmd == "GET 0":
+			return values
+		case strings.HasPrefix(cmd, client.CmdPush+" "):
+			values = append(values, strings.TrimPrefix(cmd, client.CmdPush+" "))
+			return []string{client.CmdOK}
+		}
+		return []string{"ERR BAD_ARGS unexpected " + cmd}
+	})
+	defer stop()
+	restore := func(args ...string) error {
+		_, _, err := runCommands(backupCommands, append([]string{"restore", "--node", node}, append(args, path)...)...)
+		return err
+	}
+
+	// The interrupted restore is continued after values of the node.
+	values = []string{"a"}
+	if err := restore(); err == nil || !strings.Contains(err.Error(), "--resume") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := restore("--resume"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+	if err := restore("--resume"); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 {
+		t.Errorf("restored values are pushed again, %v", values)
+	}
+
+	// Values of others are not taken for restored ones.
+	values = []string{"x"}
+	if err := restore("--resume"); err == nil || !strings.Contains(err.Error(), "differs") {
+		t.Errorf("unexpected error %v", err)
+	}
+	values = []string{"a", "b", "c", "d"}
+	if err := restore("--resume"); err == nil || !strings.Contains(err.Error(), "more than the backup") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// The fresh cluster gets all values.
+	values = nil
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
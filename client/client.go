@@ -2,6 +2,12 @@ package client
 
 import (
 	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -22,42 +28,127 @@ const (
 	CmdAccept   = "ACCEPT"
 	CmdAccepted = "ACCEPTED"
 	CmdSet      = "SET"
+	CmdJoin     = "JOIN"
+	CmdHello    = "HELLO"
+	CmdDump     = "DUMP"
+	CmdLogHash  = "LOGHASH"
 	CmdOK       = "OK"
 )
 
 const (
-	MetaKeyName = "name"
+	MetaKeyName      = "name"
+	MetaKeyTrace     = "traceparent"
+	MetaKeyTimestamp = "ts"
+	MetaKeyNonce     = "nonce"
+	MetaKeySignature = "sig"
+	MetaKeyUser      = "user"
+	MetaKeyPassword  = "password"
+	MetaKeyToken     = "token"
+	// MetaKeyNode and MetaKeyAddress are the ID and the address of the node
+	// sending peer requests.
+	MetaKeyNode    = "node"
+	MetaKeyAddress = "addr"
+	// MetaKeyCluster and MetaKeyProtocol are the cluster ID and the
+	// protocol version of the node sending peer requests.
+	MetaKeyCluster  = "cluster"
+	MetaKeyProtocol = "proto"
 )
 
 var (
 	ErrInvalidResponse = errors.New("invalid response")
 )
 
+// Logger logs the traffic of the client. Values sent and received are
+// passed in the payload field.
 type Logger interface {
-	Println(message ...interface{})
+	Debug(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
 }
 
 type NullLogger struct{}
 
-func (nl *NullLogger) Println(message ...interface{}) {}
+func (nl *NullLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nl *NullLogger) Error(msg string, keyvals ...interface{}) {}
+
+// Resolver returns the current address of the node by its ID.
+type Resolver interface {
+	Address(id string) (string, bool)
+}
 
 type Client struct {
 	Address string
-	Timeout time.Duration
-	Logger  Logger
-	Meta    map[string]string
+	// Negotiate sends HELLO before the first request, the negotiated
+	// protocol version is sent in the meta of requests.
+	Negotiate bool
+	protocol  *protocol
+	// ID is the ID of the node the client connects to. The address is
+	// resolved by the resolver on every connection if both are set.
+	ID       string
+	Resolver Resolver
+	Timeout  time.Duration
+	Logger   Logger
+	Meta     map[string]string
+	// TLS enables TLS connections if set.
+	TLS *tls.Config
+	// Secret is the cluster secret, requests are signed by it if set.
+	Secret []byte
 }
 
 func (c *Client) SetName(name string) {
 	c.Meta[MetaKeyName] = name
 }
 
+// SetNode sends the ID and the address of the node in every request, so
+// peers know the node by the ID and learn its new address.
+func (c *Client) SetNode(id, address string) {
+	c.Meta[MetaKeyName] = id
+	c.Meta[MetaKeyNode] = id
+	c.Meta[MetaKeyAddress] = address
+}
+
+// SetCluster sends the cluster ID in every request, peers reject requests
+// of other clusters.
+func (c *Client) SetCluster(id string) {
+	if id != "" {
+		c.Meta[MetaKeyCluster] = id
+	}
+}
+
+// SetCredentials authenticates requests by the user and the password.
+func (c *Client) SetCredentials(user, password string) {
+	c.Meta[MetaKeyUser] = user
+	c.Meta[MetaKeyPassword] = password
+}
+
+// SetToken authenticates requests by the token.
+func (c *Client) SetToken(token string) {
+	c.Meta[MetaKeyToken] = token
+}
+
+func (c *Client) String() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return c.Address
+}
+
+// address returns the address to connect.
+func (c *Client) address() string {
+	if c.ID != "" && c.Resolver != nil {
+		if address, ok := c.Resolver.Address(c.ID); ok {
+			return address
+		}
+	}
+	return c.Address
+}
+
 func New(address string, timeout *time.Duration) (*Client, error) {
 	client := &Client{
-		Address: address,
-		Timeout: time.Second * 20,
-		Logger:  &NullLogger{},
-		Meta:    map[string]string{},
+		Address:  address,
+		protocol: &protocol{},
+		Timeout:  time.Second * 20,
+		Logger:   &NullLogger{},
+		Meta:     map[string]string{},
 	}
 	if timeout != nil {
 		client.Timeout = *timeout
@@ -68,16 +159,36 @@ func New(address string, timeout *time.Duration) (*Client, error) {
 type Connection struct {
 	Client     *Client
 	connection net.Conn
+	reader     *bufio.Reader
 }
 
 func (c *Client) Connect() (*Connection, error) {
-	conn, err := net.DialTimeout("tcp", c.Address, c.Timeout)
+	if c.Negotiate && c.protocol.Version() == 0 {
+		if _, err := c.Hello(); err != nil {
+			return nil, err
+		}
+	}
+	return c.dial()
+}
+
+func (c *Client) dial() (*Connection, error) {
+	var conn net.Conn
+	var err error
+	address := c.address()
+	if c.TLS != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: c.Timeout}, "tcp", address, c.TLS)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, c.Timeout)
+	}
 	if err != nil {
+		// The node may be restarted with the other version.
+		c.protocol.Reset()
 		return nil, err
 	}
 	return &Connection{
 		Client:     c,
 		connection: conn,
+		reader:     bufio.NewReader(conn),
 	}, nil
 }
 
@@ -89,35 +200,105 @@ type Request interface {
 	String() string
 }
 
-func (c *Connection) write(message string) error {
-	msgparts := make([]string, 0, len(c.Client.Meta)+1)
-	msgparts = append(msgparts, message)
+// MetaRequest is the request with the meta sent in addition to the
+// client meta.
+type MetaRequest struct {
+	Request
+	Meta map[string]string
+}
+
+// WithMeta adds the meta to the request. Empty values are skipped.
+func WithMeta(r Request, meta map[string]string) Request {
+	return &MetaRequest{
+		Request: r,
+		Meta:    meta,
+	}
+}
+
+func (c *Connection) write(r Request) error {
+	message := r.String()
+	var requestMeta map[string]string
+	if metaRequest, ok := r.(*MetaRequest); ok {
+		requestMeta = metaRequest.Meta
+	}
+	meta := make(map[string]string, len(c.Client.Meta)+len(requestMeta)+4)
 	for key, value := range c.Client.Meta {
+		meta[key] = value
+	}
+	for key, value := range requestMeta {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+	if version := c.Client.protocol.Version(); version > 0 {
+		meta[MetaKeyProtocol] = strconv.Itoa(version)
+	}
+	if c.Client.Secret != nil {
+		nonce := make([]byte, 12)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		meta[MetaKeyTimestamp] = strconv.FormatInt(time.Now().Unix(), 10)
+		meta[MetaKeyNonce] = hex.EncodeToString(nonce)
+		meta[MetaKeySignature] = Sign(c.Client.Secret, message, meta)
+	}
+	msgparts := make([]string, 0, len(meta)+1)
+	msgparts = append(msgparts, message)
+	for key, value := range meta {
 		msgparts = append(msgparts, fmt.Sprintf("%s=%s", key, value))
 	}
 	_, err := fmt.Fprint(c.connection, strings.Join(msgparts, ";")+"\n")
 	return err
 }
 
+// SignedMeta are keys of the meta covered by the signature of the request.
+var SignedMeta = []string{MetaKeyTimestamp, MetaKeyNonce, MetaKeyNode, MetaKeyAddress, MetaKeyCluster, MetaKeyProtocol}
+
+// Sign makes the HMAC-SHA256 signature of the message and SignedMeta of the
+// request by the cluster secret. The time and the nonce limit replays.
+func Sign(secret []byte, message string, meta map[string]string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprint(mac, message)
+	for _, key := range SignedMeta {
+		fmt.Fprintf(mac, "\n%s=%s", key, meta[key])
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Connection) logSend(message string) {
+	cmd, args := (&Response{Message: message}).Cmd()
+	c.Client.Logger.Debug("send", "address", c.Client.Address, "cmd", cmd, "payload", args)
+}
+
+func (c *Connection) logReceive(message string) {
+	c.Client.Logger.Debug("receive", "address", c.Client.Address, "payload", strings.TrimSpace(message))
+}
+
 func (c *Connection) Exec(r Request) error {
-	message := r.String()
-	c.Client.Logger.Println("this -> ", c.Client.Address, message)
-	err := c.write(message)
+	c.logSend(r.String())
+	err := c.write(r)
 	return err
 }
 
 func (c *Connection) QueryOne(r Request) (*Response, error) {
-	message := r.String()
-	c.Client.Logger.Println("this -> ", c.Client.Address, message)
-	if err := c.write(message); err != nil {
+	c.logSend(r.String())
+	if err := c.write(r); err != nil {
 		return nil, err
 	}
-	nodeResponse, err := bufio.NewReader(c.connection).ReadString('\n')
+	nodeResponse, err := c.reader.ReadString('\n')
 	if err != nil {
 		return nil, err
 	}
-	c.Client.Logger.Println("this <- ", c.Client.Address, nodeResponse)
-	return &Response{Message: nodeResponse}, nil
+	c.logReceive(nodeResponse)
+	response := &Response{Message: nodeResponse}
+	if err := response.Err(); err != nil {
+		if replyErr, ok := err.(*Error); ok && replyErr.Code == CodeIncompatible {
+			// The version is negotiated again by the next request.
+			c.Client.protocol.Reset()
+		}
+		return nil, err
+	}
+	return response, nil
 }
 
 type Responses struct {
@@ -146,29 +327,36 @@ func (r *Responses) Err() error {
 }
 
 func (c *Connection) QueryMany(r Request) (*Responses, error) {
-	message := r.String()
-	c.Client.Logger.Println("this -> ", c.Client.Address, message)
-	if err := c.write(message); err != nil {
+	c.logSend(r.String())
+	if err := c.write(r); err != nil {
 		return nil, err
 	}
 	responses := &Responses{
 		responses: make(chan *Response),
-		errors:    make(chan error),
+		errors:    make(chan error, 1),
 	}
 	go func() {
 		defer close(responses.responses)
 		defer close(responses.errors)
 		for {
-			nodeResponse, err := bufio.NewReader(c.connection).ReadString('\n')
+			nodeResponse, err := c.reader.ReadString('\n')
 			if err == io.EOF {
 				break
 			}
+			// The error is kept until Err is called and the reading stops,
+			// e.g. when the connection is closed.
 			if err != nil {
 				responses.errors <- err
-				continue
+				break
 			}
-			responses.responses <- &Response{Message: nodeResponse}
-			c.Client.Logger.Println("this <- ", c.Client.Address, nodeResponse)
+			c.logReceive(nodeResponse)
+			response := &Response{Message: nodeResponse}
+			// The error reply ends the replies.
+			if err := response.Err(); err != nil {
+				responses.errors <- err
+				break
+			}
+			responses.responses <- response
 		}
 	}()
 	return responses, nil
@@ -214,6 +402,9 @@ func (c *Client) QueryMany(r Request) ([]*Response, error) {
 		}
 		responses = append(responses, response)
 	}
+	if err := responsesc.Err(); err != nil {
+		return nil, err
+	}
 	return responses, nil
 }
 
@@ -243,6 +434,9 @@ func (p *Push) String() string {
 }
 
 func (r *Response) Ok() (bool, error) {
+	if err := r.Err(); err != nil {
+		return false, err
+	}
 	cmd, _ := r.Cmd()
 	if cmd != CmdOK && cmd != CmdRefuse {
 		return false, ErrInvalidResponse
@@ -259,6 +453,28 @@ func (p *Get) String() string {
 	return fmt.Sprintf("%s %d", CmdGet, p.N)
 }
 
+// Dump reads entries of the log with their epochs from the epoch N.
+type Dump struct {
+	N int
+}
+
+func (d *Dump) String() string {
+	return fmt.Sprintf("%s %d", CmdDump, d.N)
+}
+
+// Entry parses the "<epoch> <value>" reply to DUMP.
+func (r *Response) Entry() (int, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(r.Message), " ", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidResponse
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", ErrInvalidResponse
+	}
+	return n, parts[1], nil
+}
+
 type Pull struct {
 	N int
 }
@@ -267,6 +483,104 @@ func (p *Pull) String() string {
 	return fmt.Sprintf("%s %d", CmdPull, p.N)
 }
 
+// Scopes of the STATUS request. The empty scope is the node with its
+// peers.
+const (
+	StatusNode    = "NODE"
+	StatusCluster = "CLUSTER"
+)
+
+type Status struct {
+	Scope string
+}
+
+func (s *Status) String() string {
+	if s.Scope == "" {
+		return CmdStatus
+	}
+	return fmt.Sprintf("%s %s", CmdStatus, s.Scope)
+}
+
+// NodeStatus is the state of the node replied to STATUS.
+type NodeStatus struct {
+	Name      string       `json:"name"`
+	Address   string       `json:"address,omitempty"`
+	Role      string       `json:"role"`
+	Ballot    int          `json:"ballot"`
+	Committed int          `json:"committed"`
+	Entries   uint64       `json:"entries"`
+	Bytes     uint64       `json:"bytes"`
+	Uptime    float64      `json:"uptime_seconds"`
+	Version   string       `json:"version"`
+	Protocols []int        `json:"protocols,omitempty"`
+	Peers     []PeerStatus `json:"peers,omitempty"`
+	// Error is set if the node has not replied to STATUS CLUSTER.
+	Error string `json:"error,omitempty"`
+}
+
+// PeerStatus is the state of the peer as seen by the node. Lag is the
+// number of entries the peer is behind the node.
+type PeerStatus struct {
+	Name      string `json:"name"`
+	Address   string `json:"address,omitempty"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	Committed int    `json:"committed"`
+	Entries   uint64 `json:"entries"`
+	Lag       uint64 `json:"lag"`
+}
+
+// ClusterStatus aggregates statuses of all nodes replied to STATUS CLUSTER.
+type ClusterStatus struct {
+	Nodes     []NodeStatus `json:"nodes"`
+	Size      int          `json:"size"`
+	Reachable int          `json:"reachable"`
+	Quorum    bool         `json:"quorum"`
+	Committed int          `json:"committed"`
+	MaxLag    uint64       `json:"max_lag"`
+}
+
+func (r *Response) NodeStatus() (*NodeStatus, error) {
+	status := &NodeStatus{}
+	return status, r.status(status)
+}
+
+func (r *Response) ClusterStatus() (*ClusterStatus, error) {
+	status := &ClusterStatus{}
+	return status, r.status(status)
+}
+
+func (r *Response) status(status interface{}) error {
+	cmd, args := r.Cmd()
+	if cmd != CmdOK || args == "" {
+		return ErrInvalidResponse
+	}
+	return json.Unmarshal([]byte(args), status)
+}
+
+// Join asks the node of the cluster for the cluster ID and nodes.
+type Join struct {
+	ID      string
+	Address string
+}
+
+func (j *Join) String() string {
+	return fmt.Sprintf("%s %s %s", CmdJoin, j.ID, j.Address)
+}
+
+// ClusterInfo is replied to JOIN. Nodes are IDs and addresses of nodes
+// known to the node including itself.
+type ClusterInfo struct {
+	ClusterID string            `json:"cluster_id"`
+	Protocol  int               `json:"protocol"`
+	Nodes     map[string]string `json:"nodes"`
+}
+
+func (r *Response) ClusterInfo() (*ClusterInfo, error) {
+	info := &ClusterInfo{}
+	return info, r.status(info)
+}
+
 type Prepare struct {
 	N int
 }
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CmdErr starts the error reply: ERR <code> <message>.
+const CmdErr = "ERR"
+
+// Codes of error replies, they are stable between versions.
+const (
+	CodeUnknownCommand   = "UNKNOWN_COMMAND"
+	CodeBadArgs          = "BAD_ARGS"
+	CodeQuorumFailed     = "QUORUM_FAILED"
+	CodeNotLeader        = "NOT_LEADER"
+	CodeOutOfRange       = "OUT_OF_RANGE"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodePermissionDenied = "PERMISSION_DENIED"
+	CodeThrottled        = "THROTTLED"
+	CodeUnavailable      = "UNAVAILABLE"
+	CodeInternal         = "INTERNAL"
+	// CodeWrongCluster and CodeIncompatible reject peers of other clusters
+	// and peers speaking unsupported versions of the protocol.
+	CodeWrongCluster = "WRONG_CLUSTER"
+	CodeIncompatible = "INCOMPATIBLE"
+)
+
+// ThrottledPrefix starts the message of THROTTLED, it is followed by the
+// time to retry after, e.g. "throttled, retry after 250ms".
+const ThrottledPrefix = "throttled, retry after "
+
+// Error is the error reply of the node. Errors with the same code are
+// equal for errors.Is, e.g. errors.Is(err, ErrQuorumFailed).
+type Error struct {
+	Code    string
+	Message string
+}
+
+var (
+	ErrUnknownCommand = &Error{Code: CodeUnknownCommand, Message: "unknown command"}
+	ErrBadArgs        = &Error{Code: CodeBadArgs, Message: "bad arguments"}
+	ErrQuorumFailed   = &Error{Code: CodeQuorumFailed, Message: "quorum failed"}
+	// ErrNotLeader is reserved for nodes which do not commit values, every
+	// Paxos node is the proposer now.
+	ErrNotLeader        = &Error{Code: CodeNotLeader, Message: "not leader"}
+	ErrOutOfRange       = &Error{Code: CodeOutOfRange, Message: "out of range"}
+	ErrUnauthorized     = &Error{Code: CodeUnauthorized, Message: "unauthorized"}
+	ErrPermissionDenied = &Error{Code: CodePermissionDenied, Message: "permission denied"}
+	ErrThrottled        = &Error{Code: CodeThrottled, Message: "throttled"}
+	ErrUnavailable      = &Error{Code: CodeUnavailable, Message: "unavailable"}
+	ErrInternal         = &Error{Code: CodeInternal, Message: "internal error"}
+	ErrWrongCluster     = &Error{Code: CodeWrongCluster, Message: "wrong cluster"}
+	ErrIncompatible     = &Error{Code: CodeIncompatible, Message: "incompatible protocol"}
+	// ErrClosing ends PULL when the node shuts down.
+	ErrClosing = &Error{Code: CodeUnavailable, Message: "node is shutting down"}
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is compares codes of errors.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Reply formats the error as the reply line.
+func (e *Error) Reply() string {
+	return fmt.Sprintf("%s %s %s", CmdErr, e.Code, e.Message)
+}
+
+// RetryAfter returns the time to retry after if the request is throttled.
+func (e *Error) RetryAfter() (time.Duration, bool) {
+	if e.Code != CodeThrottled || !strings.HasPrefix(e.Message, ThrottledPrefix) {
+		return 0, false
+	}
+	retryAfter, err := time.ParseDuration(strings.TrimPrefix(e.Message, ThrottledPrefix))
+	if err != nil {
+		return 0, false
+	}
+	return retryAfter, true
+}
+
+// Err returns the error of the ERR reply or nil. Values never contain
+// spaces, so the reply is not the value.
+func (r *Response) Err() error {
+	cmd, args := r.Cmd()
+	if cmd != CmdErr {
+		return nil
+	}
+	parts := strings.SplitN(args, " ", 2)
+	if parts[0] == "" {
+		return nil
+	}
+	err := &Error{Code: parts[0]}
+	if len(parts) == 2 {
+		err.Message = parts[1]
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResponse_Err(t *testing.T) {
+	tests := []struct {
+		message string
+		err     *Error
+	}{
+		{message: "OK\n"},
+		{message: "ERR\n"},
+		{message: "value\n"},
+		{message: "ERR QUORUM_FAILED quorum failed\n", err: &Error{Code: CodeQuorumFailed, Message: "quorum failed"}},
+		{message: "ERR BAD_ARGS invalid epoch \"x\"\n", err: &Error{Code: CodeBadArgs, Message: "invalid epoch \"x\""}},
+		{message: "ERR INTERNAL\n", err: &Error{Code: CodeInternal}},
+	}
+	for _, test := range tests {
+		err := (&Response{Message: test.message}).Err()
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", test.message, err)
+			}
+			continue
+		}
+		replyErr, ok := err.(*Error)
+		if !ok || *replyErr != *test.err {
+			t.Errorf("%q: expected %#v, got %#v", test.message, test.err, err)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	err := (&Response{Message: ErrQuorumFailed.Reply()}).Err()
+	if !errors.Is(err, ErrQuorumFailed) {
+		t.Errorf("%v is not ErrQuorumFailed", err)
+	}
+	if errors.Is(err, ErrBadArgs) {
+		t.Errorf("%v is ErrBadArgs", err)
+	}
+	if ok, err := (&Response{Message: "ERR UNAUTHORIZED unauthorized"}).Ok(); ok || !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %t %v", ok, err)
+	}
+
+	throttled := &Error{Code: CodeThrottled, Message: ThrottledPrefix + "250ms"}
+	if retryAfter, ok := throttled.RetryAfter(); !ok || retryAfter != 250*time.Millisecond {
+		t.Errorf("expected retry after 250ms, got %s %t", retryAfter, ok)
+	}
+	if _, ok := ErrInternal.RetryAfter(); ok {
+		t.Error("INTERNAL is throttled")
+	}
+}
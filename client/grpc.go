@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/tariel-x/stream/api"
+)
+
+// GRPCClient is the client of the gRPC API. The meta is sent as the gRPC
+// metadata of every call.
+type GRPCClient struct {
+	Address string
+	Meta    map[string]string
+	conn    *grpc.ClientConn
+	api     api.StreamClient
+}
+
+// NewGRPC connects to the gRPC API, TLS is used if the config is set.
+// Options are added to the dial options, e.g. the dialer.
+func NewGRPC(address string, timeout *time.Duration, tlsConfig *tls.Config, options ...grpc.DialOption) (*GRPCClient, error) {
+	dialTimeout := time.Second * 20
+	if timeout != nil {
+		dialTimeout = *timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if tlsConfig != nil {
+		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+	options = append(options, grpc.WithBlock())
+	conn, err := grpc.DialContext(ctx, address, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{
+		Address: address,
+		Meta:    map[string]string{},
+		conn:    conn,
+		api:     api.NewStreamClient(conn),
+	}, nil
+}
+
+func (c *GRPCClient) SetName(name string) {
+	c.Meta[MetaKeyName] = name
+}
+
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GRPCClient) context(ctx context.Context) context.Context {
+	keyvals := make([]string, 0, len(c.Meta)*2)
+	for key, value := range c.Meta {
+		keyvals = append(keyvals, key, value)
+	}
+	return metadata.AppendToOutgoingContext(ctx, keyvals...)
+}
+
+func (c *GRPCClient) Push(ctx context.Context, v string) error {
+	_, err := c.api.Push(c.context(ctx), &api.PushRequest{Value: v})
+	return err
+}
+
+// PushBatch pushes values in the order and returns the number of pushed
+// values.
+func (c *GRPCClient) PushBatch(ctx context.Context, values []string) (int, error) {
+	reply, err := c.api.PushBatch(c.context(ctx), &api.PushBatchRequest{Values: values})
+	if err != nil {
+		return 0, err
+	}
+	return int(reply.GetPushed()), nil
+}
+
+func (c *GRPCClient) Get(ctx context.Context, from int) ([]string, error) {
+	stream, err := c.api.Get(c.context(ctx), &api.GetRequest{From: int64(from)})
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, entry.GetValue())
+	}
+}
+
+// Entries are values received by Pull.
+type Entries struct {
+	stream api.Stream_PullClient
+	err    error
+}
+
+// Next blocks until the next value. False is returned when the stream is
+// over, Err returns the reason.
+func (e *Entries) Next() (string, bool) {
+	if e.err != nil {
+		return "", false
+	}
+	entry, err := e.stream.Recv()
+	if err != nil {
+		e.err = err
+		return "", false
+	}
+	return entry.GetValue(), true
+}
+
+func (e *Entries) Err() error {
+	if e.err == io.EOF {
+		return nil
+	}
+	return e.err
+}
+
+// Pull reads the log from the epoch and follows new values until the
+// context is done.
+func (c *GRPCClient) Pull(ctx context.Context, from int) (*Entries, error) {
+	stream, err := c.api.Pull(c.context(ctx), &api.PullRequest{From: int64(from)})
+	if err != nil {
+		return nil, err
+	}
+	return &Entries{stream: stream}, nil
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Versions of peer commands supported by the node. Nodes speak the highest
+// version supported by both, so the cluster is upgraded node by node while
+// the new version supports the previous one.
+const (
+	MinProtocolVersion = 1
+	ProtocolVersion    = 1
+)
+
+// Features are optional commands of the node, clients check them in the
+// HELLO reply before using them.
+const (
+	FeatureJoin    = "join"
+	FeatureLogHash = "loghash"
+)
+
+// Features are features supported by the node.
+var Features = []string{FeatureJoin, FeatureLogHash}
+
+// ProtocolVersions returns supported versions from the oldest.
+func ProtocolVersions() []int {
+	versions := make([]int, 0, ProtocolVersion-MinProtocolVersion+1)
+	for version := MinProtocolVersion; version <= ProtocolVersion; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// SupportedVersion returns true if the node speaks the version.
+func SupportedVersion(version int) bool {
+	return version >= MinProtocolVersion && version <= ProtocolVersion
+}
+
+// CommonVersion returns the highest of versions supported by the node.
+func CommonVersion(versions []int) (int, bool) {
+	common := 0
+	for _, version := range versions {
+		if SupportedVersion(version) && version > common {
+			common = version
+		}
+	}
+	return common, common > 0
+}
+
+// Hello advertises supported versions and features, e.g.
+// HELLO 1,2 join,loghash.
+type Hello struct {
+	Versions []int
+	Features []string
+}
+
+func (h *Hello) String() string {
+	versions := make([]string, 0, len(h.Versions))
+	for _, version := range h.Versions {
+		versions = append(versions, strconv.Itoa(version))
+	}
+	message := fmt.Sprintf("%s %s", CmdHello, strings.Join(versions, ","))
+	if len(h.Features) > 0 {
+		message += " " + strings.Join(h.Features, ",")
+	}
+	return message
+}
+
+// HelloReply is the version chosen by the node, its supported versions and
+// features.
+type HelloReply struct {
+	Version   int      `json:"version"`
+	Versions  []int    `json:"versions"`
+	Features  []string `json:"features"`
+	Node      string   `json:"node,omitempty"`
+	ClusterID string   `json:"cluster_id,omitempty"`
+}
+
+// Has returns true if the node supports the feature.
+func (h *HelloReply) Has(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Response) HelloReply() (*HelloReply, error) {
+	reply := &HelloReply{}
+	return reply, r.status(reply)
+}
+
+// isOK checks that the reply is OK, other replies without ERR are not
+// replies of HELLO.
+func (r *Response) isOK() bool {
+	cmd, _ := r.Cmd()
+	return cmd == CmdOK
+}
+
+// protocol is the version negotiated with the node, zero is not
+// negotiated.
+type protocol struct {
+	m     sync.RWMutex
+	hello *HelloReply
+}
+
+func (p *protocol) Version() int {
+	if p == nil {
+		return 0
+	}
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.hello == nil {
+		return 0
+	}
+	return p.hello.Version
+}
+
+func (p *protocol) set(hello *HelloReply) {
+	if p == nil {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.hello = hello
+}
+
+func (p *protocol) Reset() {
+	p.set(nil)
+}
+
+// Hello negotiates the protocol version with the node. Nodes without HELLO
+// speak the first version, they reply ERR UNKNOWN_COMMAND or, before
+// error codes, the bare error message.
+func (c *Client) Hello() (*HelloReply, error) {
+	connection, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	response, err := connection.QueryOne(&Hello{Versions: ProtocolVersions(), Features: Features})
+	replyErr, isReply := err.(*Error)
+	if isReply && replyErr.Code == CodeUnknownCommand || err == nil && !response.isOK() {
+		hello := &HelloReply{Version: 1, Versions: []int{1}}
+		c.protocol.set(hello)
+		return hello, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hello, err := response.HelloReply()
+	if err != nil {
+		return nil, err
+	}
+	if !SupportedVersion(hello.Version) {
+		return nil, &Error{
+			Code:    CodeIncompatible,
+			Message: fmt.Sprintf("node %s speaks protocol version %d", c, hello.Version),
+		}
+	}
+	c.protocol.set(hello)
+	return hello, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCommonVersion(t *testing.T) {
+	if version, ok := CommonVersion([]int{ProtocolVersion + 1, ProtocolVersion}); !ok || version != ProtocolVersion {
+		t.Errorf("expected %d, got %d %t", ProtocolVersion, version, ok)
+	}
+	if version, ok := CommonVersion([]int{MinProtocolVersion - 1, ProtocolVersion + 1}); ok {
+		t.Errorf("unexpected common version %d", version)
+	}
+}
+
+func TestHello(t *testing.T) {
+	hello := &Hello{Versions: []int{1, 2}, Features: []string{FeatureJoin, "loghash"}}
+	if message := hello.String(); message != "HELLO 1,2 join,loghash" {
+		t.Errorf("unexpected message %q", message)
+	}
+	reply, err := (&Response{Message: `OK {"version":1,"versions":[1],"features":["join"]}`}).HelloReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Version != 1 || !reply.Has(FeatureJoin) || reply.Has("loghash") {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+}
+
+// fakeNode replies requests by the command, one request per connection.
+func fakeNode(t *testing.T, reply func(cmd string) []string) (string, func()) {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := socket.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				cmd := strings.SplitN(strings.TrimSpace(line), ";", 2)[0]
+				for _, message := range reply(cmd) {
+					conn.Write([]byte(message + "\n"))
+				}
+			}()
+		}
+	}()
+	return socket.Addr().String(), func() { socket.Close() }
+}
+
+func TestClient_Hello(t *testing.T) {
+	tests := []struct {
+		reply   string
+		version int
+		code    string
+	}{
+		{`OK {"version":1,"versions":[1],"features":["join"]}`, 1, ""},
+		// Nodes without HELLO speak the first version.
+		{"ERR UNKNOWN_COMMAND unknown cmd", 1, ""},
+		{"unknown cmd", 1, ""},
+		{`OK {"version":99,"versions":[99]}`, 0, CodeIncompatible},
+		{"ERR INCOMPATIBLE no common protocol version", 0, CodeIncompatible},
+	}
+	for _, test := range tests {
+		reply := test.reply
+		address, stop := fakeNode(t, func(string) []string { return []string{reply} })
+		c, _ := New(address, nil)
+		hello, err := c.Hello()
+		stop()
+		if test.code != "" {
+			if replyErr, ok := err.(*Error); !ok || replyErr.Code != test.code {
+				t.Errorf("%q: expected %s, got %v", test.reply, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.reply, err)
+			continue
+		}
+		if hello.Version != test.version || c.protocol.Version() != test.version {
+			t.Errorf("%q: expected version %d, got %d", test.reply, test.version, c.protocol.Version())
+		}
+	}
+}
+
+func TestConnection_QueryOne_Incompatible(t *testing.T) {
+	address, stop := fakeNode(t, func(string) []string {
+		return []string{"ERR INCOMPATIBLE protocol version 1 is not supported"}
+	})
+	defer stop()
+	c, _ := New(address, nil)
+	c.protocol.set(&HelloReply{Version: 1})
+	connection, err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.QueryOne(&Get{N: 0}); err == nil {
+		t.Fatal("expected the error")
+	}
+	// The version is negotiated again by the next request.
+	if version := c.protocol.Version(); version != 0 {
+		t.Errorf("version %d is not reset", version)
+	}
+}
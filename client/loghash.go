@@ -0,0 +1,171 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// MaxLogHashRanges limits ranges of one LOGHASH.
+const MaxLogHashRanges = 1024
+
+// LogHash asks hashes of entries of the log in Ranges ranges of epochs from
+// From to To, e.g. LOGHASH 0 999 10.
+type LogHash struct {
+	From   int
+	To     int
+	Ranges int
+}
+
+func (l *LogHash) String() string {
+	return fmt.Sprintf("%s %d %d %d", CmdLogHash, l.From, l.To, l.Ranges)
+}
+
+// HashRange is the hash of entries of the log in epochs From-To. Hashes are
+// rolling: the hash of the range covers the hash of the previous range, so
+// the first range with different hashes has the first different entry.
+type HashRange struct {
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+	Entries int    `json:"entries"`
+	Hash    string `json:"hash"`
+}
+
+type LogHashReply struct {
+	Ranges []HashRange `json:"ranges"`
+}
+
+func (r *Response) LogHashReply() (*LogHashReply, error) {
+	reply := &LogHashReply{}
+	return reply, r.status(reply)
+}
+
+// RangeHasher computes rolling hashes of ranges of epochs of equal width.
+// Entries are added in the order of epochs, entries out of ranges are
+// skipped.
+type RangeHasher struct {
+	ranges  []HashRange
+	width   uint64
+	current int
+	hash    hash.Hash
+}
+
+// NewRangeHasher splits epochs from-to to at most ranges ranges, from is
+// not negative and to is not less than from. Epochs up to the maximal int
+// are counted in uint64, so the width does not overflow.
+func NewRangeHasher(from, to, ranges int) *RangeHasher {
+	h := &RangeHasher{hash: sha256.New()}
+	if from < 0 || to < from || ranges < 1 {
+		return h
+	}
+	h.width = uint64(to-from)/uint64(ranges) + 1
+	for i := uint64(0); i < uint64(ranges); i++ {
+		offset := i * h.width
+		if offset > uint64(to-from) {
+			break
+		}
+		start, end := from+int(offset), to
+		if last := offset + h.width - 1; last < uint64(to-from) {
+			end = from + int(last)
+		}
+		h.ranges = append(h.ranges, HashRange{From: start, To: end})
+	}
+	return h
+}
+
+func (h *RangeHasher) Add(n int, v string) {
+	if len(h.ranges) == 0 || n < h.ranges[0].From || n > h.ranges[len(h.ranges)-1].To {
+		return
+	}
+	for i := int(uint64(n-h.ranges[0].From) / h.width); h.current < i; {
+		h.next()
+	}
+	buf := make([]byte, 12+len(v))
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(v)))
+	copy(buf[12:], v)
+	h.hash.Write(buf)
+	h.ranges[h.current].Entries++
+}
+
+// next ends the current range, the hash of the next one starts with it.
+func (h *RangeHasher) next() {
+	sum := h.hash.Sum(nil)
+	h.ranges[h.current].Hash = hex.EncodeToString(sum)
+	h.hash = sha256.New()
+	h.hash.Write(sum)
+	h.current++
+}
+
+// Ranges ends all ranges and returns them.
+func (h *RangeHasher) Ranges() []HashRange {
+	for h.current < len(h.ranges) {
+		h.next()
+	}
+	return h.ranges
+}
+
+// Divergence is the first epoch where logs of nodes differ and ranges of
+// this epoch of every node.
+type Divergence struct {
+	N      int         `json:"n"`
+	Ranges []HashRange `json:"ranges"`
+}
+
+// ErrInconclusive is returned by FindDivergence if ranges which differed
+// are equal in the next round, entries are set while logs are compared.
+var ErrInconclusive = errors.New("logs changed while they were compared, the comparison is inconclusive, retry")
+
+// FindDivergence compares logs of nodes in epochs from-to by LOGHASH. The
+// first range with different hashes is split again until it is one epoch.
+// It returns nil if logs are equal.
+func FindDivergence(nodes []*Client, from, to, ranges int) (*Divergence, error) {
+	if ranges < 2 || ranges > MaxLogHashRanges {
+		return nil, fmt.Errorf("ranges must be from 2 to %d", MaxLogHashRanges)
+	}
+	for round := 0; ; round++ {
+		replies := make([][]HashRange, 0, len(nodes))
+		for _, node := range nodes {
+			response, err := node.QueryOne(&LogHash{From: from, To: to, Ranges: ranges})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", node, err)
+			}
+			reply, err := response.LogHashReply()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", node, err)
+			}
+			if len(replies) > 0 && len(reply.Ranges) != len(replies[0]) {
+				return nil, fmt.Errorf("%s: %d ranges, expected %d", node, len(reply.Ranges), len(replies[0]))
+			}
+			replies = append(replies, reply.Ranges)
+		}
+
+		differs := -1
+		for i := 0; i < len(replies[0]) && differs < 0; i++ {
+			for _, reply := range replies[1:] {
+				if reply[i].Hash != replies[0][i].Hash {
+					differs = i
+					break
+				}
+			}
+		}
+		if differs < 0 {
+			if round > 0 {
+				// Entries which differed are set since the previous round.
+				return nil, ErrInconclusive
+			}
+			return nil, nil
+		}
+		if replies[0][differs].From == replies[0][differs].To {
+			divergence := &Divergence{N: replies[0][differs].From}
+			for _, reply := range replies {
+				divergence.Ranges = append(divergence.Ranges, reply[differs])
+			}
+			return divergence, nil
+		}
+		from, to = replies[0][differs].From, replies[0][differs].To
+	}
+}
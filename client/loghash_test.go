@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func hashRanges(entries map[int]string, from, to, ranges int) []HashRange {
+	hasher := NewRangeHasher(from, to, ranges)
+	for n := from; n <= to; n++ {
+		if v, ok := entries[n]; ok {
+			hasher.Add(n, v)
+		}
+	}
+	return hasher.Ranges()
+}
+
+func TestRangeHasher(t *testing.T) {
+	entries := map[int]string{3: "a", 12: "b", 25: "c", 27: "d"}
+	expected := hashRanges(entries, 0, 29, 3)
+	if len(expected) != 3 || expected[1].From != 10 || expected[1].To != 19 || expected[2].Entries != 2 {
+		t.Fatalf("unexpected ranges %+v", expected)
+	}
+	if actual := hashRanges(entries, 0, 29, 3); actual[2].Hash != expected[2].Hash {
+		t.Errorf("hashes of equal entries differ")
+	}
+
+	// Hashes are rolling, ranges after the different entry differ.
+	entries[12] = "x"
+	actual := hashRanges(entries, 0, 29, 3)
+	if actual[0].Hash != expected[0].Hash || actual[1].Hash == expected[1].Hash || actual[2].Hash == expected[2].Hash {
+		t.Errorf("unexpected hashes %+v", actual)
+	}
+
+	if ranges := hashRanges(entries, 5, 6, 10); len(ranges) != 2 || ranges[1].From != 6 {
+		t.Errorf("unexpected ranges %+v", ranges)
+	}
+}
+
+func TestRangeHasher_Wide(t *testing.T) {
+	tests := []struct {
+		from, to, ranges int
+		expected         []HashRange
+	}{
+		{1, math.MaxInt64, 1, []HashRange{{From: 1, To: math.MaxInt64}}},
+		{0, math.MaxInt64, 2, []HashRange{{From: 0, To: math.MaxInt64 / 2}, {From: math.MaxInt64/2 + 1, To: math.MaxInt64}}},
+	}
+	for _, test := range tests {
+		hasher := NewRangeHasher(test.from, test.to, test.ranges)
+		hasher.Add(math.MaxInt64, "a")
+		ranges := hasher.Ranges()
+		if len(ranges) != len(test.expected) {
+			t.Fatalf("%d-%d: unexpected ranges %+v", test.from, test.to, ranges)
+		}
+		for i := range ranges {
+			if ranges[i].From != test.expected[i].From || ranges[i].To != test.expected[i].To {
+				t.Errorf("%d-%d: expected %+v, got %+v", test.from, test.to, test.expected[i], ranges[i])
+			}
+		}
+		if last := ranges[len(ranges)-1]; last.Entries != 1 {
+			t.Errorf("%d-%d: the last entry is not added to %+v", test.from, test.to, last)
+		}
+	}
+}
+
+// hashNode replies LOGHASH by hashes of entries, the entry at changed
+// epoch is changed to x after the first request.
+func hashNode(t *testing.T, entries map[int]string, changed int) (*Client, func()) {
+	m := sync.Mutex{}
+	requests := 0
+	address, stop := fakeNode(t, func(cmd string) []string {
+		m.Lock()
+		defer m.Unlock()
+		var from, to, ranges int
+		fmt.Sscanf(cmd, CmdLogHash+" %d %d %d", &from, &to, &ranges)
+		if requests > 0 && changed >= 0 {
+			entries[changed] = "x"
+		}
+		requests++
+		encoded, _ := json.Marshal(LogHashReply{Ranges: hashRanges(entries, from, to, ranges)})
+		return []string{CmdOK + " " + string(encoded)}
+	})
+	c, _ := New(address, nil)
+	return c, stop
+}
+
+func TestFindDivergence(t *testing.T) {
+	a, stopA := hashNode(t, map[int]string{3: "a", 12: "b", 25: "c"}, -1)
+	defer stopA()
+	b, stopB := hashNode(t, map[int]string{3: "a", 12: "x", 25: "c"}, -1)
+	defer stopB()
+	divergence, err := FindDivergence([]*Client{a, b}, 0, 29, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if divergence == nil || divergence.N != 12 {
+		t.Errorf("unexpected divergence %+v", divergence)
+	}
+
+	// The divergence which is gone in the next round is not equality.
+	c, stopC := hashNode(t, map[int]string{3: "a", 12: "b", 25: "c"}, 12)
+	defer stopC()
+	if divergence, err := FindDivergence([]*Client{b, c}, 0, 29, 3); err != ErrInconclusive {
+		t.Errorf("expected %v, got %+v, %v", ErrInconclusive, divergence, err)
+	}
+}
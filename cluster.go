@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/cluster"
+	"github.com/tariel-x/stream/config"
+)
+
+// nodeClusterID returns the cluster ID of the config or of the storage
+// directory of the file backend. The ID of the config is kept in the
+// directory, the different ID is the error.
+func nodeClusterID(conf *config.Config) (string, error) {
+	if conf.Storage.Backend != config.BackendFile {
+		return conf.ClusterID, nil
+	}
+	stored, err := cluster.ReadID(conf.Storage.Dir)
+	if err != nil {
+		return "", err
+	}
+	if conf.ClusterID == "" {
+		return stored, nil
+	}
+	if err := cluster.WriteID(conf.Storage.Dir, conf.ClusterID); err != nil {
+		return "", fmt.Errorf("cluster ID %s is not of the node: %v", conf.ClusterID, err)
+	}
+	return conf.ClusterID, nil
+}
+
+// Init creates the cluster ID. It is written to the storage directory of
+// the file backend, otherwise it is passed to nodes by cluster_id.
+func Init(c *cli.Context) error {
+	conf, err := nodeConfig(c)
+	if err != nil {
+		return err
+	}
+	id := conf.ClusterID
+	if id == "" {
+		id = cluster.NewID()
+	}
+	if conf.Storage.Backend == config.BackendFile {
+		if conf.Storage.Dir == "" {
+			return cli.NewExitError("storage dir is required by the file backend", 1)
+		}
+		if err := cluster.WriteID(conf.Storage.Dir, id); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// Join asks the seed node for the cluster ID and nodes, keeps the ID and
+// prints nodes to run the node with. Nodes of the cluster must add the node
+// to their nodes, the quorum of Paxos is fixed while nodes run, so join
+// fails until the seed lists the node.
+func Join(c *cli.Context) error {
+	conf, err := nodeConfig(c)
+	if err != nil {
+		return err
+	}
+	seed := c.String("seed")
+	if seed == "" {
+		return cli.NewExitError("seed is required", 1)
+	}
+	if conf.Address() == "" {
+		return cli.NewExitError("listen or advertise address is required", 1)
+	}
+	_, peerTLS, err := nodeTLS(conf.TLS)
+	if err != nil {
+		return err
+	}
+	seedClient, err := client.New(seed, &conf.Timeouts.Peer)
+	if err != nil {
+		return err
+	}
+	seedClient.TLS = peerTLS
+	if conf.ClusterSecret != "" {
+		seedClient.Secret = []byte(conf.ClusterSecret)
+	}
+	seedClient.SetNode(conf.Name(), conf.Address())
+	seedClient.SetCluster(conf.ClusterID)
+	hello, err := seedClient.Hello()
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", seed, err), 1)
+	}
+	if !hello.Has(client.FeatureJoin) {
+		return cli.NewExitError(fmt.Sprintf("%s does not support join, it should be upgraded", seed), 1)
+	}
+	response, err := seedClient.QueryOne(&client.Join{ID: conf.Name(), Address: conf.Address()})
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", seed, err), 1)
+	}
+	info, err := response.ClusterInfo()
+	if err != nil {
+		return err
+	}
+	existing, member := info.Nodes[conf.Name()]
+	if member && existing != conf.Address() {
+		return cli.NewExitError(fmt.Sprintf("node %s is in the cluster with the address %s", conf.Name(), existing), 1)
+	}
+	conf.ClusterID = info.ClusterID
+	if conf.Storage.Backend == config.BackendFile {
+		if err := cluster.WriteID(conf.Storage.Dir, info.ClusterID); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+
+	info.Nodes[conf.Name()] = conf.Address()
+	ids := make([]string, 0, len(info.Nodes))
+	for id := range info.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	nodes := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == info.Nodes[id] {
+			nodes = append(nodes, id)
+			continue
+		}
+		nodes = append(nodes, id+"="+info.Nodes[id])
+	}
+	fmt.Printf("cluster-id=%s\n", info.ClusterID)
+	fmt.Printf("nodes=%s\n", strings.Join(nodes, ","))
+	if !member {
+		return cli.NewExitError(fmt.Sprintf("node %s is not a member of the cluster: "+
+			"run it and restart every node of the cluster one by one with the nodes above, "+
+			"then run join again to check", conf.Name()), 1)
+	}
+	return nil
+}
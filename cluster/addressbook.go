@@ -0,0 +1,77 @@
+// Package cluster keeps the membership of the node: IDs of nodes and their
+// addresses.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrEmptyID = errors.New("empty node ID")
+
+// AddressBook maps IDs of nodes to their addresses. Nodes are identified by
+// IDs, so the address of the node may change while the node stays the same.
+type AddressBook struct {
+	m         sync.RWMutex
+	addresses map[string]string
+}
+
+func NewAddressBook(addresses map[string]string) (*AddressBook, error) {
+	book := &AddressBook{addresses: map[string]string{}}
+	for id, address := range addresses {
+		if id == "" {
+			return nil, ErrEmptyID
+		}
+		if address == "" {
+			return nil, fmt.Errorf("empty address of node %s", id)
+		}
+		book.addresses[id] = address
+	}
+	return book, nil
+}
+
+// Address returns the address of the node.
+func (b *AddressBook) Address(id string) (string, bool) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	address, ok := b.addresses[id]
+	return address, ok
+}
+
+// Update sets the new address of the known node and returns the previous
+// one. Unknown nodes are not added.
+func (b *AddressBook) Update(id, address string) (string, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	previous, ok := b.addresses[id]
+	if !ok || address == "" || previous == address {
+		return previous, false
+	}
+	b.addresses[id] = address
+	return previous, true
+}
+
+// IDs returns sorted IDs of nodes.
+func (b *AddressBook) IDs() []string {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	ids := make([]string, 0, len(b.addresses))
+	for id := range b.addresses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Addresses returns the copy of the book.
+func (b *AddressBook) Addresses() map[string]string {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	addresses := make(map[string]string, len(b.addresses))
+	for id, address := range b.addresses {
+		addresses[id] = address
+	}
+	return addresses
+}
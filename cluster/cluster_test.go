@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAddressBook(t *testing.T) {
+	if _, err := NewAddressBook(map[string]string{"": "localhost:7001"}); err != ErrEmptyID {
+		t.Errorf("expected ErrEmptyID, got %v", err)
+	}
+	book, err := NewAddressBook(map[string]string{
+		"n2": "localhost:7002",
+		"n1": "localhost:7001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := book.IDs(); !reflect.DeepEqual(ids, []string{"n1", "n2"}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+
+	if previous, ok := book.Update("n2", "10.0.0.2:7002"); !ok || previous != "localhost:7002" {
+		t.Errorf("n2 is not updated, previous %s", previous)
+	}
+	if address, _ := book.Address("n2"); address != "10.0.0.2:7002" {
+		t.Errorf("unexpected address %s", address)
+	}
+	if _, ok := book.Update("n2", "10.0.0.2:7002"); ok {
+		t.Error("the same address is updated")
+	}
+	if _, ok := book.Update("n3", "localhost:7003"); ok {
+		t.Error("unknown node is added")
+	}
+	if _, ok := book.Address("n3"); ok {
+		t.Error("unknown node has the address")
+	}
+}
+
+func TestWriteID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if id, err := ReadID(dir); err != nil || id != "" {
+		t.Fatalf("unexpected ID %q %v", id, err)
+	}
+	id := NewID()
+	if err := WriteID(dir, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteID(dir, id); err != nil {
+		t.Errorf("the same ID is not written: %v", err)
+	}
+	if err := WriteID(dir, NewID()); err == nil {
+		t.Error("the ID is replaced")
+	}
+	if actual, err := ReadID(dir); err != nil || actual != id {
+		t.Errorf("expected %s, got %q %v", id, actual, err)
+	}
+}
@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// IDFile keeps the cluster ID in the data directory of the node.
+const IDFile = "cluster.id"
+
+var ErrInitialized = errors.New("cluster ID is already set")
+
+// NewID makes the random cluster ID.
+func NewID() string {
+	return uuid.NewV4().String()
+}
+
+// ReadID reads the cluster ID from the directory, it is empty if the node
+// is not initialized.
+func ReadID(dir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IDFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return "", fmt.Errorf("empty cluster ID in %s", filepath.Join(dir, IDFile))
+	}
+	return id, nil
+}
+
+// WriteID writes the cluster ID to the directory. The different ID which
+// is already written is not replaced.
+func WriteID(dir, id string) error {
+	existing, err := ReadID(dir)
+	if err != nil {
+		return err
+	}
+	if existing == id {
+		return nil
+	}
+	if existing != "" {
+		return fmt.Errorf("%s: %v, %s", dir, ErrInitialized, existing)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, IDFile), []byte(id+"\n"), 0644)
+}
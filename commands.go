@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+)
+
+var (
+	nodeFlag = cli.StringFlag{
+		Name:  "node, n",
+		Value: "localhost:7001",
+		Usage: "Node interface:port",
+	}
+	jsonFlag = cli.BoolFlag{
+		Name:  "json, j",
+		Usage: "Print JSON lines instead of raw values",
+	}
+	fromFlag = cli.IntFlag{
+		Name:  "from, f",
+		Usage: "Epoch to read the log from",
+	}
+)
+
+// stdout and stderr of client commands, tests replace them.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+var (
+	authFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "user, u",
+			EnvVar: "STREAM_USER",
+			Usage:  "User to authenticate",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			EnvVar: "STREAM_PASSWORD",
+			Usage:  "Password of the user",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			EnvVar: "STREAM_TOKEN",
+			Usage:  "Token to authenticate instead of the user and the password",
+		},
+	}
+	// connectionFlags are flags of connections to nodes.
+	connectionFlags = append(append([]cli.Flag{}, tlsFlags...), authFlags...)
+)
+
+var clientCommands = []cli.Command{
+	{
+		Name:      "push",
+		Usage:     "push values from arguments or from stdin line by line",
+		ArgsUsage: "[value...]",
+		Action:    Push,
+		Flags:     append([]cli.Flag{nodeFlag, jsonFlag}, connectionFlags...),
+	},
+	{
+		Name:   "get",
+		Usage:  "print the log",
+		Action: Get,
+		Flags:  append([]cli.Flag{nodeFlag, jsonFlag, fromFlag}, connectionFlags...),
+	},
+	{
+		Name:    "pull",
+		Aliases: []string{"tail"},
+		Usage:   "print the log and follow new values",
+		Action:  Pull,
+		Flags:   append([]cli.Flag{nodeFlag, jsonFlag, fromFlag}, connectionFlags...),
+	},
+	{
+		Name:   "status",
+		Usage:  "print the node status with its peers",
+		Action: Status,
+		Flags: append([]cli.Flag{
+			nodeFlag,
+			jsonFlag,
+			cli.BoolFlag{
+				Name:  "cluster, c",
+				Usage: "Print the status of all nodes of the cluster aggregated by the node",
+			},
+		}, connectionFlags...),
+	},
+	{
+		Name:   "nodes",
+		Usage:  "print the status of every node",
+		Action: Nodes,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "nodes, n",
+				Usage: "List of nodes separated by comma ','.",
+			},
+			jsonFlag,
+		}, connectionFlags...),
+	},
+}
+
+type printer struct {
+	json bool
+	out  io.Writer
+}
+
+func newPrinter(c *cli.Context) *printer {
+	return &printer{json: c.Bool("json"), out: stdout}
+}
+
+// Print prints the raw line or the JSON object.
+func (p *printer) Print(raw string, object interface{}) error {
+	if !p.json {
+		_, err := fmt.Fprintln(p.out, raw)
+		return err
+	}
+	return json.NewEncoder(p.out).Encode(object)
+}
+
+type valueLine struct {
+	Value string `json:"value"`
+}
+
+type pushLine struct {
+	Value string `json:"value"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func newClient(c *cli.Context) (*client.Client, error) {
+	return dialNode(c, c.String("node"))
+}
+
+func dialNode(c *cli.Context, address string) (*client.Client, error) {
+	if address == "" {
+		return nil, errors.New("invalid node address")
+	}
+	tlsConfig, err := clientTLS(c)
+	if err != nil {
+		return nil, err
+	}
+	nodeClient, err := client.New(address, nil)
+	if err != nil {
+		return nil, err
+	}
+	nodeClient.TLS = tlsConfig
+	if token := c.String("token"); token != "" {
+		nodeClient.SetToken(token)
+	} else if user := c.String("user"); user != "" {
+		nodeClient.SetCredentials(user, c.String("password"))
+	} else {
+		return nodeClient, nil
+	}
+	if tlsConfig == nil {
+		fmt.Fprintln(stderr, "warning: credentials are sent in plain text without TLS")
+	}
+	return nodeClient, nil
+}
+
+// validateValue checks that the protocol can carry the value.
+func validateValue(v string) error {
+	if v == "" {
+		return errors.New("value is empty")
+	}
+	if strings.ContainsAny(v, " ;\n\r") {
+		return fmt.Errorf("value %q must not contain spaces, ';' or line breaks", v)
+	}
+	return nil
+}
+
+func Push(c *cli.Context) error {
+	nodeClient, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	p := newPrinter(c)
+
+	push := func(v string) error {
+		if err := validateValue(v); err != nil {
+			return err
+		}
+		if err := pushValue(nodeClient, v); err != nil {
+			return err
+		}
+		return p.Print(v, pushLine{Value: v, OK: true})
+	}
+
+	// Values are pushed one by one, the failed value is reported and the
+	// next one is pushed.
+	failed := 0
+	pushNext := func(v string) error {
+		err := push(v)
+		if err == nil {
+			return nil
+		}
+		failed++
+		if p.json {
+			return p.Print("", pushLine{Value: v, Error: err.Error()})
+		}
+		fmt.Fprintln(stderr, err)
+		return nil
+	}
+
+	if c.NArg() > 0 {
+		for _, v := range c.Args() {
+			if err := pushNext(v); err != nil {
+				return err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if err := pushNext(scanner.Text()); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("failed to push %d values", failed), 1)
+	}
+	return nil
+}
+
+// pushValue pushes the value, throttled values are pushed again after the
+// time the node asks.
+func pushValue(nodeClient *client.Client, v string) error {
+	response, err := nodeClient.QueryOne(&client.Push{V: v})
+	for err != nil {
+		replyErr, ok := err.(*client.Error)
+		if !ok {
+			return err
+		}
+		retryAfter, throttled := replyErr.RetryAfter()
+		if !throttled {
+			return err
+		}
+		time.Sleep(retryAfter)
+		response, err = nodeClient.QueryOne(&client.Push{V: v})
+	}
+	ok, err := response.Ok()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(response.Message))
+	}
+	if !ok {
+		return fmt.Errorf("push %s refused", v)
+	}
+	return nil
+}
+
+func Get(c *cli.Context) error {
+	nodeClient, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	responses, err := nodeClient.QueryMany(&client.Get{N: c.Int("from")})
+	if err != nil {
+		return err
+	}
+	p := newPrinter(c)
+	for _, response := range responses {
+		v := strings.TrimSpace(response.Message)
+		if err := p.Print(v, valueLine{Value: v}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Pull(c *cli.Context) error {
+	nodeClient, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	connection, err := nodeClient.Connect()
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-backgroundContext.Done()
+		connection.Close()
+	}()
+	responses, err := connection.QueryMany(&client.Pull{N: c.Int("from")})
+	if err != nil {
+		return err
+	}
+	p := newPrinter(c)
+	for response := responses.Next(); response != nil; response = responses.Next() {
+		v := strings.TrimSpace(response.Message)
+		if err := p.Print(v, valueLine{Value: v}); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-backgroundContext.Done():
+		return nil
+	default:
+		return responses.Err()
+	}
+}
+
+func queryStatus(c *cli.Context, address string, scope string) (*client.Response, error) {
+	nodeClient, err := dialNode(c, address)
+	if err != nil {
+		return nil, err
+	}
+	return nodeClient.QueryOne(&client.Status{Scope: scope})
+}
+
+func nodeStatus(c *cli.Context, address string) client.NodeStatus {
+	response, err := queryStatus(c, address, client.StatusNode)
+	if err != nil {
+		return client.NodeStatus{Name: address, Error: err.Error()}
+	}
+	status, err := response.NodeStatus()
+	if err != nil {
+		return client.NodeStatus{Name: address, Error: err.Error()}
+	}
+	return *status
+}
+
+// nodeLabel returns the ID of the node with the address if they differ.
+func nodeLabel(name, address string) string {
+	if address == "" || address == name {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, address)
+}
+
+func formatNodeStatus(status client.NodeStatus) string {
+	name := nodeLabel(status.Name, status.Address)
+	if status.Error != "" {
+		return fmt.Sprintf("%s\tunreachable: %s", name, status.Error)
+	}
+	return fmt.Sprintf("%s\t%s\tballot=%d committed=%d entries=%d bytes=%d uptime=%s version=%s",
+		name, status.Role, status.Ballot, status.Committed, status.Entries, status.Bytes,
+		time.Duration(status.Uptime*float64(time.Second)).Round(time.Second), status.Version)
+}
+
+func formatPeerStatus(peer client.PeerStatus) string {
+	name := nodeLabel(peer.Name, peer.Address)
+	if !peer.Reachable {
+		return fmt.Sprintf("  %s\tunreachable: %s", name, peer.Error)
+	}
+	return fmt.Sprintf("  %s\treachable committed=%d entries=%d lag=%d", name, peer.Committed, peer.Entries, peer.Lag)
+}
+
+func Status(c *cli.Context) error {
+	p := newPrinter(c)
+	if c.Bool("cluster") {
+		return clusterStatus(c, p)
+	}
+	response, err := queryStatus(c, c.String("node"), "")
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s\tunreachable: %s", c.String("node"), err), 1)
+	}
+	status, err := response.NodeStatus()
+	if err != nil {
+		return err
+	}
+	lines := []string{formatNodeStatus(*status)}
+	for _, peer := range status.Peers {
+		lines = append(lines, formatPeerStatus(peer))
+	}
+	return p.Print(strings.Join(lines, "\n"), status)
+}
+
+func clusterStatus(c *cli.Context, p *printer) error {
+	response, err := queryStatus(c, c.String("node"), client.StatusCluster)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s\tunreachable: %s", c.String("node"), err), 1)
+	}
+	status, err := response.ClusterStatus()
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, node := range status.Nodes {
+		lines = append(lines, formatNodeStatus(node))
+	}
+	lines = append(lines, fmt.Sprintf("reachable %d/%d quorum=%t committed=%d max_lag=%d",
+		status.Reachable, status.Size, status.Quorum, status.Committed, status.MaxLag))
+	if err := p.Print(strings.Join(lines, "\n"), status); err != nil {
+		return err
+	}
+	if !status.Quorum {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+func Nodes(c *cli.Context) error {
+	nodesListString := c.String("nodes")
+	if nodesListString == "" {
+		return errors.New("invalid nodes list")
+	}
+	p := newPrinter(c)
+	for _, address := range strings.Split(nodesListString, ",") {
+		status := nodeStatus(c, address)
+		if err := p.Print(formatNodeStatus(status), status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
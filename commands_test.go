@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+)
+
+// fakeNode replies requests by the command, one request per connection.
+func fakeNode(t *testing.T, reply func(cmd string) []string) (string, func()) {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := socket.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				cmd := strings.SplitN(strings.TrimSpace(line), ";", 2)[0]
+				for _, message := range reply(cmd) {
+					conn.Write([]byte(message + "\n"))
+				}
+			}()
+		}
+	}()
+	return socket.Addr().String(), func() { socket.Close() }
+}
+
+// runCommand runs the client command and returns its stdout, stderr and
+// the error.
+func runCommand(args ...string) (string, string, error) {
+	return runCommands(clientCommands, args...)
+}
+
+func runCommands(commands []cli.Command, args ...string) (string, string, error) {
+	// The context is set by main.
+	if backgroundContext == nil {
+		backgroundContext = context.Background()
+	}
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	stdout, stderr = out, errOut
+	defer func() {
+		stdout, stderr = os.Stdout, os.Stderr
+	}()
+	exiter := cli.OsExiter
+	cli.OsExiter = func(int) {}
+	defer func() { cli.OsExiter = exiter }()
+
+	app := cli.NewApp()
+	app.Commands = commands
+	app.ErrWriter = errOut
+	err := app.Run(append([]string{"stream"}, args...))
+	return out.String(), errOut.String(), err
+}
+
+func TestPush(t *testing.T) {
+	var pushed []string
+	node, stop := fakeNode(t, func(cmd string) []string {
+		if cmd == "PUSH fail" {
+			return []string{"ERR QUORUM_FAILED quorum failed"}
+		}
+		pushed = append(pushed, cmd)
+		return []string{client.CmdOK}
+	})
+	defer stop()
+
+	out, errOut, err := runCommand("push", "-n", node, "a", "fail", "b;c", "d")
+	if err == nil || !strings.Contains(err.Error(), "failed to push 2 values") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if out != "a\nd\n" || strings.Count(errOut, "\n") < 2 {
+		t.Errorf("unexpected output %q, %q", out, errOut)
+	}
+
+	// Failed values are printed as JSON lines too.
+	out, _, _ = runCommand("push", "-n", node, "--json", "fail", "e")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var failed, ok pushLine
+	if err := json.Unmarshal([]byte(lines[0]), &failed); err != nil || failed.OK || failed.Error == "" {
+		t.Errorf("unexpected line %q", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &ok); err != nil || !ok.OK || ok.Value != "e" {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+	if strings.Join(pushed, ",") != "PUSH a,PUSH d,PUSH e" {
+		t.Errorf("unexpected pushes %v", pushed)
+	}
+}
+
+func TestGet(t *testing.T) {
+	node, stop := fakeNode(t, func(cmd string) []string {
+		if cmd != "GET 2" {
+			return []string{"ERR BAD_ARGS unexpected " + cmd}
+		}
+		return []string{"a", "b"}
+	})
+	defer stop()
+	out, _, err := runCommand("get", "-n", node, "--from", "2")
+	if err != nil || out != "a\nb\n" {
+		t.Errorf("unexpected output %q, %v", out, err)
+	}
+	out, _, err = runCommand("get", "-n", node, "--from", "2", "--json")
+	if err != nil || out != "{\"value\":\"a\"}\n{\"value\":\"b\"}\n" {
+		t.Errorf("unexpected output %q, %v", out, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	node, stop := fakeNode(t, func(cmd string) []string {
+		return []string{`OK {"name":"n1","address":"localhost:7001","role":"acceptor","ballot":5,"committed":4,"entries":2,"bytes":3,"uptime_seconds":61,"version":"0.1",` +
+			`"peers":[{"name":"n2","reachable":true,"committed":4,"entries":2},{"name":"n3","error":"connection refused"}]}`}
+	})
+	defer stop()
+	out, _, err := runCommand("status", "-n", node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 ||
+		lines[0] != "n1 (localhost:7001)\tacceptor\tballot=5 committed=4 entries=2 bytes=3 uptime=1m1s version=0.1" ||
+		!strings.HasPrefix(lines[1], "  n2") || !strings.Contains(lines[2], "connection refused") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if _, _, err := runCommand("status", "-n", "127.0.0.1:1"); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestValidateValue(t *testing.T) {
+	for _, v := range []string{"", "a b", "a;b", "a\nb"} {
+		if validateValue(v) == nil {
+			t.Errorf("%q is valid", v)
+		}
+	}
+	if err := validateValue("a-b"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+)
+
+var compareCommand = cli.Command{
+	Name:   "compare",
+	Usage:  "compare logs of nodes by hashes of ranges of epochs and print the first epoch where they differ, it exits with the error if logs differ",
+	Action: Compare,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "nodes, n",
+			Usage: "List of nodes separated by comma ','.",
+		},
+		cli.IntFlag{
+			Name:  "from, f",
+			Usage: "Epoch to compare logs from, e.g. the first epoch kept by the retention",
+		},
+		cli.IntFlag{
+			Name:  "to, t",
+			Value: -1,
+			Usage: "Epoch to compare logs to, the lowest committed epoch of nodes by default",
+		},
+		cli.IntFlag{
+			Name:  "ranges, r",
+			Value: 16,
+			Usage: "Number of ranges hashed by one LOGHASH",
+		},
+		jsonFlag,
+	}, connectionFlags...),
+}
+
+type compareNode struct {
+	Node      string `json:"node"`
+	Committed int    `json:"committed"`
+}
+
+type compareResult struct {
+	From       int                `json:"from"`
+	To         int                `json:"to"`
+	Nodes      []compareNode      `json:"nodes"`
+	Equal      bool               `json:"equal"`
+	Divergence *client.Divergence `json:"divergence,omitempty"`
+}
+
+// Compare compares entries committed by all nodes, entries after the
+// lowest committed epoch are not replicated to every node yet.
+func Compare(c *cli.Context) error {
+	addresses := strings.Split(c.String("nodes"), ",")
+	if c.String("nodes") == "" || len(addresses) < 2 {
+		return errors.New("at least two nodes are compared")
+	}
+	result := compareResult{From: c.Int("from"), To: c.Int("to")}
+	nodes := make([]*client.Client, 0, len(addresses))
+	for _, address := range addresses {
+		nodeClient, err := dialNode(c, address)
+		if err != nil {
+			return err
+		}
+		hello, err := nodeClient.Hello()
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %v", address, err), 1)
+		}
+		if !hello.Has(client.FeatureLogHash) {
+			return cli.NewExitError(fmt.Sprintf("%s: LOGHASH is not supported", address), 1)
+		}
+		status := nodeStatus(c, address)
+		if status.Error != "" {
+			return cli.NewExitError(fmt.Sprintf("%s: %s", address, status.Error), 1)
+		}
+		if c.Int("to") < 0 && (len(nodes) == 0 || status.Committed < result.To) {
+			result.To = status.Committed
+		}
+		nodes = append(nodes, nodeClient)
+		result.Nodes = append(result.Nodes, compareNode{Node: address, Committed: status.Committed})
+	}
+
+	divergence, err := client.FindDivergence(nodes, result.From, result.To, c.Int("ranges"))
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	result.Equal, result.Divergence = divergence == nil, divergence
+
+	p := newPrinter(c)
+	if p.json {
+		if err := p.Print("", result); err != nil {
+			return err
+		}
+	} else {
+		for _, node := range result.Nodes {
+			fmt.Printf("%s\tcommitted=%d\n", node.Node, node.Committed)
+		}
+		if divergence == nil {
+			fmt.Printf("logs are equal in epochs %d-%d\n", result.From, result.To)
+		} else {
+			fmt.Printf("logs differ at epoch %d\n", divergence.N)
+			for i, r := range divergence.Ranges {
+				fmt.Printf("%s\tentries=%d hash=%s\n", addresses[i], r.Entries, r.Hash)
+			}
+		}
+	}
+	if divergence != nil {
+		return cli.NewExitError(fmt.Sprintf("logs differ at epoch %d", divergence.N), 1)
+	}
+	return nil
+}
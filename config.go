@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/auth"
+	"github.com/tariel-x/stream/config"
+	"github.com/tariel-x/stream/limit"
+)
+
+var configFlag = cli.StringFlag{
+	Name:  "config, c",
+	Usage: "YAML config file, environment variables STREAM_* override it and flags override both",
+}
+
+// nodeConfig loads the config file, applies environment variables and then
+// flags set explicitly.
+func nodeConfig(c *cli.Context) (*config.Config, error) {
+	conf := config.Default()
+	if path := c.String("config"); path != "" {
+		var err error
+		if conf, err = config.Load(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.ApplyOSEnv(); err != nil {
+		return nil, err
+	}
+
+	stringFlags := map[string]*string{
+		"cluster-id":     &conf.ClusterID,
+		"node-id":        &conf.NodeID,
+		"listen":         &conf.Listen,
+		"advertise":      &conf.Advertise,
+		"http":           &conf.HTTP,
+		"grpc":           &conf.GRPC,
+		"metrics":        &conf.Metrics,
+		"storage":        &conf.Storage.Backend,
+		"dir":            &conf.Storage.Dir,
+		"fsync":          &conf.Storage.Fsync,
+		"log-level":      &conf.Log.Level,
+		"trace-exporter": &conf.Trace.Exporter,
+		"trace-file":     &conf.Trace.File,
+		"trace-endpoint": &conf.Trace.Endpoint,
+		"credentials":    &conf.Credentials,
+		"cluster-secret": &conf.ClusterSecret,
+		"tls-cert":       &conf.TLS.Cert,
+		"tls-key":        &conf.TLS.Key,
+		"tls-ca":         &conf.TLS.CA,
+		"tls-peer-ca":    &conf.TLS.PeerCA,
+	}
+	for name, value := range stringFlags {
+		if c.IsSet(name) {
+			*value = c.String(name)
+		}
+	}
+	durations := map[string]*time.Duration{
+		"fsync-interval": &conf.Storage.FsyncInterval,
+		"peer-timeout":   &conf.Timeouts.Peer,
+		"read-timeout":   &conf.Timeouts.Read,
+		"write-timeout":  &conf.Timeouts.Write,
+		"drain-timeout":  &conf.Timeouts.Drain,
+	}
+	for name, value := range durations {
+		if c.IsSet(name) {
+			*value = c.Duration(name)
+		}
+	}
+	bools := map[string]*bool{
+		"log-json":       &conf.Log.JSON,
+		"log-payloads":   &conf.Log.Payloads,
+		"log-runtime":    &conf.Log.Runtime,
+		"insecure-peers": &conf.InsecurePeers,
+	}
+	for name, value := range bools {
+		if c.IsSet(name) {
+			*value = c.Bool(name)
+		}
+	}
+	if c.IsSet("nodes") {
+		conf.Peers = config.SplitList(c.String("nodes"))
+	}
+	if c.IsSet("http-origins") {
+		conf.HTTPOrigins = config.SplitList(c.String("http-origins"))
+	}
+	if c.IsSet("retention-entries") {
+		conf.Storage.Retention.MaxEntries = c.Uint64("retention-entries")
+	}
+	if c.IsSet("retention-bytes") {
+		conf.Storage.Retention.MaxBytes = c.Uint64("retention-bytes")
+	}
+	if c.IsSet("max-connections") {
+		conf.Limits.MaxConnections = c.Int("max-connections")
+	}
+	if c.IsSet("limits") {
+		limits, err := limit.Load(c.String("limits"))
+		if err != nil {
+			return nil, err
+		}
+		conf.Limits.Config = *limits
+	}
+	return conf, nil
+}
+
+// CheckConfig validates the config of the run command and loads files it
+// refers to.
+func CheckConfig(c *cli.Context) error {
+	conf, err := nodeConfig(c)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if err := conf.Validate(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if _, _, err := nodeTLS(conf.TLS); err != nil {
+		return cli.NewExitError(fmt.Errorf("tls: %v", err), 1)
+	}
+	if conf.Credentials != "" {
+		if _, err := auth.Load(conf.Credentials); err != nil {
+			return cli.NewExitError(fmt.Errorf("credentials: %v", err), 1)
+		}
+	}
+	fmt.Println("config is valid")
+	return nil
+}
@@ -0,0 +1,453 @@
+// Package config is the configuration of the node: the YAML file, the
+// environment overrides and the validation.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/tariel-x/stream/limit"
+	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+)
+
+// Storage backends.
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// Config is the configuration of the node, e.g.
+//
+//	node_id: n1
+//	listen: localhost:7001
+//	peers: [n1=localhost:7001, n2=localhost:7002, n3=localhost:7003]
+//	storage:
+//	  backend: file
+//	  dir: /var/lib/stream
+//	  fsync: always
+//	  retention:
+//	    max_entries: 1000000
+//	timeouts:
+//	  read: 30s
+//	tls:
+//	  cert: node.pem
+//	  key: node.key
+//	  ca: ca.pem
+//	limits:
+//	  max_connections: 1024
+//	  default:
+//	    push_messages: 100
+type Config struct {
+	// ClusterID is set by init or join, peers of other clusters are
+	// rejected. It is kept in the storage directory by the file backend.
+	ClusterID string `yaml:"cluster_id"`
+	// NodeID is the name of the node, it is the advertise address if empty.
+	NodeID    string `yaml:"node_id"`
+	Listen    string `yaml:"listen"`
+	Advertise string `yaml:"advertise"`
+	HTTP      string `yaml:"http"`
+	// HTTPOrigins are origins of pages allowed to open the WebSocket
+	// besides the same origin, "*" allows any.
+	HTTPOrigins []string `yaml:"http_origins"`
+	GRPC        string   `yaml:"grpc"`
+	Metrics     string   `yaml:"metrics"`
+	// Peers are nodes of the cluster as "id=address" or as addresses which
+	// are also IDs. The node itself is skipped.
+	Peers         []string `yaml:"peers"`
+	Storage       Storage  `yaml:"storage"`
+	Timeouts      Timeouts `yaml:"timeouts"`
+	TLS           TLS      `yaml:"tls"`
+	Limits        Limits   `yaml:"limits"`
+	Credentials   string   `yaml:"credentials"`
+	ClusterSecret string   `yaml:"cluster_secret"`
+	// InsecurePeers accepts peer commands of any client if neither TLS nor
+	// the cluster secret is set, the node does not start without it then.
+	InsecurePeers bool  `yaml:"insecure_peers"`
+	Log           Log   `yaml:"log"`
+	Trace         Trace `yaml:"trace"`
+}
+
+type Storage struct {
+	Backend       string        `yaml:"backend"`
+	Dir           string        `yaml:"dir"`
+	Fsync         string        `yaml:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsync_interval"`
+	Retention     Retention     `yaml:"retention"`
+}
+
+// Retention limits entries kept by the node, zero is unlimited.
+type Retention struct {
+	MaxEntries uint64 `yaml:"max_entries"`
+	MaxBytes   uint64 `yaml:"max_bytes"`
+}
+
+type Timeouts struct {
+	// Peer is the timeout of connections to peers.
+	Peer  time.Duration `yaml:"peer"`
+	Read  time.Duration `yaml:"read"`
+	Write time.Duration `yaml:"write"`
+	Drain time.Duration `yaml:"drain"`
+}
+
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	CA   string `yaml:"ca"`
+	// PeerCA signs client certificates of nodes only, connections with
+	// them are peers.
+	PeerCA string `yaml:"peer_ca"`
+}
+
+// Limits are the connection limit and rate limits of clients.
+type Limits struct {
+	MaxConnections int `yaml:"max_connections"`
+	limit.Config   `yaml:",inline"`
+}
+
+type Log struct {
+	Level    string `yaml:"level"`
+	JSON     bool   `yaml:"json"`
+	Payloads bool   `yaml:"payloads"`
+	// Runtime allows changing the level by PUT /loglevel.
+	Runtime bool `yaml:"runtime"`
+}
+
+type Trace struct {
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Default returns the configuration used for missing values.
+func Default() *Config {
+	return &Config{
+		Storage: Storage{
+			Backend:       BackendMemory,
+			Fsync:         storage.FsyncAlways,
+			FsyncInterval: time.Second,
+		},
+		Timeouts: Timeouts{
+			Peer:  20 * time.Second,
+			Read:  30 * time.Second,
+			Write: 10 * time.Second,
+			Drain: 10 * time.Second,
+		},
+		Limits: Limits{MaxConnections: 1024},
+		Log:    Log{Level: "info"},
+		Trace: Trace{
+			Exporter: "none",
+			File:     "spans.jsonl",
+			Endpoint: "http://localhost:4318/v1/traces",
+		},
+	}
+}
+
+// Load reads the file over the default configuration. Unknown keys are
+// errors.
+func Load(path string) (*Config, error) {
+	config := Default()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// env is the environment variable overriding the value.
+type env struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+func str(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func duration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolean(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func uinteger(field func(c *Config) *uint64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// Env are environment variables overriding the file.
+var Env = []env{
+	{"STREAM_CLUSTER_ID", str(func(c *Config) *string { return &c.ClusterID })},
+	{"STREAM_NODE_ID", str(func(c *Config) *string { return &c.NodeID })},
+	{"STREAM_LISTEN", str(func(c *Config) *string { return &c.Listen })},
+	{"STREAM_ADVERTISE", str(func(c *Config) *string { return &c.Advertise })},
+	{"STREAM_HTTP", str(func(c *Config) *string { return &c.HTTP })},
+	{"STREAM_GRPC", str(func(c *Config) *string { return &c.GRPC })},
+	{"STREAM_METRICS", str(func(c *Config) *string { return &c.Metrics })},
+	{"STREAM_HTTP_ORIGINS", func(c *Config, value string) error {
+		c.HTTPOrigins = SplitList(value)
+		return nil
+	}},
+	{"STREAM_PEERS", func(c *Config, value string) error {
+		c.Peers = SplitList(value)
+		return nil
+	}},
+	{"STREAM_STORAGE_BACKEND", str(func(c *Config) *string { return &c.Storage.Backend })},
+	{"STREAM_STORAGE_DIR", str(func(c *Config) *string { return &c.Storage.Dir })},
+	{"STREAM_STORAGE_FSYNC", str(func(c *Config) *string { return &c.Storage.Fsync })},
+	{"STREAM_STORAGE_FSYNC_INTERVAL", duration(func(c *Config) *time.Duration { return &c.Storage.FsyncInterval })},
+	{"STREAM_RETENTION_MAX_ENTRIES", uinteger(func(c *Config) *uint64 { return &c.Storage.Retention.MaxEntries })},
+	{"STREAM_RETENTION_MAX_BYTES", uinteger(func(c *Config) *uint64 { return &c.Storage.Retention.MaxBytes })},
+	{"STREAM_TIMEOUT_PEER", duration(func(c *Config) *time.Duration { return &c.Timeouts.Peer })},
+	{"STREAM_TIMEOUT_READ", duration(func(c *Config) *time.Duration { return &c.Timeouts.Read })},
+	{"STREAM_TIMEOUT_WRITE", duration(func(c *Config) *time.Duration { return &c.Timeouts.Write })},
+	{"STREAM_TIMEOUT_DRAIN", duration(func(c *Config) *time.Duration { return &c.Timeouts.Drain })},
+	{"STREAM_TLS_CERT", str(func(c *Config) *string { return &c.TLS.Cert })},
+	{"STREAM_TLS_KEY", str(func(c *Config) *string { return &c.TLS.Key })},
+	{"STREAM_TLS_CA", str(func(c *Config) *string { return &c.TLS.CA })},
+	{"STREAM_TLS_PEER_CA", str(func(c *Config) *string { return &c.TLS.PeerCA })},
+	{"STREAM_MAX_CONNECTIONS", func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		c.Limits.MaxConnections = n
+		return nil
+	}},
+	{"STREAM_CREDENTIALS", str(func(c *Config) *string { return &c.Credentials })},
+	{"STREAM_CLUSTER_SECRET", str(func(c *Config) *string { return &c.ClusterSecret })},
+	{"STREAM_INSECURE_PEERS", boolean(func(c *Config) *bool { return &c.InsecurePeers })},
+	{"STREAM_LOG_LEVEL", str(func(c *Config) *string { return &c.Log.Level })},
+	{"STREAM_LOG_JSON", boolean(func(c *Config) *bool { return &c.Log.JSON })},
+	{"STREAM_LOG_PAYLOADS", boolean(func(c *Config) *bool { return &c.Log.Payloads })},
+	{"STREAM_LOG_RUNTIME", boolean(func(c *Config) *bool { return &c.Log.Runtime })},
+	{"STREAM_TRACE_EXPORTER", str(func(c *Config) *string { return &c.Trace.Exporter })},
+	{"STREAM_TRACE_FILE", str(func(c *Config) *string { return &c.Trace.File })},
+	{"STREAM_TRACE_ENDPOINT", str(func(c *Config) *string { return &c.Trace.Endpoint })},
+}
+
+// ApplyEnv overrides values by set environment variables.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, e := range Env {
+		value, ok := lookup(e.name)
+		if !ok {
+			continue
+		}
+		if err := e.set(c, value); err != nil {
+			return fmt.Errorf("%s: %v", e.name, err)
+		}
+	}
+	return nil
+}
+
+// ApplyOSEnv overrides values by the environment of the process.
+func (c *Config) ApplyOSEnv() error {
+	return c.ApplyEnv(os.LookupEnv)
+}
+
+// SplitList splits the comma separated list skipping empty items.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Address returns the address of the node in the list of nodes.
+func (c *Config) Address() string {
+	if c.Advertise != "" {
+		return c.Advertise
+	}
+	return c.Listen
+}
+
+// Name returns the node ID or the address if the ID is not set.
+func (c *Config) Name() string {
+	if c.NodeID != "" {
+		return c.NodeID
+	}
+	return c.Address()
+}
+
+// Node is the entry of peers.
+type Node struct {
+	ID      string
+	Address string
+}
+
+// ParseNode parses "id=address" or the address which is also the ID.
+func ParseNode(entry string) Node {
+	if i := strings.Index(entry, "="); i >= 0 {
+		return Node{ID: entry[:i], Address: entry[i+1:]}
+	}
+	return Node{ID: entry, Address: entry}
+}
+
+// Nodes returns parsed peers.
+func (c *Config) Nodes() []Node {
+	nodes := make([]Node, 0, len(c.Peers))
+	for _, peer := range c.Peers {
+		nodes = append(nodes, ParseNode(peer))
+	}
+	return nodes
+}
+
+// OtherPeers returns peers without the node itself.
+func (c *Config) OtherPeers() []Node {
+	peers := make([]Node, 0, len(c.Peers))
+	for _, node := range c.Nodes() {
+		if node.ID != c.Name() && node.Address != c.Address() {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+// Validate checks the configuration and returns all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.Listen == "" {
+		add("listen: required")
+	}
+	if len(c.OtherPeers()) == 0 {
+		add("peers: at least one other node is required")
+	}
+	ids, addresses := map[string]struct{}{}, map[string]struct{}{}
+	for _, node := range c.Nodes() {
+		if node.ID == "" || node.Address == "" {
+			add("peers: %q must be id=address or the address", node.ID+"="+node.Address)
+			continue
+		}
+		if _, ok := ids[node.ID]; ok {
+			add("peers: duplicate ID %s", node.ID)
+		}
+		if _, ok := addresses[node.Address]; ok && node.Address != node.ID {
+			add("peers: duplicate address %s", node.Address)
+		}
+		ids[node.ID], addresses[node.Address] = struct{}{}, struct{}{}
+		// The node is known to others by the ID in peers.
+		if node.ID == c.Name() && node.Address != c.Address() {
+			add("peers: %s has the address %s, the node advertises %s", node.ID, node.Address, c.Address())
+		}
+		if node.Address == c.Address() && node.ID != c.Name() && node.ID != node.Address {
+			add("peers: %s has the address of the node %s", node.ID, c.Name())
+		}
+	}
+	if strings.ContainsAny(c.ClusterID, " ;=,\n") {
+		add("cluster_id: %q must not contain spaces, ';', '=' or ','", c.ClusterID)
+	}
+	if strings.ContainsAny(c.NodeID, " ;=,\n") {
+		add("node_id: %q must not contain spaces, ';', '=' or ','", c.NodeID)
+	}
+
+	switch c.Storage.Backend {
+	case BackendMemory:
+	case BackendFile:
+		if c.Storage.Dir == "" {
+			add("storage.dir: required by the file backend")
+		}
+	default:
+		add("storage.backend: unknown backend %q, expected memory or file", c.Storage.Backend)
+	}
+	switch c.Storage.Fsync {
+	case storage.FsyncAlways, storage.FsyncNever:
+	case storage.FsyncInterval:
+		if c.Storage.FsyncInterval <= 0 {
+			add("storage.fsync_interval: must be positive")
+		}
+	default:
+		add("storage.fsync: unknown policy %q, expected always, interval or never", c.Storage.Fsync)
+	}
+
+	timeouts := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"peer", c.Timeouts.Peer},
+		{"read", c.Timeouts.Read},
+		{"write", c.Timeouts.Write},
+		{"drain", c.Timeouts.Drain},
+	}
+	for _, t := range timeouts {
+		if t.timeout < 0 {
+			add("timeouts.%s: must not be negative", t.name)
+		}
+	}
+	if c.Timeouts.Peer == 0 {
+		add("timeouts.peer: must be positive")
+	}
+
+	tlsSet := 0
+	for _, file := range []string{c.TLS.Cert, c.TLS.Key, c.TLS.CA, c.TLS.PeerCA} {
+		if file != "" {
+			tlsSet++
+		}
+	}
+	if tlsSet != 0 && tlsSet != 4 {
+		add("tls: cert, key, ca and peer_ca are required for TLS")
+	}
+	if tlsSet == 0 && c.ClusterSecret == "" && !c.InsecurePeers {
+		add("cluster_secret: tls or cluster_secret is required to authenticate peers, insecure_peers accepts peer commands of any client")
+	}
+
+	if c.Limits.MaxConnections < 0 {
+		add("limits.max_connections: must not be negative")
+	}
+	if err := c.Limits.Config.Validate(); err != nil {
+		add("limits.%v", err)
+	}
+
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		add("log.level: %v", err)
+	}
+	switch c.Trace.Exporter {
+	case "", "none", "stdout", "file", "otlp":
+	default:
+		add("trace.exporter: unknown exporter %q, expected none, stdout, file or otlp", c.Trace.Exporter)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+node_id: n1
+listen: localhost:7001
+peers: [n1=localhost:7001, n2=localhost:7002, n3=localhost:7003]
+cluster_secret: secret
+storage:
+  backend: file
+  dir: /tmp/n1
+  fsync: interval
+  retention:
+    max_entries: 1000
+timeouts:
+  read: 5s
+limits:
+  max_connections: 10
+  default:
+    push_messages: 100
+  users:
+    producer:
+      push_messages: 1000
+`)
+	defer os.Remove(path)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Storage.Backend != BackendFile || config.Storage.Retention.MaxEntries != 1000 {
+		t.Errorf("unexpected storage %+v", config.Storage)
+	}
+	// Missing values are defaults.
+	if config.Timeouts.Read != 5*time.Second || config.Timeouts.Write != 10*time.Second {
+		t.Errorf("unexpected timeouts %+v", config.Timeouts)
+	}
+	if config.Storage.FsyncInterval != time.Second {
+		t.Errorf("unexpected fsync interval %s", config.Storage.FsyncInterval)
+	}
+	if config.Limits.MaxConnections != 10 || config.Limits.Default.PushMessages != 100 || config.Limits.Users["producer"].PushMessages != 1000 {
+		t.Errorf("unexpected limits %+v", config.Limits)
+	}
+	expected := []Node{{ID: "n2", Address: "localhost:7002"}, {ID: "n3", Address: "localhost:7003"}}
+	if peers := config.OtherPeers(); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("unexpected peers %v", peers)
+	}
+
+	unknown := writeConfig(t, "listen: localhost:7001\nlisten_address: localhost:7001\n")
+	defer os.Remove(unknown)
+	if _, err := Load(unknown); err == nil {
+		t.Error("unknown key is accepted")
+	}
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	config := Default()
+	config.Listen = "localhost:7001"
+	env := map[string]string{
+		"STREAM_LISTEN":                "0.0.0.0:7001",
+		"STREAM_PEERS":                 "a:1, b:2,",
+		"STREAM_TIMEOUT_DRAIN":         "1m",
+		"STREAM_RETENTION_MAX_ENTRIES": "5",
+		"STREAM_LOG_JSON":              "true",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	if err := config.ApplyEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if config.Listen != "0.0.0.0:7001" || !reflect.DeepEqual(config.Peers, []string{"a:1", "b:2"}) {
+		t.Errorf("unexpected addresses %s %v", config.Listen, config.Peers)
+	}
+	if config.Timeouts.Drain != time.Minute || config.Storage.Retention.MaxEntries != 5 || !config.Log.JSON {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	env["STREAM_TIMEOUT_READ"] = "soon"
+	if err := config.ApplyEnv(lookup); err == nil || !strings.Contains(err.Error(), "STREAM_TIMEOUT_READ") {
+		t.Errorf("expected the error of STREAM_TIMEOUT_READ, got %v", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config := Default()
+	config.Storage.Backend = BackendFile
+	config.Storage.Fsync = "sometimes"
+	config.TLS.Cert = "node.pem"
+	config.Timeouts.Read = -time.Second
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid config is accepted")
+	}
+	// All problems are reported at once.
+	for _, field := range []string{"listen", "peers", "storage.dir", "storage.fsync", "tls", "timeouts.read"} {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("%s is not reported in %q", field, err)
+		}
+	}
+}
+
+func TestConfig_OtherPeers(t *testing.T) {
+	// Addresses are IDs if peers have no IDs.
+	config := Default()
+	config.Listen = "localhost:7001"
+	config.NodeID = "n1"
+	config.Peers = []string{"localhost:7001", "localhost:7002"}
+	config.InsecurePeers = true
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if peers := config.OtherPeers(); !reflect.DeepEqual(peers, []Node{{ID: "localhost:7002", Address: "localhost:7002"}}) {
+		t.Errorf("unexpected peers %v", peers)
+	}
+
+	config.NodeID = ""
+	config.Peers = []string{"n1=localhost:7001", "n2=localhost:7002", "n2=localhost:7003"}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid peers are accepted")
+	}
+	for _, problem := range []string{"duplicate ID n2", "n1 has the address of the node localhost:7001"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q is not reported in %q", problem, err)
+		}
+	}
+}
+
+func TestConfig_ValidatePeerAuth(t *testing.T) {
+	config := Default()
+	config.Listen = "localhost:7001"
+	config.Peers = []string{"localhost:7001", "localhost:7002"}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cluster_secret:") {
+		t.Errorf("config without peer authentication is accepted, %v", err)
+	}
+	config.ClusterSecret = "secret"
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+	config.ClusterSecret = ""
+	config.InsecurePeers = true
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,11 +1,15 @@
 module github.com/tariel-x/stream
 
-go 1.12
+go 1.13
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
+	github.com/golang/protobuf v1.3.3
+	github.com/prometheus/client_golang v1.4.1
 	github.com/satori/go.uuid v1.2.0
 	github.com/urfave/cli v1.22.2
+	golang.org/x/crypto v0.0.0-20200220183623-bac4c82f6975
+	google.golang.org/grpc v1.27.1
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	gopkg.in/yaml.v2 v2.2.7
 )
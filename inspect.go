@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	storage "github.com/tariel-x/stream/log"
+)
+
+var dirFlag = cli.StringFlag{
+	Name:  "dir, d",
+	Usage: "Storage directory of the file backend, the node must be stopped",
+}
+
+var logCommands = []cli.Command{
+	{
+		Name:  "log",
+		Usage: "files of the file backend of the stopped node",
+		Subcommands: []cli.Command{
+			{
+				Name:   "inspect",
+				Usage:  "check checksums of records and the index and print the summary or entries in the range of epochs",
+				Action: Inspect,
+				Flags: []cli.Flag{
+					dirFlag,
+					jsonFlag,
+					cli.BoolFlag{
+						Name:  "entries, e",
+						Usage: "Print entries, the file offset, the epoch and the value",
+					},
+					cli.IntFlag{
+						Name:  "from, f",
+						Value: -1,
+						Usage: "Lowest epoch of printed entries",
+					},
+					cli.IntFlag{
+						Name:  "to, t",
+						Value: -1,
+						Usage: "Highest epoch of printed entries",
+					},
+				},
+			},
+			{
+				Name:   "repair",
+				Usage:  "truncate the corrupted tail of the data file and rebuild the index",
+				Action: Repair,
+				Flags:  []cli.Flag{dirFlag},
+			},
+		},
+	},
+}
+
+type entryLine struct {
+	Offset int64  `json:"offset"`
+	N      int    `json:"n"`
+	Value  string `json:"value"`
+}
+
+// Inspect exits with the error if files are corrupted, so it is used by
+// scripts.
+func Inspect(c *cli.Context) error {
+	dir := c.String("dir")
+	if dir == "" {
+		return cli.NewExitError("dir is required", 1)
+	}
+	var each func(storage.Record) error
+	if c.Bool("entries") {
+		p := newPrinter(c)
+		from, to := c.Int("from"), c.Int("to")
+		each = func(record storage.Record) error {
+			if (from >= 0 && record.N < from) || (to >= 0 && record.N > to) {
+				return nil
+			}
+			return p.Print(fmt.Sprintf("%d\t%d\t%s", record.Offset, record.N, record.Value),
+				entryLine{Offset: record.Offset, N: record.N, Value: record.Value})
+		}
+	}
+	report, err := storage.Inspect(dir, each)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	// The summary is not mixed with printed entries.
+	out := os.Stdout
+	if c.Bool("entries") {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "data\t%d bytes, %d valid\n", report.Size, report.Valid)
+	if report.Records > 0 {
+		fmt.Fprintf(out, "records\t%d, epochs %d-%d\n", report.Records, report.First, report.Last)
+	} else {
+		fmt.Fprintln(out, "records\t0")
+	}
+	if report.IndexError != nil {
+		fmt.Fprintf(out, "index\t%v\n", report.IndexError)
+	} else {
+		fmt.Fprintf(out, "index\t%d records, consistent\n", report.Index)
+	}
+	if report.Corrupt != nil {
+		fmt.Fprintf(out, "corrupt\t%v, %d bytes after it\n", report.Corrupt, report.Size-report.Valid)
+	}
+	if !report.OK() {
+		return cli.NewExitError(fmt.Sprintf("%s is damaged, it is repaired by log repair", dir), 1)
+	}
+	return nil
+}
+
+func Repair(c *cli.Context) error {
+	dir := c.String("dir")
+	if dir == "" {
+		return cli.NewExitError("dir is required", 1)
+	}
+	report, err := storage.Repair(dir)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if report.OK() {
+		fmt.Printf("%s is not damaged, %d records\n", dir, report.Records)
+		return nil
+	}
+	if report.Corrupt != nil {
+		fmt.Printf("%v, %d bytes are truncated\n", report.Corrupt, report.Size-report.Valid)
+	}
+	fmt.Printf("%d records are kept, epochs %d-%d, the index is rebuilt\n", report.Records, report.First, report.Last)
+	return nil
+}
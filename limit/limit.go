@@ -0,0 +1,238 @@
+// Package limit throttles clients by token buckets. Every client has its own
+// buckets of pushed messages, pushed bytes and pulled bytes per second.
+package limit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// idleClient is the time after which buckets of the unused client are
+// dropped, they are full again by then.
+const idleClient = time.Minute
+
+// Limits are rates per second, zero is unlimited. The burst of every bucket
+// is one second of the rate.
+type Limits struct {
+	PushMessages float64 `yaml:"push_messages"`
+	PushBytes    float64 `yaml:"push_bytes"`
+	PullBytes    float64 `yaml:"pull_bytes"`
+}
+
+func (l Limits) validate() error {
+	if l.PushMessages < 0 || l.PushBytes < 0 || l.PullBytes < 0 {
+		return fmt.Errorf("negative limit")
+	}
+	return nil
+}
+
+// Config are default limits and limits of authenticated users and clients
+// by the name meta. Users and names are different clients, so the name of the
+// user does not share the bucket of the user.
+type Config struct {
+	Default Limits            `yaml:"default"`
+	Users   map[string]Limits `yaml:"users"`
+	Names   map[string]Limits `yaml:"names"`
+}
+
+// Load reads the limits file, e.g.
+//
+//	default:
+//	  push_messages: 100
+//	  push_bytes: 1048576
+//	  pull_bytes: 10485760
+//	users:
+//	  producer:
+//	    push_messages: 1000
+//	names:
+//	  dashboard:
+//	    pull_bytes: 0
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("limits %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("limits %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks that limits are not negative.
+func (c *Config) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for user, limits := range c.Users {
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("user %s: %v", user, err)
+		}
+	}
+	for name, limits := range c.Names {
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("name %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+type bucket struct {
+	rate    float64
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	if rate == 0 {
+		return nil
+	}
+	return &bucket{rate: rate, tokens: rate, updated: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.updated = now
+}
+
+// delay returns the time until n tokens are available. The full bucket
+// gives any number of tokens, so requests larger than the burst pass too.
+func (b *bucket) delay(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= n || b.tokens >= b.rate {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take takes n tokens, the bucket may go to debt.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type client struct {
+	m            sync.Mutex
+	pushMessages *bucket
+	pushBytes    *bucket
+	pullBytes    *bucket
+	used         time.Time
+}
+
+// Limiter keeps buckets of clients.
+type Limiter struct {
+	config  *Config
+	now     func() time.Time
+	m       sync.Mutex
+	clients map[string]*client
+	pruned  time.Time
+}
+
+func NewLimiter(config *Config) (*Limiter, error) {
+	return &Limiter{
+		config:  config,
+		now:     time.Now,
+		clients: map[string]*client{},
+		pruned:  time.Now(),
+	}, nil
+}
+
+// client returns buckets of the user if it is authenticated or of the name
+// otherwise.
+func (l *Limiter) client(user, name string, now time.Time) *client {
+	id, key, clients := name, "name "+name, l.config.Names
+	if user != "" {
+		id, key, clients = user, "user "+user, l.config.Users
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	if now.Sub(l.pruned) > idleClient {
+		for k, c := range l.clients {
+			c.m.Lock()
+			if now.Sub(c.used) > idleClient {
+				delete(l.clients, k)
+			}
+			c.m.Unlock()
+		}
+		l.pruned = now
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		limits, ok := clients[id]
+		if !ok {
+			limits = l.config.Default
+		}
+		c = &client{
+			pushMessages: newBucket(limits.PushMessages, now),
+			pushBytes:    newBucket(limits.PushBytes, now),
+			pullBytes:    newBucket(limits.PullBytes, now),
+		}
+		l.clients[key] = c
+	}
+	c.m.Lock()
+	c.used = now
+	c.m.Unlock()
+	return c
+}
+
+// Push takes one message and the bytes of the value from buckets of the
+// client. If they are not available nothing is taken and the time to retry
+// after is returned.
+func (l *Limiter) Push(user, name string, bytes int) time.Duration {
+	now := l.now()
+	c := l.client(user, name, now)
+	c.m.Lock()
+	defer c.m.Unlock()
+	wait := c.pushMessages.delay(1, now)
+	if bytesWait := c.pushBytes.delay(float64(bytes), now); bytesWait > wait {
+		wait = bytesWait
+	}
+	if wait > 0 {
+		return wait
+	}
+	c.pushMessages.take(1, now)
+	c.pushBytes.take(float64(bytes), now)
+	return 0
+}
+
+// Pull takes the bytes of the sent value and waits while the bucket of the
+// client is in debt.
+func (l *Limiter) Pull(ctx context.Context, user, name string, bytes int) error {
+	now := l.now()
+	c := l.client(user, name, now)
+	c.m.Lock()
+	wait := c.pullBytes.take(float64(bytes), now)
+	c.m.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
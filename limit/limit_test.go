@@ -0,0 +1,84 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, config *Config) (*Limiter, *time.Time) {
+	limiter, err := NewLimiter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+	limiter.pruned = now
+	return limiter, &now
+}
+
+func TestLimiter_Push(t *testing.T) {
+	limiter, now := newTestLimiter(t, &Config{
+		Default: Limits{PushMessages: 2, PushBytes: 10},
+		Users:   map[string]Limits{"producer": {PushMessages: 10}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if wait := limiter.Push("", "a", 1); wait != 0 {
+			t.Fatalf("push %d is throttled for %s", i, wait)
+		}
+	}
+	if wait := limiter.Push("", "a", 1); wait != 500*time.Millisecond {
+		t.Errorf("expected retry after 500ms, got %s", wait)
+	}
+	if wait := limiter.Push("", "b", 1); wait != 0 {
+		t.Errorf("client b is throttled by client a for %s", wait)
+	}
+	*now = now.Add(500 * time.Millisecond)
+	if wait := limiter.Push("", "a", 1); wait != 0 {
+		t.Errorf("push after the retry is throttled for %s", wait)
+	}
+
+	// The value larger than the burst passes when the bucket is full and
+	// makes the debt.
+	if wait := limiter.Push("", "c", 15); wait != 0 {
+		t.Errorf("large push is throttled for %s", wait)
+	}
+	if wait := limiter.Push("", "c", 1); wait != 600*time.Millisecond {
+		t.Errorf("expected retry after 600ms, got %s", wait)
+	}
+
+	for i := 0; i < 10; i++ {
+		if wait := limiter.Push("producer", "", 100); wait != 0 {
+			t.Fatalf("push %d of producer is throttled for %s", i, wait)
+		}
+	}
+	// The name of the user is the other client with default limits.
+	limiter.Push("", "producer", 1)
+	limiter.Push("", "producer", 1)
+	if wait := limiter.Push("", "producer", 1); wait == 0 {
+		t.Error("name producer has limits of user producer")
+	}
+}
+
+func TestLimiter_Pull(t *testing.T) {
+	limiter, _ := newTestLimiter(t, &Config{Default: Limits{PullBytes: 1000}})
+	if err := limiter.Pull(context.Background(), "", "a", 1000); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Pull(ctx, "", "a", 1000); err != context.Canceled {
+		t.Errorf("expected the pull in debt to wait, got %v", err)
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	limiter, now := newTestLimiter(t, &Config{Default: Limits{PushMessages: 1}})
+	limiter.Push("", "a", 1)
+	*now = now.Add(2 * idleClient)
+	limiter.Push("", "b", 1)
+	if _, ok := limiter.clients["name a"]; ok {
+		t.Error("idle client is not pruned")
+	}
+}
@@ -0,0 +1,383 @@
+package log
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The file backend keeps entries in the data file and their offsets in the
+// index file. Every record of the data file is
+//
+//	length uint32 | crc32c uint32 | n int64 | value [length]byte
+//
+// where the checksum covers n and the value. Every record of the index file
+// is
+//
+//	n int64 | offset int64
+//
+// Integers are big-endian. Records are appended in the order entries are
+// set, so n is not sorted.
+const (
+	DataFile  = "log.dat"
+	IndexFile = "log.idx"
+	// LockFile is locked by the process using the directory, so the
+	// running node and log repair or restore do not write it at once.
+	LockFile = "LOCK"
+
+	recordHeader = 16
+	indexRecord  = 16
+	// maxValue limits the length read from the header, so the corrupted
+	// length does not allocate the huge buffer.
+	maxValue = 64 * 1024 * 1024
+)
+
+// Fsync policies of the file backend.
+const (
+	// FsyncAlways syncs the data file before Set returns.
+	FsyncAlways = "always"
+	// FsyncInterval syncs the data file periodically.
+	FsyncInterval = "interval"
+	// FsyncNever leaves syncing to the OS.
+	FsyncNever = "never"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrClosed is returned by Set of the closed log.
+var ErrClosed = errors.New("log is closed")
+
+// CorruptError is the record of the data file which can not be read, records
+// after it are lost.
+type CorruptError struct {
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupted record at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Record is the entry of the data file.
+type Record struct {
+	Offset int64
+	N      int
+	Value  string
+}
+
+// Checksum is CRC-32C of the big-endian 64-bit position and the value, it
+// is the checksum of records and of entries of backups.
+func Checksum(n int, v string) uint32 {
+	buf := make([]byte, 8+len(v))
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	copy(buf[8:], v)
+	return crc32.Checksum(buf, castagnoli)
+}
+
+func encodeRecord(n int, v string) []byte {
+	buf := make([]byte, recordHeader+len(v))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(v)))
+	binary.BigEndian.PutUint32(buf[4:], Checksum(n, v))
+	binary.BigEndian.PutUint64(buf[8:], uint64(n))
+	copy(buf[recordHeader:], v)
+	return buf
+}
+
+// ReadRecords reads records of the data file in the directory. Reading
+// stops at the first record which can not be read, it is returned as
+// *CorruptError together with the offset of the valid part.
+func ReadRecords(dir string, each func(Record) error) (int64, error) {
+	file, err := os.Open(filepath.Join(dir, DataFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, recordHeader)
+	for {
+		read, err := io.ReadFull(reader, header)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return offset, &CorruptError{Offset: offset, Reason: fmt.Sprintf("truncated header of %d bytes", read)}
+		}
+		if err != nil {
+			return offset, err
+		}
+		length := binary.BigEndian.Uint32(header[0:])
+		if length > maxValue {
+			return offset, &CorruptError{Offset: offset, Reason: fmt.Sprintf("length %d is too large", length)}
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(reader, value); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, &CorruptError{Offset: offset, Reason: "truncated value"}
+		} else if err != nil {
+			return offset, err
+		}
+		n := int(binary.BigEndian.Uint64(header[8:]))
+		if Checksum(n, string(value)) != binary.BigEndian.Uint32(header[4:]) {
+			return offset, &CorruptError{Offset: offset, Reason: "checksum mismatch"}
+		}
+		if err := each(Record{Offset: offset, N: n, Value: string(value)}); err != nil {
+			return offset, err
+		}
+		offset += recordHeader + int64(length)
+	}
+}
+
+// ReadIndex reads records of the index file in the directory.
+func ReadIndex(dir string) ([]Record, error) {
+	data, err := readFile(filepath.Join(dir, IndexFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%indexRecord != 0 {
+		return nil, fmt.Errorf("index size %d is not a multiple of %d", len(data), indexRecord)
+	}
+	records := make([]Record, 0, len(data)/indexRecord)
+	for i := 0; i < len(data); i += indexRecord {
+		records = append(records, Record{
+			N:      int(binary.BigEndian.Uint64(data[i:])),
+			Offset: int64(binary.BigEndian.Uint64(data[i+8:])),
+		})
+	}
+	return records, nil
+}
+
+func readFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func encodeIndex(n int, offset int64) []byte {
+	buf := make([]byte, indexRecord)
+	binary.BigEndian.PutUint64(buf[0:], uint64(n))
+	binary.BigEndian.PutUint64(buf[8:], uint64(offset))
+	return buf
+}
+
+// lockDir takes the exclusive lock of the directory, it is released by
+// closing the returned file.
+func lockDir(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("%s is locked by another process, e.g. the running node: %v", dir, err)
+	}
+	return lock, nil
+}
+
+// store appends entries to files of the directory.
+type store struct {
+	dir string
+	// lock is the lock of the directory held while the store is open.
+	lock   *os.File
+	fsync  string
+	data   *os.File
+	index  *os.File
+	offset int64
+	// records is the number of records in files, retained or not.
+	records int
+	m       sync.Mutex
+	dirty   bool
+	done    chan struct{}
+}
+
+// openStore opens files of the directory locked by the lock, the store
+// releases the lock when it is closed.
+func openStore(lock *os.File, dir, fsync string, interval time.Duration, records []Record, offset int64) (*store, error) {
+	data, err := os.OpenFile(filepath.Join(dir, DataFile), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Writes start after the last valid record.
+	if err := data.Truncate(offset); err != nil {
+		data.Close()
+		return nil, err
+	}
+	if _, err := data.Seek(offset, io.SeekStart); err != nil {
+		data.Close()
+		return nil, err
+	}
+	s := &store{
+		dir:     dir,
+		lock:    lock,
+		fsync:   fsync,
+		data:    data,
+		offset:  offset,
+		records: len(records),
+		done:    make(chan struct{}),
+	}
+	index, err := ReadIndex(dir)
+	if err != nil || checkIndex(index, records) != nil {
+		// The index is rebuilt from the data file.
+		if err := s.writeIndex(records); err != nil {
+			data.Close()
+			return nil, err
+		}
+	}
+	if s.index, err = os.OpenFile(filepath.Join(dir, IndexFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		data.Close()
+		return nil, err
+	}
+	if fsync == FsyncInterval {
+		go s.syncEvery(interval)
+	}
+	return s, nil
+}
+
+func (s *store) writeIndex(records []Record) error {
+	path := filepath.Join(s.dir, IndexFile)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		if _, err := writer.Write(encodeIndex(record.N, record.Offset)); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *store) append(n int, v string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, err := s.data.Write(encodeRecord(n, v)); err != nil {
+		return err
+	}
+	if _, err := s.index.Write(encodeIndex(n, s.offset)); err != nil {
+		return err
+	}
+	s.offset += recordHeader + int64(len(v))
+	s.records++
+	if s.fsync == FsyncAlways {
+		return s.data.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *store) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.m.Lock()
+			if s.dirty {
+				s.data.Sync()
+				s.dirty = false
+			}
+			s.m.Unlock()
+		}
+	}
+}
+
+// compact rewrites files with the retained entries.
+func (s *store) compact(first *item) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	path := filepath.Join(s.dir, DataFile)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	var records []Record
+	var offset int64
+	for cursor := first; cursor != nil; cursor = cursor.next {
+		if _, err := writer.Write(encodeRecord(cursor.n, cursor.v)); err != nil {
+			file.Close()
+			return err
+		}
+		records = append(records, Record{Offset: offset, N: cursor.n})
+		offset += recordHeader + int64(len(cursor.v))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// The index is written first, so it is rebuilt on open if the data
+	// file is not renamed.
+	if err := s.writeIndex(records); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.data.Close()
+	s.index.Close()
+	if s.data, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	if s.index, err = os.OpenFile(filepath.Join(s.dir, IndexFile), os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	s.offset = offset
+	s.records = len(records)
+	s.dirty = false
+	return nil
+}
+
+func (s *store) close() error {
+	close(s.done)
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.data.Sync(); err != nil {
+		return err
+	}
+	if err := s.data.Close(); err != nil {
+		return err
+	}
+	if err := s.index.Close(); err != nil {
+		return err
+	}
+	return s.lock.Close()
+}
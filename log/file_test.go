@@ -0,0 +1,204 @@
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func values(t *testing.T, l *Log) []string {
+	results, err := l.Get(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestOpenLog(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	l, err := OpenLog(dir, FsyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Set(ctx, 0, "a")
+	l.Set(ctx, 2, "c")
+	l.Set(ctx, 1, "b")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set(ctx, 3, "d"); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// The lost index is rebuilt.
+	os.Remove(filepath.Join(dir, IndexFile))
+	l, err = OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := values(t, l); !reflect.DeepEqual(actual, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected values %v", actual)
+	}
+	l.Set(ctx, 3, "d")
+	l.Close()
+	index, err := ReadIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 4 || index[3].N != 3 {
+		t.Errorf("unexpected index %v", index)
+	}
+}
+
+func TestOpenLog_Corrupted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := OpenLog(dir, FsyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Set(context.Background(), 0, "a")
+	l.Close()
+
+	file, err := os.OpenFile(filepath.Join(dir, DataFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0})
+	file.Close()
+	if _, err := OpenLog(dir, FsyncAlways, 0); err == nil {
+		t.Error("the corrupted log is opened")
+	}
+	offset, err := ReadRecords(dir, func(Record) error { return nil })
+	if corrupt, ok := err.(*CorruptError); !ok || corrupt.Offset != offset || offset != recordHeader+1 {
+		t.Errorf("unexpected error %v at %d", err, offset)
+	}
+}
+
+func TestLog_Retention(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	l, err := OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetRetention(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []string{"a", "b", "c", "d", "e"} {
+		if err := l.Set(ctx, i, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual := values(t, l); !reflect.DeepEqual(actual, []string{"d", "e"}) {
+		t.Errorf("unexpected values %v", actual)
+	}
+	l.Close()
+
+	// Files are compacted, so the reopened log keeps retained entries.
+	l, err = OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if count := l.Count(); count != 2 {
+		t.Errorf("files are not compacted, %d entries", count)
+	}
+	if actual := values(t, l); !reflect.DeepEqual(actual, []string{"d", "e"}) {
+		t.Errorf("unexpected values after reopening %v", actual)
+	}
+	if l.Last() != 4 {
+		t.Errorf("unexpected last %d", l.Last())
+	}
+}
+
+func TestRepair(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := OpenLog(dir, FsyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Set(context.Background(), 1, "a")
+	l.Set(context.Background(), 5, "c")
+	l.Set(context.Background(), 3, "b")
+	l.Close()
+
+	report, err := Inspect(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 3 || report.First != 1 || report.Last != 5 || report.Index != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, DataFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(encodeRecord(7, "c")[:recordHeader])
+	file.Close()
+	report, err = Repair(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Corrupt == nil || report.Size-report.Valid != recordHeader {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// The index of records of the repaired data file is consistent.
+	if report, err = Inspect(dir, nil); err != nil || !report.OK() || report.Size != report.Valid {
+		t.Errorf("the log is not repaired, %+v, %v", report, err)
+	}
+	l, err = OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if actual := values(t, l); !reflect.DeepEqual(actual, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected values %v", actual)
+	}
+}
+
+func TestOpenLog_Locked(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The directory of the open log is not opened, inspected or repaired.
+	if _, err := OpenLog(dir, FsyncNever, 0); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected the lock error, got %v", err)
+	}
+	if _, err := Inspect(dir, nil); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected the lock error, got %v", err)
+	}
+	if _, err := Repair(dir); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected the lock error, got %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = OpenLog(dir, FsyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Report is the state of files of the file backend.
+type Report struct {
+	// Size is the size of the data file, Valid is the size of its part
+	// which is read.
+	Size  int64
+	Valid int64
+	// Records is the number of valid records, First and Last are their
+	// lowest and highest positions.
+	Records int
+	First   int
+	Last    int
+	// Corrupt is the first record which can not be read.
+	Corrupt *CorruptError
+	// Index is the number of records of the index file, IndexError is the
+	// first problem of the index.
+	Index      int
+	IndexError error
+}
+
+// OK returns true if the data file is read to the end and the index is
+// consistent with it.
+func (r *Report) OK() bool {
+	return r.Corrupt == nil && r.IndexError == nil
+}
+
+// Inspect reads files of the file backend in the directory without opening
+// the log. Valid records are passed to each if it is not nil, corrupted
+// files are reported, not returned as errors.
+func Inspect(dir string, each func(Record) error) (*Report, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	report, _, err := inspect(dir, each)
+	return report, err
+}
+
+func inspect(dir string, each func(Record) error) (*Report, []Record, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, nil, err
+	}
+	report := &Report{}
+	if info, err := os.Stat(filepath.Join(dir, DataFile)); err == nil {
+		report.Size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	var records []Record
+	offset, err := ReadRecords(dir, func(record Record) error {
+		if len(records) == 0 || record.N < report.First {
+			report.First = record.N
+		}
+		if len(records) == 0 || record.N > report.Last {
+			report.Last = record.N
+		}
+		records = append(records, Record{Offset: record.Offset, N: record.N})
+		if each != nil {
+			return each(record)
+		}
+		return nil
+	})
+	if corrupt, ok := err.(*CorruptError); ok {
+		report.Corrupt = corrupt
+	} else if err != nil {
+		return nil, nil, err
+	}
+	report.Valid, report.Records = offset, len(records)
+
+	index, err := ReadIndex(dir)
+	report.Index = len(index)
+	if err != nil {
+		report.IndexError = err
+	} else {
+		report.IndexError = checkIndex(index, records)
+	}
+	return report, records, nil
+}
+
+// Repair truncates the corrupted tail of the data file and rebuilds the
+// inconsistent index. The node must be stopped. The report is of files
+// before the repair.
+func Repair(dir string) (*Report, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	report, records, err := inspect(dir, nil)
+	if err != nil || report.OK() {
+		lock.Close()
+		return report, err
+	}
+	s, err := openStore(lock, dir, FsyncNever, 0, records, report.Valid)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return report, s.close()
+}
+
+// checkIndex returns the first record of the index which does not match
+// the data file.
+func checkIndex(index, records []Record) error {
+	for i := range index {
+		if i >= len(records) {
+			return fmt.Errorf("index has %d records, data file has %d", len(index), len(records))
+		}
+		if index[i].N != records[i].N || index[i].Offset != records[i].Offset {
+			return fmt.Errorf("index record %d is position %d at offset %d, data file has position %d at offset %d",
+				i, index[i].N, index[i].Offset, records[i].N, records[i].Offset)
+		}
+	}
+	if len(index) < len(records) {
+		return fmt.Errorf("index has %d records, data file has %d", len(index), len(records))
+	}
+	return nil
+}
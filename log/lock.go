@@ -0,0 +1,14 @@
+// +build !windows
+
+package log
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes the exclusive lock of the file without waiting, the lock is
+// released when the file is closed.
+func flock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,11 @@
+package log
+
+import (
+	"os"
+)
+
+// flock does not lock the file on Windows, files of the storage directory
+// are opened by one process by convention.
+func flock(file *os.File) error {
+	return nil
+}
@@ -3,8 +3,10 @@ package log
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type item struct {
@@ -14,10 +16,23 @@ type item struct {
 	previous *item
 }
 
+// maxPending limits entries queued to the PULL subscriber, so the slow
+// subscriber does not buffer the whole stream.
+const maxPending = 1024
+
+// wait is the PULL subscriber. Set queues new entries from the position of
+// the subscriber to pending and wakes it up, so the subscriber does not
+// scan the log again. When pending is full, it is dropped and the
+// subscriber reads entries from the log again from the position after the
+// last sent entry.
 type wait struct {
-	c      chan *item
-	count  uint64
-	border *item
+	c    chan struct{}
+	from int
+	// pending, behind and queued are guarded by the lock of the log.
+	pending []item
+	behind  bool
+	queued  uint64
+	sent    *uint64
 }
 
 type Log struct {
@@ -25,46 +40,237 @@ type Log struct {
 	last        *item
 	m           sync.RWMutex
 	count       uint64
-	waitlist    map[uint64]wait
+	size        uint64
+	waitlist    map[uint64]*wait
 	connections *uint64
+	// store persists entries, the log is in memory only if it is nil.
+	store      *store
+	closed     bool
+	maxEntries uint64
+	maxBytes   uint64
 }
 
 func NewLog() (*Log, error) {
 	l := &Log{
 		m:           sync.RWMutex{},
-		waitlist:    map[uint64]wait{},
+		waitlist:    map[uint64]*wait{},
 		connections: new(uint64),
 	}
 	atomic.StoreUint64(l.connections, 0)
 	return l, nil
 }
 
+// OpenLog opens the log persisted in the directory by the file backend.
+// Entries are loaded to memory, the corrupted tail of the data file is
+// reported and the log is not opened.
+func OpenLog(dir, fsync string, interval time.Duration) (*Log, error) {
+	switch fsync {
+	case FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid fsync interval %s", interval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", fsync)
+	}
+	l, err := NewLog()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	offset, err := ReadRecords(dir, func(record Record) error {
+		l.set(record.N, record.Value)
+		records = append(records, Record{Offset: record.Offset, N: record.N})
+		return nil
+	})
+	if _, ok := err.(*CorruptError); ok {
+		lock.Close()
+		return nil, fmt.Errorf("%s: %v, the tail can be truncated by log repair", dir, err)
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if l.store, err = openStore(lock, dir, fsync, interval, records, offset); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetRetention limits entries kept by the log, the oldest entries over the
+// limits are dropped. Zero is unlimited.
+func (l *Log) SetRetention(maxEntries, maxBytes uint64) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.maxEntries, l.maxBytes = maxEntries, maxBytes
+	return l.retain()
+}
+
+// retain drops the oldest entries over the retention limits. The last entry
+// is always kept. Entries are dropped even if PULL subscribers have not read
+// them: connected subscribers keep up to maxPending entries queued to them,
+// but subscribers which pull, resume or fall behind from dropped positions
+// silently start from the first kept entry.
+func (l *Log) retain() error {
+	dropped := false
+	for l.first != nil && l.first != l.last &&
+		((l.maxEntries > 0 && l.count > l.maxEntries) || (l.maxBytes > 0 && l.size > l.maxBytes)) {
+		l.count--
+		l.size -= uint64(len(l.first.v))
+		l.first = l.first.next
+		l.first.previous = nil
+		dropped = true
+	}
+	// Files are rewritten when most of their records are dropped.
+	if dropped && l.store != nil && uint64(l.store.records) > 2*l.count {
+		return l.store.compact(l.first)
+	}
+	return nil
+}
+
+// Close syncs and closes files of the log.
+func (l *Log) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed || l.store == nil {
+		l.closed = true
+		return nil
+	}
+	l.closed = true
+	return l.store.close()
+}
+
 func (l *Log) removeWait(i uint64) {
 	l.m.Lock()
 	defer l.m.Unlock()
 	delete(l.waitlist, i)
 }
 
-func (l *Log) addWait(w wait) uint64 {
+// addWait queues entries from the position of the subscriber and adds it
+// under the same lock, so no entry is missed or queued twice.
+func (l *Log) addWait(w *wait) uint64 {
 	l.m.Lock()
 	defer l.m.Unlock()
+	for cursor := l.first; cursor != nil; cursor = cursor.next {
+		if cursor.n >= w.from {
+			w.queued++
+		}
+	}
+	l.refill(w, w.from)
 	i := atomic.AddUint64(l.connections, 1)
 	l.waitlist[i] = w
 	return i
 }
 
+// refill queues up to maxPending entries from the position to the
+// subscriber. The subscriber stays behind and is woken up again if there
+// are more entries. It is called under the lock of the log.
+func (l *Log) refill(w *wait, from int) {
+	w.pending = nil
+	w.behind = false
+	for cursor := l.first; cursor != nil; cursor = cursor.next {
+		if cursor.n < from {
+			continue
+		}
+		if len(w.pending) == maxPending {
+			w.behind = true
+			select {
+			case w.c <- struct{}{}:
+			default:
+			}
+			return
+		}
+		w.pending = append(w.pending, item{n: cursor.n, v: cursor.v})
+	}
+}
+
+// Count returns the number of entries.
+func (l *Log) Count() uint64 {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.count
+}
+
+// Size returns the total size of values in bytes.
+func (l *Log) Size() uint64 {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.size
+}
+
+// Last returns the number of the last entry or 0 if the log is empty.
+func (l *Log) Last() int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	if l.last == nil {
+		return 0
+	}
+	return l.last.n
+}
+
+// Lags returns the number of entries which are not sent yet to every PULL
+// subscriber.
+func (l *Log) Lags() []uint64 {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	lags := make([]uint64, 0, len(l.waitlist))
+	for _, w := range l.waitlist {
+		entries := w.queued
+		sent := atomic.LoadUint64(w.sent)
+		if sent > entries {
+			sent = entries
+		}
+		lags = append(lags, entries-sent)
+	}
+	return lags
+}
+
 func (l *Log) Set(ctx context.Context, n int, v string) error {
 	l.m.Lock()
 	defer l.m.Unlock()
-	defer func() {
-		for _, w := range l.waitlist {
-			w.c <- l.last
+	if l.closed {
+		return ErrClosed
+	}
+	// The entry is persisted before it is seen by readers.
+	if l.store != nil {
+		if err := l.store.append(n, v); err != nil {
+			return err
 		}
-	}()
+	}
+	for _, w := range l.waitlist {
+		if n < w.from {
+			continue
+		}
+		w.queued++
+		switch {
+		case w.behind:
+		case len(w.pending) == maxPending:
+			// The subscriber reads the log again from its position.
+			w.pending = nil
+			w.behind = true
+		default:
+			w.pending = append(w.pending, item{n: n, v: v})
+		}
+		select {
+		case w.c <- struct{}{}:
+		default:
+		}
+	}
+	l.set(n, v)
+	return l.retain()
+}
+
+func (l *Log) set(n int, v string) {
 	l.count++
+	l.size += uint64(len(v))
 	if l.first == nil || l.last == nil {
 		l.init(n, v)
-		return nil
+		return
 	}
 
 	// Search correct position.
@@ -74,14 +280,20 @@ func (l *Log) Set(ctx context.Context, n int, v string) error {
 	for cursor.previous != nil && cursor.n >= n {
 		cursor = cursor.previous
 	}
+	// The entry is before the first, entries are kept in the order of
+	// positions.
+	if cursor.n >= n {
+		l.first = &item{n: n, v: v, next: cursor}
+		cursor.previous = l.first
+		return
+	}
 	// Found element is the last.
 	if l.last == cursor && cursor.next == nil {
 		l.append(n, v)
-		return nil
+		return
 	}
 	// Insert in the middle of the list.
 	l.insert(cursor, cursor.next, n, v)
-	return nil
 }
 
 func (l *Log) init(n int, v string) {
@@ -132,7 +344,7 @@ func (l *Log) Get(ctx context.Context, n int) ([]string, error) {
 	if cursor == nil {
 		return nil, nil
 	}
-	for cursor.n < n {
+	for cursor != nil && cursor.n < n {
 		cursor = cursor.next
 	}
 	var results []string
@@ -152,56 +364,75 @@ func (l *Log) Get(ctx context.Context, n int) ([]string, error) {
 	return results, nil
 }
 
+// Entries passes entries in positions from-to with their positions to
+// each. Entries are copied first, so they are the snapshot of the log and
+// each does not block Set.
+func (l *Log) Entries(ctx context.Context, from, to int, each func(n int, v string) error) error {
+	if from < 0 || to < from {
+		return errors.New("invalid range")
+	}
+	l.m.RLock()
+	var entries []item
+	for cursor := l.first; cursor != nil && cursor.n <= to; cursor = cursor.next {
+		if cursor.n >= from {
+			entries = append(entries, item{n: cursor.n, v: cursor.v})
+		}
+	}
+	l.m.RUnlock()
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := each(entry.n, entry.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (l *Log) Pull(ctx context.Context, n int) (chan string, error) {
 	if n < 0 {
 		return nil, errors.New("invalid n")
 	}
-	w := wait{
-		c:      make(chan *item, l.count),
-		border: l.last,
+	w := &wait{
+		c:    make(chan struct{}, 1),
+		from: n,
+		sent: new(uint64),
 	}
 	thiswait := l.addWait(w)
 
 	results := make(chan string)
 	go func() {
 		defer close(results)
-		defer close(w.c)
 		defer l.removeWait(thiswait)
 
-		l.m.RLock()
-		cursor := l.first
-		for cursor != nil && cursor.n < n {
-			cursor = cursor.next
-		}
-
-		alreadySent := map[int]struct{}{}
-		for cursor != nil && cursor.n <= w.border.n {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		// next is the position after the last sent entry.
+		next := n
+		for {
+			l.m.Lock()
+			if w.behind {
+				l.refill(w, next)
 			}
-			if cursor.n < n {
-				continue
+			values := w.pending
+			w.pending = nil
+			l.m.Unlock()
+
+			for _, v := range values {
+				select {
+				case <-ctx.Done():
+					return
+				case results <- v.v:
+					atomic.AddUint64(w.sent, 1)
+					if v.n >= next {
+						next = v.n + 1
+					}
+				}
 			}
-			results <- cursor.v
-			alreadySent[cursor.n] = struct{}{}
-			cursor = cursor.next
-		}
-		l.m.RUnlock()
 
-		for {
 			select {
 			case <-ctx.Done():
 				return
-			case new, ok := <-w.c:
-				if !ok {
-					return
-				}
-				if _, ok := alreadySent[new.n]; ok {
-					continue
-				}
-				results <- new.v
+			case <-w.c:
 			}
 		}
 	}()
@@ -2,7 +2,11 @@ package log
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLog_Set(t *testing.T) {
@@ -28,3 +32,142 @@ func TestLog_Set(t *testing.T) {
 		}
 	}
 }
+
+func TestLog_Pull(t *testing.T) {
+	l, _ := NewLog()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.Set(ctx, 0, "a")
+	l.Set(ctx, 2, "c")
+
+	results, err := l.Pull(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries set after PULL are sent once, entries before n are not.
+	l.Set(ctx, 4, "e")
+	l.Set(ctx, 0, "x")
+	l.Set(ctx, 3, "d")
+
+	expected := []string{"c", "e", "d"}
+	for _, e := range expected {
+		if v := <-results; v != e {
+			t.Errorf("%s != %s", e, v)
+		}
+	}
+	select {
+	case v := <-results:
+		t.Errorf("unexpected %s", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestLog_PullRetention(t *testing.T) {
+	l, _ := NewLog()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.SetRetention(1, 0)
+	results, err := l.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connected subscriber gets dropped entries queued to it.
+	for i, v := range []string{"a", "b", "c"} {
+		l.Set(ctx, i, v)
+	}
+	for _, e := range []string{"a", "b", "c"} {
+		if v := <-results; v != e {
+			t.Errorf("%s != %s", e, v)
+		}
+	}
+
+	// The new subscriber starts from the first kept entry.
+	results, err = l.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := <-results; v != "c" {
+		t.Errorf("c != %s", v)
+	}
+}
+
+func TestLog_PullBehind(t *testing.T) {
+	l, _ := NewLog()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	total := 3*maxPending + 10
+	for i := 0; i < total/2; i++ {
+		l.Set(ctx, i, fmt.Sprint(i))
+	}
+	results, err := l.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscriber does not read, so the queue is dropped.
+	for i := total / 2; i < total; i++ {
+		l.Set(ctx, i, fmt.Sprint(i))
+	}
+	l.m.RLock()
+	for _, w := range l.waitlist {
+		if len(w.pending) > maxPending {
+			t.Errorf("%d entries are queued", len(w.pending))
+		}
+	}
+	l.m.RUnlock()
+
+	// Entries are read from the log again, each once and in order.
+	for i := 0; i < total; i++ {
+		if v := <-results; v != fmt.Sprint(i) {
+			t.Fatalf("%d != %s", i, v)
+		}
+	}
+	select {
+	case v := <-results:
+		t.Errorf("unexpected %s", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestLog_Entries(t *testing.T) {
+	l, _ := NewLog()
+	ctx := context.Background()
+	l.Set(ctx, 3, "b")
+	l.Set(ctx, 1, "a")
+	l.Set(ctx, 7, "c")
+
+	var entries []string
+	err := l.Entries(ctx, 2, 7, func(n int, v string) error {
+		entries = append(entries, fmt.Sprintf("%d %s", n, v))
+		// Entries are the snapshot, so each may change the log.
+		return l.Set(ctx, n+10, v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"3 b", "7 c"}; !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = l.Entries(ctx, 0, 10, func(n int, v string) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected the error of each, got %v after %d calls", err, calls)
+	}
+	if err := l.Entries(ctx, -1, 10, func(int, string) error { return nil }); err == nil {
+		t.Error("negative position is accepted")
+	}
+
+	// Entries after to are not passed.
+	entries = nil
+	l.Entries(ctx, 0, 12, func(n int, v string) error {
+		entries = append(entries, fmt.Sprintf("%d %s", n, v))
+		return nil
+	})
+	if expected := []string{"1 a", "3 b", "7 c"}; !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+}
@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// LevelHandler shows the level on GET and changes it on PUT or POST with
+// the level query parameter, e.g. PUT /loglevel?level=debug. The handler has
+// no authentication, so changes are refused unless writable is set.
+func LevelHandler(l *Logger, writable bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if !writable {
+				http.Error(w, "changing the log level is disabled", http.StatusForbidden)
+				return
+			}
+			level, err := ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			l.SetLevel(level)
+			l.Info("log level changed", "level", level.String())
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, l.Level())
+	})
+}
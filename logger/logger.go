@@ -0,0 +1,231 @@
+// Package logger implements the leveled logger with key/value fields.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return strconv.Itoa(int(l))
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(levelName, name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+// PayloadKey is the key of fields which carry user values. They are
+// redacted unless payloads are enabled.
+const PayloadKey = "payload"
+
+// output is shared by the logger and all its children, so the level
+// changed on any of them is changed for all.
+type output struct {
+	m        sync.Mutex
+	w        io.Writer
+	level    int32
+	json     bool
+	payloads int32
+}
+
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates the logger writing lines to w. JSON enables JSON lines
+// instead of the text key=value format.
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{
+		out: &output{
+			w:     w,
+			level: int32(level),
+			json:  json,
+		},
+	}
+}
+
+// Nop creates the logger which writes nothing.
+func Nop() *Logger {
+	return New(ioutil.Discard, LevelError+1, false)
+}
+
+// With returns the child logger which adds the fields to every line.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{
+		out:    l.out,
+		fields: fields,
+	}
+}
+
+// Component returns the child logger of the component.
+func (l *Logger) Component(name string) *Logger {
+	return l.With("component", name)
+}
+
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.out.level, int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.out.level))
+}
+
+// SetPayloads enables logging of payload fields.
+func (l *Logger) SetPayloads(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&l.out.payloads, v)
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Write implements io.Writer, so the standard logger can write to it.
+func (l *Logger) Write(p []byte) (int, error) {
+	l.Info(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	payloads := atomic.LoadInt32(&l.out.payloads) == 1
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if key, ok := fields[i].(string); ok && key == PayloadKey && !payloads {
+			fields[i+1] = redact(fields[i+1])
+		}
+		if err, ok := fields[i+1].(error); ok {
+			message := err.Error()
+			if !payloads {
+				message = redactQuoted(message)
+			}
+			fields[i+1] = message
+		}
+	}
+
+	var line []byte
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if l.out.json {
+		line = formatJSON(now, level, msg, fields)
+	} else {
+		line = formatText(now, level, msg, fields)
+	}
+
+	l.out.m.Lock()
+	defer l.out.m.Unlock()
+	l.out.w.Write(line)
+}
+
+func redact(v interface{}) string {
+	return fmt.Sprintf("[redacted %d bytes]", len(fmt.Sprint(v)))
+}
+
+var quoted = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// redactQuoted redacts quoted parts of error messages, they carry arguments
+// of requests, e.g. strconv.Atoi: parsing "value": invalid syntax.
+func redactQuoted(s string) string {
+	return quoted.ReplaceAllStringFunc(s, func(q string) string {
+		return redact(q[1 : len(q)-1])
+	})
+}
+
+func formatJSON(now string, level Level, msg string, fields []interface{}) []byte {
+	object := map[string]interface{}{
+		"time":  now,
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		object[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	line, err := json.Marshal(object)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":  now,
+			"level": level.String(),
+			"msg":   msg,
+			"error": err.Error(),
+		})
+	}
+	return append(line, '\n')
+}
+
+func formatText(now string, level Level, msg string, fields []interface{}) []byte {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "%s %-5s %s", now, strings.ToUpper(level.String()), quote(msg))
+	for i := 0; i < len(fields); i += 2 {
+		fmt.Fprintf(builder, " %s=%s", fields[i], quote(fmt.Sprint(fields[i+1])))
+	}
+	builder.WriteByte('\n')
+	return []byte(builder.String())
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
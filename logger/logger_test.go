@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLogger_Text(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	l := New(buffer, LevelInfo, false).Component("server")
+	l.Debug("skipped")
+	l.Info("request", "cmd", "PUSH", PayloadKey, "secret value")
+
+	line := buffer.String()
+	if strings.Contains(line, "skipped") {
+		t.Errorf("debug line is written: %s", line)
+	}
+	for _, expected := range []string{"INFO", "component=server", "cmd=PUSH", `payload="[redacted 12 bytes]"`} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("%s is not found in %s", expected, line)
+		}
+	}
+
+	buffer.Reset()
+	l.SetLevel(LevelDebug)
+	l.SetPayloads(true)
+	l.Debug("request", PayloadKey, "value")
+	if !strings.Contains(buffer.String(), "payload=value") {
+		t.Errorf("payload is not written: %s", buffer.String())
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	l := New(buffer, LevelDebug, true).With("node", "a")
+	l.Warn("quorum failed", "n", 5)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "warn" || line["msg"] != "quorum failed" || line["node"] != "a" || line["n"] != float64(5) {
+		t.Errorf("unexpected line %v", line)
+	}
+}
+
+func TestLogger_RedactErrors(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	l := New(buffer, LevelInfo, false)
+	_, err := strconv.Atoi("secret")
+	l.Info("request failed", "error", err)
+	if strings.Contains(buffer.String(), "secret") || !strings.Contains(buffer.String(), "[redacted 6 bytes]") {
+		t.Errorf("payload is not redacted: %s", buffer.String())
+	}
+
+	buffer.Reset()
+	l.SetPayloads(true)
+	l.Info("request failed", "error", err)
+	if !strings.Contains(buffer.String(), "secret") {
+		t.Errorf("payload is redacted: %s", buffer.String())
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	l := New(&bytes.Buffer{}, LevelInfo, false)
+	request := func(handler http.Handler, method string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, "/loglevel?level=debug", nil))
+		return recorder.Code
+	}
+	if code := request(LevelHandler(l, false), http.MethodPut); code != http.StatusForbidden || l.Level() != LevelInfo {
+		t.Errorf("level is changed, %d", code)
+	}
+	if code := request(LevelHandler(l, false), http.MethodGet); code != http.StatusOK {
+		t.Errorf("unexpected code %d", code)
+	}
+	if code := request(LevelHandler(l, true), http.MethodPut); code != http.StatusOK || l.Level() != LevelDebug {
+		t.Errorf("level is not changed, %d", code)
+	}
+}
@@ -2,29 +2,180 @@ package main
 
 import (
 	"context"
-	"errors"
+	"crypto/rand"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 
+	"github.com/tariel-x/stream/auth"
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/cluster"
+	"github.com/tariel-x/stream/config"
+	"github.com/tariel-x/stream/limit"
 	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/metrics"
 	"github.com/tariel-x/stream/paxos"
 	"github.com/tariel-x/stream/server"
 	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
 )
 
 var backgroundContext context.Context
 
+// runFlags are flags of the run command, they override the config file.
+var runFlags = append([]cli.Flag{
+	configFlag,
+	cli.StringFlag{
+		Name:  "cluster-id",
+		Usage: "ID of the cluster made by init, peers of other clusters are rejected",
+	},
+	cli.StringFlag{
+		Name:  "node-id",
+		Usage: "ID of the node, the advertise address if empty",
+	},
+	cli.StringFlag{
+		Name:  "nodes, n",
+		Usage: "List of nodes separated by comma ','. Current node would be skipped.",
+	},
+	cli.StringFlag{
+		Name:  "listen, l",
+		Usage: "Listen interface:port",
+	},
+	cli.StringFlag{
+		Name:  "advertise, a",
+		Usage: "Address of the node in the nodes list if it differs from the listen address, e.g. the proxy address",
+	},
+	cli.StringFlag{
+		Name:  "http",
+		Usage: "Listen interface:port of the HTTP/JSON gateway, disabled if empty",
+	},
+	cli.StringFlag{
+		Name:  "http-origins",
+		Usage: "Origins of pages allowed to open the WebSocket besides the same origin separated by comma ',', * allows any",
+	},
+	cli.StringFlag{
+		Name:  "grpc",
+		Usage: "Listen interface:port of the gRPC API, disabled if empty",
+	},
+	cli.StringFlag{
+		Name:  "metrics, m",
+		Usage: "Listen interface:port of the HTTP metrics endpoint, disabled if empty",
+	},
+	cli.StringFlag{
+		Name:  "storage",
+		Value: config.BackendMemory,
+		Usage: "Storage backend: memory or file",
+	},
+	cli.StringFlag{
+		Name:  "dir",
+		Usage: "Directory of the file backend",
+	},
+	cli.StringFlag{
+		Name:  "fsync",
+		Value: storage.FsyncAlways,
+		Usage: "Fsync policy of the file backend: always, interval or never",
+	},
+	cli.DurationFlag{
+		Name:  "fsync-interval",
+		Value: time.Second,
+		Usage: "Interval of fsync by the interval policy",
+	},
+	cli.Uint64Flag{
+		Name:  "retention-entries",
+		Usage: "Maximum entries kept by the node, older entries are dropped, 0 is unlimited",
+	},
+	cli.Uint64Flag{
+		Name:  "retention-bytes",
+		Usage: "Maximum total size of values kept by the node, older entries are dropped, 0 is unlimited",
+	},
+	cli.StringFlag{
+		Name:  "log-level",
+		Value: "info",
+		Usage: "Log level: debug, info, warn or error. Can be changed by PUT /loglevel?level= on the metrics endpoint if --log-runtime is set",
+	},
+	cli.BoolFlag{
+		Name:  "log-json",
+		Usage: "Write logs as JSON lines",
+	},
+	cli.BoolFlag{
+		Name:  "log-runtime",
+		Usage: "Allow changing the log level by PUT /loglevel, the metrics endpoint has no authentication",
+	},
+	cli.BoolFlag{
+		Name:  "log-payloads",
+		Usage: "Write pushed and sent values to logs instead of redacting them",
+	},
+	cli.StringFlag{
+		Name:  "trace-exporter",
+		Value: "none",
+		Usage: "Exporter of trace spans: none, stdout, file or otlp",
+	},
+	cli.StringFlag{
+		Name:  "trace-file",
+		Value: "spans.jsonl",
+		Usage: "File to write spans by the file exporter",
+	},
+	cli.StringFlag{
+		Name:  "trace-endpoint",
+		Value: "http://localhost:4318/v1/traces",
+		Usage: "OTLP/HTTP endpoint of the collector for the otlp exporter",
+	},
+	cli.StringFlag{
+		Name:  "credentials",
+		Usage: "YAML file of users and their permissions, clients are not authenticated if empty",
+	},
+	cli.StringFlag{
+		Name:  "limits",
+		Usage: "YAML file of rate limits of PUSH and PULL per client, clients are not limited if empty",
+	},
+	cli.IntFlag{
+		Name:  "max-connections",
+		Value: 1024,
+		Usage: "Maximum open connections of the line protocol, 0 is unlimited",
+	},
+	cli.DurationFlag{
+		Name:  "peer-timeout",
+		Value: 20 * time.Second,
+		Usage: "Timeout of connections to peers",
+	},
+	cli.DurationFlag{
+		Name:  "read-timeout",
+		Value: 30 * time.Second,
+		Usage: "Time to read the request, idle connections are closed after it, 0 is unlimited",
+	},
+	cli.DurationFlag{
+		Name:  "write-timeout",
+		Value: 10 * time.Second,
+		Usage: "Time to write every reply line or WebSocket frame, stuck clients are disconnected after it, 0 is unlimited",
+	},
+	cli.DurationFlag{
+		Name:  "drain-timeout",
+		Value: 10 * time.Second,
+		Usage: "Time to finish running requests on SIGTERM before they are cancelled",
+	},
+	cli.StringFlag{
+		Name:  "cluster-secret",
+		Usage: "Secret shared by nodes, peer requests are signed by it and PREPARE, ACCEPT and SET without the signature are rejected",
+	},
+	cli.BoolFlag{
+		Name:  "insecure-peers",
+		Usage: "Accept PREPARE, ACCEPT and SET of any client without TLS and the cluster secret, e.g. for the local cluster",
+	},
+}, append(tlsFlags, tlsPeerCAFlag)...)
+
 func main() {
 	log.SetOutput(os.Stdout)
 
 	app := cli.NewApp()
 	app.Name = "Στρεαμ"
-	app.Version = "0.1"
+	app.Version = stream.Version
 	app.Usage = "Στρεαμ is distributed log made with Paxos"
 
 	app.Commands = []cli.Command{
@@ -33,18 +184,40 @@ func main() {
 			Aliases: []string{"r"},
 			Usage:   "run node",
 			Action:  Run,
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:  "nodes, n",
-					Usage: "List of nodes separated by comma ','. Current node would be skipped.",
-				},
-				cli.StringFlag{
-					Name:  "listen, l",
-					Usage: "Listen interface:port",
-				},
-			},
+			Flags:   runFlags,
 		},
 	}
+	app.Commands = append(app.Commands, cli.Command{
+		Name:   "init",
+		Usage:  "create the cluster ID and keep it in the storage directory, it is printed",
+		Action: Init,
+		Flags:  runFlags,
+	}, cli.Command{
+		Name:   "join",
+		Usage:  "get the cluster ID and nodes of the cluster from the seed node and print nodes to run with",
+		Action: Join,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "seed, s",
+				Usage: "Address of the node of the cluster",
+			},
+		}, runFlags...),
+	}, cli.Command{
+		Name:  "config",
+		Usage: "configuration of the node",
+		Subcommands: []cli.Command{
+			{
+				Name:   "check",
+				Usage:  "validate the config of the run command, it takes the same flags",
+				Action: CheckConfig,
+				Flags:  runFlags,
+			},
+		},
+	})
+	app.Commands = append(app.Commands, clientCommands...)
+	app.Commands = append(app.Commands, backupCommands...)
+	app.Commands = append(app.Commands, logCommands...)
+	app.Commands = append(app.Commands, compareCommand)
 
 	// listen signals
 	ctx, cancel := context.WithCancel(context.Background())
@@ -67,42 +240,230 @@ func main() {
 	}
 }
 
+func newLogger(conf config.Log) (*logger.Logger, error) {
+	level, err := logger.ParseLevel(conf.Level)
+	if err != nil {
+		return nil, err
+	}
+	appLogger := logger.New(os.Stdout, level, conf.JSON)
+	appLogger.SetPayloads(conf.Payloads)
+	return appLogger, nil
+}
+
+func newTracer(conf config.Trace, service string, lg *logger.Logger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch conf.Exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter = tracing.NewWriterExporter(file)
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(conf.Endpoint, service)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", conf.Exporter)
+	}
+	tracingLogger := lg.Component("tracing")
+	return tracing.NewTracer(exporter, func(err error) {
+		tracingLogger.Warn("error exporting spans", "error", err)
+	}), nil
+}
+
 func Run(c *cli.Context) error {
-	listenAddress := c.String("listen")
-	if listenAddress == "" {
-		return errors.New("invalid listen address")
+	conf, err := nodeConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	appLogger, err := newLogger(conf.Log)
+	if err != nil {
+		return err
+	}
+	log.SetFlags(0)
+	log.SetOutput(appLogger)
+
+	nodes := conf.OtherPeers()
+	name := conf.Name()
+
+	tracer, err := newTracer(conf.Trace, name, appLogger)
+	if err != nil {
+		return err
+	}
+	if tracer != nil {
+		tracing.SetTracer(tracer)
+		defer tracer.Shutdown()
+	}
+
+	serverTLS, peerTLS, err := nodeTLS(conf.TLS)
+	if err != nil {
+		return err
+	}
+
+	var secret []byte
+	if conf.ClusterSecret != "" {
+		secret = []byte(conf.ClusterSecret)
+	}
+
+	clusterID, err := nodeClusterID(conf)
+	if err != nil {
+		return err
+	}
+	if clusterID == "" {
+		appLogger.Warn("cluster ID is not set, run init or join to reject peers of other clusters")
 	}
 
-	nodesListString := c.String("nodes")
-	if nodesListString == "" {
-		return errors.New("invalid nodes list")
+	// Peers are known by IDs, their addresses are resolved by the book on
+	// every connection.
+	addresses := map[string]string{}
+	for _, node := range nodes {
+		addresses[node.ID] = node.Address
+	}
+	book, err := cluster.NewAddressBook(addresses)
+	if err != nil {
+		return err
 	}
-	allNodes := strings.Split(nodesListString, ",")
-	nodes := make([]string, 0, len(allNodes)-1)
-	for _, node := range allNodes {
-		if node != listenAddress {
-			nodes = append(nodes, node)
+
+	// Clients of peers are shared by paxos and STATUS.
+	paxosNodes := make([]paxos.Node, 0, len(nodes))
+	peers := make([]stream.Peer, 0, len(nodes))
+	for _, node := range nodes {
+		peer, err := client.New(node.Address, &conf.Timeouts.Peer)
+		if err != nil {
+			return err
 		}
+		peer.ID = node.ID
+		peer.Resolver = book
+		peer.Negotiate = true
+		peer.SetNode(name, conf.Address())
+		peer.SetCluster(clusterID)
+		peer.Logger = appLogger.Component("client")
+		peer.TLS = peerTLS
+		peer.Secret = secret
+		paxosNodes = append(paxosNodes, peer)
+		peers = append(peers, peer)
 	}
 
-	pxs, err := paxos.NewPaxos(nodes, listenAddress)
+	pxs, err := paxos.NewPaxosWithNodes(paxosNodes, rand.Reader, appLogger)
 	if err != nil {
 		return err
 	}
 
-	lg, err := storage.NewLog()
+	lg, err := openLog(conf.Storage)
 	if err != nil {
 		return err
 	}
+	if lg.Count() > 0 {
+		pxs.Restore(lg.Last())
+	}
+	defer func() {
+		if err := lg.Close(); err != nil {
+			appLogger.Error("error closing log", "error", err)
+		}
+	}()
+
+	if metricsAddress := conf.Metrics; metricsAddress != "" {
+		registerLogMetrics(lg)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+		mux.Handle("/loglevel", logger.LevelHandler(appLogger, conf.Log.Runtime))
+		go serveHTTP(backgroundContext, metricsAddress, mux, appLogger.Component("http"))
+	}
 
-	hndlr, err := stream.NewHandler(lg, pxs)
+	hndlr, err := stream.NewHandler(lg, pxs, appLogger)
 	if err != nil {
 		return err
 	}
+	hndlr.SetName(name)
+	hndlr.SetAddress(conf.Address())
+	hndlr.SetAddressBook(book)
+	hndlr.SetCluster(clusterID)
+	hndlr.SetPeers(peers)
+	// Peer commands of any client are accepted only if it is set explicitly.
+	if !conf.InsecurePeers || serverTLS != nil || secret != nil {
+		hndlr.RequirePeerAuth()
+	}
+	if credentialsFile := conf.Credentials; credentialsFile != "" {
+		credentials, err := auth.Load(credentialsFile)
+		if err != nil {
+			return err
+		}
+		if serverTLS == nil {
+			appLogger.Warn("credentials are sent in plain text without TLS")
+		}
+		hndlr.SetAuth(credentials)
+	}
+	limits := conf.Limits.Config
+	if limits.Default != (limit.Limits{}) || len(limits.Users) > 0 || len(limits.Names) > 0 {
+		limiter, err := limit.NewLimiter(&limits)
+		if err != nil {
+			return err
+		}
+		hndlr.SetLimiter(limiter)
+	}
+
+	// The node exits when both servers are drained.
+	httpDone := make(chan struct{})
+	if httpAddress := conf.HTTP; httpAddress != "" {
+		httpServer, err := server.NewHTTPServer(httpAddress, hndlr, appLogger)
+		if err != nil {
+			return err
+		}
+		httpServer.SetTLS(serverTLS)
+		httpServer.SetAllowedOrigins(conf.HTTPOrigins)
+		httpServer.SetReadTimeout(conf.Timeouts.Read)
+		httpServer.SetWriteTimeout(conf.Timeouts.Write)
+		httpServer.SetDrainTimeout(conf.Timeouts.Drain)
+		go func() {
+			defer close(httpDone)
+			if err := httpServer.Run(backgroundContext); err != nil {
+				appLogger.Error("http gateway failed", "address", httpAddress, "error", err)
+			}
+		}()
+	} else {
+		close(httpDone)
+	}
 
-	srv, err := server.NewServer(listenAddress, hndlr)
+	srv, err := server.NewServer(conf.Listen, hndlr, appLogger)
 	if err != nil {
 		return err
 	}
-	return srv.Run(backgroundContext)
+	srv.SetGRPCAddress(conf.GRPC)
+	srv.SetTLS(serverTLS)
+	srv.SetSecret(secret)
+	srv.SetMaxConnections(conf.Limits.MaxConnections)
+	srv.SetTimeouts(conf.Timeouts.Read, conf.Timeouts.Write)
+	srv.SetDrainTimeout(conf.Timeouts.Drain)
+	if err := srv.Run(backgroundContext); err != nil {
+		return err
+	}
+	<-httpDone
+	return nil
+}
+
+// openLog opens the log of the storage backend and applies retention.
+func openLog(conf config.Storage) (*storage.Log, error) {
+	var lg *storage.Log
+	var err error
+	switch conf.Backend {
+	case config.BackendFile:
+		lg, err = storage.OpenLog(conf.Dir, conf.Fsync, conf.FsyncInterval)
+	default:
+		lg, err = storage.NewLog()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lg.SetRetention(conf.Retention.MaxEntries, conf.Retention.MaxBytes); err != nil {
+		lg.Close()
+		return nil, err
+	}
+	return lg, nil
 }
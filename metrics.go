@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/metrics"
+)
+
+func registerLogMetrics(lg *storage.Log) {
+	metrics.NewGaugeFunc("stream_log_entries", "Entries in the log.", func() float64 {
+		return float64(lg.Count())
+	})
+	metrics.NewGaugeFunc("stream_log_bytes", "Total size of values in the log.", func() float64 {
+		return float64(lg.Size())
+	})
+	metrics.NewGaugeFunc("stream_pull_subscribers", "Active PULL subscribers.", func() float64 {
+		return float64(len(lg.Lags()))
+	})
+	metrics.NewGaugeFunc("stream_pull_lag_max_entries", "Entries not yet sent to the most lagging PULL subscriber.", func() float64 {
+		var max uint64
+		for _, lag := range lg.Lags() {
+			if lag > max {
+				max = lag
+			}
+		}
+		return float64(max)
+	})
+	metrics.NewGaugeFunc("stream_pull_lag_entries", "Entries not yet sent to all PULL subscribers.", func() float64 {
+		var sum uint64
+		for _, lag := range lg.Lags() {
+			sum += lag
+		}
+		return float64(sum)
+	})
+}
+
+// serveHTTP runs the HTTP server until the context is done.
+func serveHTTP(ctx context.Context, address string, handler http.Handler, lg *logger.Logger) {
+	srv := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		if err := srv.Close(); err != nil {
+			lg.Error("error closing http server", "error", err)
+		}
+	}()
+	lg.Info("started http", "address", address)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		lg.Error("http server failed", "address", address, "error", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package metrics creates counters, gauges and histograms of the node in the
+// Prometheus registry and exposes them by HTTP.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are latency buckets in seconds.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	m          sync.Mutex
+	registry   *prometheus.Registry
+	collectors map[string]prometheus.Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		registry:   prometheus.NewRegistry(),
+		collectors: map[string]prometheus.Collector{},
+	}
+}
+
+// DefaultRegistry collects metrics created by NewCounter, NewGauge,
+// NewGaugeFunc and NewHistogram.
+var DefaultRegistry = NewRegistry()
+
+// Register adds the collector. The collector with the same name is
+// replaced.
+func (r *Registry) Register(name string, c prometheus.Collector) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if previous, ok := r.collectors[name]; ok {
+		r.registry.Unregister(previous)
+	}
+	r.registry.MustRegister(c)
+	r.collectors[name] = c
+}
+
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+type Counter struct {
+	vec *prometheus.CounterVec
+}
+
+func newCounter(name, help string, labels []string) *Counter {
+	return &Counter{vec: prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)}
+}
+
+// NewCounter creates the counter in the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := newCounter(name, help, labels)
+	DefaultRegistry.Register(name, c.vec)
+	return c
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Inc()
+}
+
+type Gauge struct {
+	vec *prometheus.GaugeVec
+}
+
+func newGauge(name, help string, labels []string) *Gauge {
+	return &Gauge{vec: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)}
+}
+
+// NewGauge creates the gauge in the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := newGauge(name, help, labels)
+	DefaultRegistry.Register(name, g.vec)
+	return g
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Inc()
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Dec()
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+// NewGaugeFunc creates the gauge in the default registry, its value is
+// calculated on collecting.
+func NewGaugeFunc(name, help string, f func() float64) {
+	DefaultRegistry.Register(name, prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, f))
+}
+
+type Histogram struct {
+	vec *prometheus.HistogramVec
+}
+
+func newHistogram(name, help string, buckets []float64, labels []string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	opts := prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}
+	return &Histogram{vec: prometheus.NewHistogramVec(opts, labels)}
+}
+
+// NewHistogram creates the histogram in the default registry. Nil buckets
+// are replaced by DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := newHistogram(name, help, buckets, labels)
+	DefaultRegistry.Register(name, h.vec)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	counter := newCounter("test_requests_total", "Requests.", []string{"cmd"})
+	r.Register("test_requests_total", counter.vec)
+	gauge := newGauge("test_connections", "Connections.", nil)
+	r.Register("test_connections", gauge.vec)
+	histogram := newHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1}, nil)
+	r.Register("test_duration_seconds", histogram.vec)
+
+	counter.Inc("PUSH")
+	counter.Add(2, `"GET"\`+"\n")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+
+	// The collector with the same name is replaced.
+	replaced := newGauge("test_connections", "Connections.", nil)
+	r.Register("test_connections", replaced.vec)
+	replaced.Set(5)
+
+	expected := `# HELP test_connections Connections.
+# TYPE test_connections gauge
+test_connections 5
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 2
+test_duration_seconds_sum 0.55
+test_duration_seconds_count 2
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{cmd="\"GET\"\\\n"} 2
+test_requests_total{cmd="PUSH"} 1
+`
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	if string(body) != expected {
+		t.Errorf("unexpected output:\n%s", body)
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", recorder.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,26 @@
+package paxos
+
+import (
+	"github.com/tariel-x/stream/metrics"
+)
+
+var (
+	roundsTotal = metrics.NewCounter(
+		"stream_paxos_rounds_total",
+		"Prepare rounds started by the node.",
+	)
+	quorumFailuresTotal = metrics.NewCounter(
+		"stream_paxos_quorum_failures_total",
+		"Rounds which failed to gather the quorum.",
+		"phase",
+	)
+	commitRetriesTotal = metrics.NewCounter(
+		"stream_paxos_commit_retries_total",
+		"Commits repeated because the slot was taken by another value.",
+	)
+	refusalsTotal = metrics.NewCounter(
+		"stream_paxos_refusals_total",
+		"PREPARE and ACCEPT requests refused by the node.",
+		"phase",
+	)
+)
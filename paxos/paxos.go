@@ -1,40 +1,49 @@
 package paxos
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"errors"
-	"log"
+	"fmt"
+	"io"
 	"sync"
 	"sync/atomic"
 
 	"github.com/satori/go.uuid"
 
 	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
 	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
 )
 
 var (
-	ErrQuorumFailed = errors.New("quorum failed")
-	ErrAlreadySet   = errors.New("already set by another node")
+	ErrAlreadySet = errors.New("already set by another node")
 )
 
-type logger struct{}
-
-func (l *logger) Println(message ...interface{}) {
-	log.Println(message...)
-}
-
 type Paxos struct {
 	*paxos
 }
 
-func NewPaxos(nodes []string, name string) (*Paxos, error) {
-	wnpaxos, err := newPaxos(nodes, name)
+// NewPaxos makes paxos over nodes by addresses. TLS config is used to
+// connect nodes if set.
+func NewPaxos(nodes []string, name string, tlsConfig *tls.Config, lg *logger.Logger) (*Paxos, error) {
+	wnpaxos, err := newPaxos(nodes, name, tlsConfig, lg)
 	return &Paxos{
 		paxos: wnpaxos,
 	}, err
 }
 
+// NewPaxosWithNodes makes paxos over the already connected nodes.
+// Random is used to generate proposal numbers and IDs of values, so the
+// seeded source makes the proposals reproducible.
+func NewPaxosWithNodes(nodes []Node, random io.Reader, lg *logger.Logger) (*Paxos, error) {
+	return &Paxos{
+		paxos: newPaxosWithNodes(nodes, random, lg),
+	}, nil
+}
+
 func (p *Paxos) Prepare(n int) (bool, stream.AcceptMessage) {
 	accepted, acceptMessage := p.paxos.Prepare(n)
 	if acceptMessage == nil {
@@ -43,8 +52,45 @@ func (p *Paxos) Prepare(n int) (bool, stream.AcceptMessage) {
 	return accepted, stream.AcceptMessage(acceptMessage)
 }
 
+// Node is the remote paxos participant.
+type Node interface {
+	QueryOne(r client.Request) (*client.Response, error)
+	Exec(r client.Request) error
+}
+
+// Group runs requests to nodes concurrently, Wait blocks until all of them
+// return.
+type Group interface {
+	Go(f func())
+	Wait()
+}
+
+type waitGroup struct {
+	sync.WaitGroup
+}
+
+func newWaitGroup() Group {
+	return &waitGroup{}
+}
+
+func (g *waitGroup) Go(f func()) {
+	g.Add(1)
+	go func() {
+		defer g.Done()
+		f()
+	}()
+}
+
+// SetGroup replaces groups which run requests to nodes, e.g. the simulation
+// counts goroutines of them to know when all of them wait for the network.
+func (p *Paxos) SetGroup(newGroup func() Group) {
+	p.newGroup = newGroup
+}
+
 type paxos struct {
-	nodes      []*client.Client
+	nodes      []Node
+	random     io.Reader
+	logger     *logger.Logger
 	minQuorum  int
 	acceptedV  *string
 	acceptedID *string
@@ -52,31 +98,42 @@ type paxos struct {
 	n          *uint64
 	setted     map[string]struct{}
 	settedM    sync.RWMutex
+	proposing  *int64
+	newGroup   func() Group
 }
 
-func newPaxos(nodes []string, name string) (*paxos, error) {
-	clients := []*client.Client{}
+func newPaxos(nodes []string, name string, tlsConfig *tls.Config, lg *logger.Logger) (*paxos, error) {
+	clients := []Node{}
 	for _, node := range nodes {
 		client, err := client.New(node, nil)
-		client.SetName(name)
-		client.Logger = &logger{}
 		if err != nil {
 			return nil, err
 		}
+		client.SetName(name)
+		client.Logger = lg.Component("client")
+		client.TLS = tlsConfig
 		clients = append(clients, client)
 	}
+	return newPaxosWithNodes(clients, rand.Reader, lg), nil
+}
+
+func newPaxosWithNodes(nodes []Node, random io.Reader, lg *logger.Logger) *paxos {
 	minQuorum := (len(nodes) / 2) + 1
 	startN := uint64(0)
 	p := &paxos{
-		nodes:     clients,
+		nodes:     nodes,
+		random:    random,
+		logger:    lg.Component("paxos"),
 		minQuorum: minQuorum,
 		n:         &startN,
 		setted:    map[string]struct{}{},
 		settedM:   sync.RWMutex{},
 		acceptedM: sync.RWMutex{},
+		proposing: new(int64),
+		newGroup:  newWaitGroup,
 	}
 	atomic.StoreUint64(p.n, p.randInc())
-	return p, nil
+	return p
 }
 
 type AcceptMessage struct {
@@ -108,19 +165,32 @@ func (p *paxos) getSetted(id string) bool {
 	return ok
 }
 
-func (p *paxos) Commit(v string) ([]stream.AcceptMessage, error) {
+func (p *paxos) Commit(ctx context.Context, v string) ([]stream.AcceptMessage, error) {
 	var acceptedMessages []stream.AcceptMessage
 	var acceptMessage *AcceptMessage
 	var err error
 
-	id := uuid.NewV4().String()
+	atomic.AddInt64(p.proposing, 1)
+	defer atomic.AddInt64(p.proposing, -1)
+
+	id := p.newID()
+	ctx, span := tracing.Start(ctx, "paxos.commit")
+	span.SetAttribute("id", id)
+	defer func() {
+		span.SetAttribute("slots", len(acceptedMessages))
+		span.Finish(err)
+	}()
 
 	//TODO: if the foreign value is commited by the origin node - skip value.
 	for acceptMessage == nil || (acceptMessage != nil && acceptMessage.id != id) {
-		acceptMessage, err = p.commit(v, id)
+		if acceptMessage != nil {
+			commitRetriesTotal.Inc()
+		}
+		acceptMessage, err = p.commit(ctx, v, id)
 		// If the initial value has already set then just return (-1, nil)
 		// If the foreign value has already set then just skip and return to the origin value.
 		if err == ErrAlreadySet && acceptMessage != nil {
+			err = nil
 			if acceptMessage.id == id {
 				return acceptedMessages, nil
 			}
@@ -137,15 +207,49 @@ func (p *paxos) Commit(v string) ([]stream.AcceptMessage, error) {
 	return acceptedMessages, nil
 }
 
+// Ballot returns the highest proposal number known to the node.
+func (p *paxos) Ballot() int {
+	return int(atomic.LoadUint64(p.n))
+}
+
+// Restore raises the ballot over the last entry of the persisted log, so
+// values committed after the restart follow persisted values.
+func (p *paxos) Restore(last int) {
+	for {
+		n := atomic.LoadUint64(p.n)
+		if uint64(last) < n || atomic.CompareAndSwapUint64(p.n, n, uint64(last)+p.randInc()) {
+			return
+		}
+	}
+}
+
+// Proposing returns true while the node commits values.
+func (p *paxos) Proposing() bool {
+	return atomic.LoadInt64(p.proposing) > 0
+}
+
+// newID makes the ID of the proposed value from the random source, so the
+// seeded source makes IDs reproducible.
+func (p *paxos) newID() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(p.random, b); err != nil {
+		return uuid.NewV4().String()
+	}
+	id := uuid.FromBytesOrNil(b)
+	id.SetVersion(uuid.V4)
+	id.SetVariant(uuid.VariantRFC4122)
+	return id.String()
+}
+
 func (p *paxos) randInc() uint64 {
 	b := make([]byte, 1)
-	if _, err := rand.Read(b); err != nil {
+	if _, err := io.ReadFull(p.random, b); err != nil {
 		return 2
 	}
 	return uint64(b[0]) + 2
 }
 
-func (p *paxos) commit(v, id string) (*AcceptMessage, error) {
+func (p *paxos) commit(ctx context.Context, v, id string) (*AcceptMessage, error) {
 	var acceptMessage *AcceptMessage
 	var err error
 
@@ -153,11 +257,21 @@ commitCycle:
 	for {
 	promisePhase:
 		for {
-			acceptMessage, err = p.prepare(atomic.LoadUint64(p.n), v, id)
+			// The request is cancelled, e.g. by the shutdown of the node.
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				if err == client.ErrQuorumFailed {
+					return nil, err
+				}
+				return nil, ctxErr
+			}
+			roundsTotal.Inc()
+			acceptMessage, err = p.prepare(ctx, atomic.LoadUint64(p.n), v, id)
 			switch err {
 			case nil:
 				break promisePhase
-			case ErrQuorumFailed:
+			case client.ErrQuorumFailed:
+				quorumFailuresTotal.Inc("prepare")
+				p.logger.Debug("prepare quorum failed", "n", atomic.LoadUint64(p.n))
 				atomic.AddUint64(p.n, p.randInc()) //TODO: set max proposed N in quorum + 1
 			default:
 				return nil, err
@@ -170,11 +284,13 @@ commitCycle:
 			return acceptMessage, ErrAlreadySet
 		}
 		// Accept phase
-		err = p.accept(acceptMessage)
+		err = p.accept(ctx, acceptMessage)
 		switch err {
 		case nil:
 			break commitCycle
-		case ErrQuorumFailed:
+		case client.ErrQuorumFailed:
+			quorumFailuresTotal.Inc("accept")
+			p.logger.Debug("accept quorum failed", "n", acceptMessage.n)
 			atomic.AddUint64(p.n, p.randInc()) //TODO: set max proposed N in quorum + 1
 		default:
 			return nil, err
@@ -185,7 +301,7 @@ commitCycle:
 		return acceptMessage, ErrAlreadySet
 	}
 	p.Set(id)
-	return acceptMessage, p.set(acceptMessage)
+	return acceptMessage, p.set(ctx, acceptMessage)
 }
 
 //Prepare returns true if proposed N is more than last known N.
@@ -207,6 +323,7 @@ func (p *paxos) Prepare(n int) (bool, *AcceptMessage) {
 		p.acceptedID = nil
 		return true, msg
 	}
+	refusalsTotal.Inc("prepare")
 	return false, nil
 }
 
@@ -220,22 +337,27 @@ func (p *paxos) Accept(n int, v, id string) bool {
 		return true
 
 	}
+	refusalsTotal.Inc("accept")
 	return false
 }
 
-func (p *paxos) prepare(n uint64, v, id string) (*AcceptMessage, error) {
-	wg := &sync.WaitGroup{}
+func (p *paxos) prepare(ctx context.Context, n uint64, v, id string) (acceptMessage *AcceptMessage, err error) {
+	ctx, span := tracing.Start(ctx, "paxos.prepare")
+	span.SetAttribute("n", n)
+	defer func() { span.Finish(err) }()
+
+	group := p.newGroup()
 	promises := make(chan client.Promise, len(p.nodes))
 	for _, node := range p.nodes {
-		wg.Add(1)
-		go p.sendPrepare(node, wg, promises, n)
+		node := node
+		group.Go(func() { p.sendPrepare(ctx, node, promises, n) })
 	}
 
-	wg.Wait()
+	group.Wait()
 	close(promises)
 	count := 0
 	var maxPrevPromisedN int
-	acceptMessage := &AcceptMessage{
+	acceptMessage = &AcceptMessage{
 		n:  n,
 		id: id,
 		v:  v,
@@ -257,40 +379,49 @@ func (p *paxos) prepare(n uint64, v, id string) (*AcceptMessage, error) {
 	}
 
 	if count < p.minQuorum || rejection {
-		return nil, ErrQuorumFailed
+		return nil, client.ErrQuorumFailed
 	}
 
 	return acceptMessage, nil
 }
 
-func (p *paxos) sendPrepare(nodeClient *client.Client, wg *sync.WaitGroup, promises chan client.Promise, n uint64) {
-	defer wg.Done()
+func (p *paxos) sendPrepare(ctx context.Context, nodeClient Node, promises chan client.Promise, n uint64) {
+	ctx, span := tracing.Start(ctx, "paxos.send_prepare")
+	span.SetAttribute("peer", nodeName(nodeClient))
+	span.SetAttribute("n", n)
+	var err error
+	defer func() { span.Finish(err) }()
 
-	response, err := nodeClient.QueryOne(&client.Prepare{N: int(n)})
+	response, err := nodeClient.QueryOne(withTrace(ctx, &client.Prepare{N: int(n)}))
 	if err != nil {
-		log.Println(err)
+		p.logger.Warn("error sending prepare", "peer", nodeName(nodeClient), "n", n, "error", err)
 		return
 	}
 
 	promise, err := response.Promise()
 	if err != nil {
-		log.Println("can not parse reply", err)
+		p.logger.Warn("can not parse reply", "peer", nodeName(nodeClient), "n", n, "error", err)
 		return
 	}
 	if promise != nil {
+		span.SetAttribute("promise", promise.Promise)
 		promises <- *promise
 	}
 }
 
-func (p *paxos) accept(message *AcceptMessage) error {
-	wg := &sync.WaitGroup{}
+func (p *paxos) accept(ctx context.Context, message *AcceptMessage) (err error) {
+	ctx, span := tracing.Start(ctx, "paxos.accept")
+	span.SetAttribute("n", message.n)
+	defer func() { span.Finish(err) }()
+
+	group := p.newGroup()
 	accepts := make(chan client.Accepted, len(p.nodes))
 	for _, node := range p.nodes {
-		wg.Add(1)
-		go p.sendAccept(node, wg, accepts, message.n, message.v, message.id)
+		node := node
+		group.Go(func() { p.sendAccept(ctx, node, accepts, message.n, message.v, message.id) })
 	}
 
-	wg.Wait()
+	group.Wait()
 	close(accepts)
 	count := 0
 	rejection := false
@@ -304,41 +435,70 @@ func (p *paxos) accept(message *AcceptMessage) error {
 	}
 
 	if count < p.minQuorum || rejection {
-		return ErrQuorumFailed
+		return client.ErrQuorumFailed
 	}
 	return nil
 }
 
-func (p *paxos) sendAccept(nodeClient *client.Client, wg *sync.WaitGroup, accepts chan client.Accepted, n uint64, v, id string) {
-	defer wg.Done()
-	response, err := nodeClient.QueryOne(&client.Accept{
+func (p *paxos) sendAccept(ctx context.Context, nodeClient Node, accepts chan client.Accepted, n uint64, v, id string) {
+	ctx, span := tracing.Start(ctx, "paxos.send_accept")
+	span.SetAttribute("peer", nodeName(nodeClient))
+	span.SetAttribute("n", n)
+	var err error
+	defer func() { span.Finish(err) }()
+
+	response, err := nodeClient.QueryOne(withTrace(ctx, &client.Accept{
 		N:  int(n),
 		V:  v,
 		ID: id,
-	})
+	}))
 	if err != nil {
-		log.Println(err)
+		p.logger.Warn("error sending accept", "peer", nodeName(nodeClient), "n", n, "error", err)
 		return
 	}
 
 	agreement, err := response.Accepted()
 	if err != nil {
-		log.Println("can not parse reply", err)
+		p.logger.Warn("can not parse reply", "peer", nodeName(nodeClient), "n", n, "error", err)
 		return
 	}
 	if agreement != nil {
+		span.SetAttribute("accepted", agreement.Accepted)
 		accepts <- *agreement
 	}
 }
 
-func (p *paxos) set(message *AcceptMessage) error {
-	setRequest := &client.Set{
+func (p *paxos) set(ctx context.Context, message *AcceptMessage) error {
+	ctx, span := tracing.Start(ctx, "paxos.set")
+	span.SetAttribute("n", message.n)
+	defer span.Finish(nil)
+
+	setRequest := withTrace(ctx, &client.Set{
 		N:  int(message.n),
 		ID: message.id,
 		V:  message.v,
-	}
+	})
+	// Nobody waits for SET replies.
+	group := p.newGroup()
 	for _, node := range p.nodes {
-		go node.Exec(setRequest)
+		node := node
+		group.Go(func() { node.Exec(setRequest) })
 	}
 	return nil
 }
+
+// withTrace adds the trace context to the request meta.
+func withTrace(ctx context.Context, r client.Request) client.Request {
+	traceparent := tracing.Traceparent(ctx)
+	if traceparent == "" {
+		return r
+	}
+	return client.WithMeta(r, map[string]string{client.MetaKeyTrace: traceparent})
+}
+
+func nodeName(node Node) string {
+	if s, ok := node.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", node)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/tariel-x/stream/api"
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
+)
+
+// grpcService implements the gRPC API by the line protocol commands of the
+// handler, so it behaves the same way as the TCP server.
+type grpcService struct {
+	handler *stream.Handler
+	logger  *logger.Logger
+	// closing ends Pull streams, so the graceful stop is not blocked by
+	// subscribers.
+	closing <-chan struct{}
+}
+
+func newGRPCServer(handler *stream.Handler, tlsConfig *tls.Config, closing <-chan struct{}, lg *logger.Logger) *grpc.Server {
+	var options []grpc.ServerOption
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(options...)
+	api.RegisterStreamServer(srv, &grpcService{
+		handler: handler,
+		logger:  lg,
+		closing: closing,
+	})
+	return srv
+}
+
+func (s *grpcService) process(ctx context.Context, message string, each func(message string) error) error {
+	request := &Request{message: message, meta: map[string]string{}}
+	if p, ok := peer.FromContext(ctx); ok {
+		request.address = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{client.MetaKeyName, client.MetaKeyUser, client.MetaKeyPassword, client.MetaKeyToken} {
+			if values := md.Get(key); len(values) > 0 {
+				request.meta[key] = values[0]
+			}
+		}
+		request.name = request.meta[client.MetaKeyName]
+		if traceparents := md.Get(client.MetaKeyTrace); len(traceparents) > 0 {
+			if sc, err := tracing.ParseTraceparent(traceparents[0]); err == nil {
+				ctx = tracing.ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+
+	cmd, args := (&client.Response{Message: message}).Cmd()
+	s.logger.Debug("receive", "name", request.Name(), "cmd", cmd, logger.PayloadKey, args)
+	if err := processRequest(ctx, s.handler, request, each); err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *grpcService) push(ctx context.Context, v string) error {
+	if !validValue(v) {
+		return status.Error(codes.InvalidArgument, ErrInvalidValue.Error())
+	}
+	var reply string
+	err := s.process(ctx, fmt.Sprintf("%s %s", client.CmdPush, v), func(message string) error {
+		reply = message
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if reply != client.CmdOK {
+		return status.Errorf(codes.Internal, "unexpected reply %s", reply)
+	}
+	return nil
+}
+
+func (s *grpcService) Push(ctx context.Context, request *api.PushRequest) (*api.PushReply, error) {
+	if err := s.push(ctx, request.GetValue()); err != nil {
+		return nil, err
+	}
+	return &api.PushReply{}, nil
+}
+
+func (s *grpcService) PushBatch(ctx context.Context, request *api.PushBatchRequest) (*api.PushBatchReply, error) {
+	for i, v := range request.GetValues() {
+		if err := s.push(ctx, v); err != nil {
+			st := status.Convert(err)
+			return nil, status.Errorf(st.Code(), "pushed %d of %d: %s", i, len(request.GetValues()), st.Message())
+		}
+	}
+	return &api.PushBatchReply{Pushed: int32(len(request.GetValues()))}, nil
+}
+
+func (s *grpcService) Get(request *api.GetRequest, srv api.Stream_GetServer) error {
+	if request.GetFrom() < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid from %d", request.GetFrom())
+	}
+	return s.process(srv.Context(), fmt.Sprintf("%s %d", client.CmdGet, request.GetFrom()), func(message string) error {
+		return srv.Send(&api.Entry{Value: message})
+	})
+}
+
+func (s *grpcService) Pull(request *api.PullRequest, srv api.Stream_PullServer) error {
+	if request.GetFrom() < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid from %d", request.GetFrom())
+	}
+	ctx, cancel := context.WithCancel(srv.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := s.process(ctx, fmt.Sprintf("%s %d", client.CmdPull, request.GetFrom()), func(message string) error {
+		return srv.Send(&api.Entry{Value: message})
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-s.closing:
+		return status.Error(codes.Unavailable, client.ErrClosing.Error())
+	default:
+		return nil
+	}
+}
+
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	replyErr := stream.ReplyError(err)
+	code := codes.Internal
+	switch replyErr.Code {
+	case client.CodeUnknownCommand, client.CodeBadArgs:
+		code = codes.InvalidArgument
+	case client.CodeOutOfRange:
+		code = codes.OutOfRange
+	case client.CodeUnauthorized:
+		code = codes.Unauthenticated
+	case client.CodePermissionDenied:
+		code = codes.PermissionDenied
+	case client.CodeThrottled:
+		code = codes.ResourceExhausted
+	case client.CodeQuorumFailed, client.CodeNotLeader, client.CodeUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, replyErr.Message)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/stream"
+)
+
+// dialGRPC serves the gRPC API of the handler in memory and connects to it.
+func dialGRPC(t *testing.T, handler *stream.Handler, closing chan struct{}) (*client.GRPCClient, func()) {
+	listener := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(handler, nil, closing, logger.Nop())
+	go srv.Serve(listener)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+		return listener.Dial()
+	})
+	timeout := 5 * time.Second
+	grpcClient, err := client.NewGRPC("bufnet", &timeout, nil, dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grpcClient, func() {
+		grpcClient.Close()
+		srv.Stop()
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	closing := make(chan struct{})
+	grpcClient, stop := dialGRPC(t, newHandler(t), closing)
+	defer stop()
+	ctx := context.Background()
+
+	if err := grpcClient.Push(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if pushed, err := grpcClient.PushBatch(ctx, []string{"b", "c"}); err != nil || pushed != 2 {
+		t.Fatalf("pushed %d, %v", pushed, err)
+	}
+	values, err := grpcClient.Get(ctx, 2)
+	if err != nil || strings.Join(values, ",") != "b,c" {
+		t.Errorf("unexpected values %v, %v", values, err)
+	}
+
+	entries, err := grpcClient.Pull(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := entries.Next(); !ok || v != "c" {
+		t.Errorf("unexpected value %q, %v", v, entries.Err())
+	}
+	if err := grpcClient.Push(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := entries.Next(); !ok || v != "d" {
+		t.Errorf("unexpected value %q, %v", v, entries.Err())
+	}
+	// Subscribers are told to resume on the other node when the server
+	// stops.
+	close(closing)
+	if _, ok := entries.Next(); ok || status.Code(entries.Err()) != codes.Unavailable {
+		t.Errorf("unexpected end %v", entries.Err())
+	}
+}
+
+func TestGRPC_Errors(t *testing.T) {
+	handler := newHandler(t)
+	handler.SetAuth(tokenAuth{})
+	grpcClient, stop := dialGRPC(t, handler, make(chan struct{}))
+	defer stop()
+	ctx := context.Background()
+
+	if err := grpcClient.Push(ctx, "a b"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := grpcClient.Push(ctx, "a"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := grpcClient.Get(ctx, -1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	grpcClient.Meta[client.MetaKeyToken] = "wrong"
+	if err := grpcClient.Push(ctx, "a"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unexpected error %v", err)
+	}
+	grpcClient.Meta[client.MetaKeyToken] = "t"
+	if err := grpcClient.Push(ctx, "fail"); status.Code(err) != codes.Unavailable {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := grpcClient.PushBatch(ctx, []string{"a", "fail"}); status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "pushed 1 of 2") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	tests := map[error]codes.Code{
+		stream.ErrIncorrectCmd:                           codes.InvalidArgument,
+		client.ErrOutOfRange:                             codes.OutOfRange,
+		stream.ErrUnauthorized:                           codes.Unauthenticated,
+		stream.ErrPermissionDenied:                       codes.PermissionDenied,
+		&stream.ThrottledError{RetryAfter: time.Second}:  codes.ResourceExhausted,
+		client.ErrQuorumFailed:                           codes.Unavailable,
+		status.Error(codes.Aborted, "aborted"):           codes.Aborted,
+		&client.Error{Code: "OTHER", Message: "message"}: codes.Internal,
+	}
+	for err, code := range tests {
+		if actual := status.Code(grpcError(err)); actual != code {
+			t.Errorf("%v: expected %s, got %s", err, code, actual)
+		}
+	}
+}
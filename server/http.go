@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
+)
+
+// HeaderName is the HTTP header with the client name, the same as the name
+// meta of the line protocol.
+const HeaderName = "X-Stream-Name"
+
+var ErrInvalidValue = errors.New("value must not be empty or contain spaces, ';' or line breaks")
+
+// MaxPushBody limits the body of the push request.
+const MaxPushBody = 1 << 20
+
+// HTTPServer is the HTTP/JSON gateway to the handler. Requests are
+// translated to the line protocol commands, so they behave the same way as
+// requests of the TCP server.
+type HTTPServer struct {
+	listenAddress string
+	tls           *tls.Config
+	handler       *stream.Handler
+	logger        *logger.Logger
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	drainTimeout  time.Duration
+	origins       map[string]bool
+}
+
+// SetTLS enables HTTPS.
+func (server *HTTPServer) SetTLS(config *tls.Config) {
+	server.tls = config
+}
+
+// SetAllowedOrigins sets origins of pages allowed to open the WebSocket
+// besides the same origin, "*" allows any.
+func (server *HTTPServer) SetAllowedOrigins(origins []string) {
+	server.origins = map[string]bool{}
+	for _, origin := range origins {
+		server.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+}
+
+// SetReadTimeout sets the time to read request headers and to wait for the
+// next request on the idle connection. Zero is unlimited.
+func (server *HTTPServer) SetReadTimeout(timeout time.Duration) {
+	server.readTimeout = timeout
+}
+
+// SetWriteTimeout sets the time to write every WebSocket frame, stuck
+// browsers are disconnected after it. Zero is unlimited.
+func (server *HTTPServer) SetWriteTimeout(timeout time.Duration) {
+	server.writeTimeout = timeout
+}
+
+// SetDrainTimeout sets the time to finish requests on shutdown.
+func (server *HTTPServer) SetDrainTimeout(timeout time.Duration) {
+	server.drainTimeout = timeout
+}
+
+func NewHTTPServer(listenAddress string, handler *stream.Handler, lg *logger.Logger) (*HTTPServer, error) {
+	return &HTTPServer{
+		listenAddress: listenAddress,
+		handler:       handler,
+		logger:        lg.Component("http"),
+		drainTimeout:  10 * time.Second,
+	}, nil
+}
+
+// Handler routes requests of the gateway, subscriptions are ended when the
+// context is done.
+func (server *HTTPServer) Handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/push", server.push)
+	mux.HandleFunc("/log", server.log)
+	// Subscriptions are not drained, they are ended by the server context.
+	mux.HandleFunc("/pull", func(w http.ResponseWriter, r *http.Request) {
+		server.pull(ctx, w, r)
+	})
+	// Hijacked connections are not closed with the server, so the
+	// websocket is closed by the server context too.
+	mux.HandleFunc("/ws/pull", func(w http.ResponseWriter, r *http.Request) {
+		server.subscribe(ctx, w, r)
+	})
+	return mux
+}
+
+func (server *HTTPServer) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              server.listenAddress,
+		Handler:           server.Handler(ctx),
+		TLSConfig:         server.tls,
+		ReadHeaderTimeout: server.readTimeout,
+		IdleTimeout:       server.readTimeout,
+	}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		drainCtx, cancel := context.WithTimeout(context.Background(), server.drainTimeout)
+		defer cancel()
+		if err := srv.Shutdown(drainCtx); err != nil {
+			server.logger.Warn("http drain timed out", "error", err)
+			srv.Close()
+		}
+	}()
+	server.logger.Info("started listen", "address", server.listenAddress)
+	var err error
+	if server.tls != nil {
+		// Certificates are taken from the TLS config.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	<-shutdown
+	return nil
+}
+
+type pushBody struct {
+	Value string `json:"value"`
+}
+
+type pushReply struct {
+	OK bool `json:"ok"`
+}
+
+type logReply struct {
+	Values []string `json:"values"`
+}
+
+type valueReply struct {
+	Value string `json:"value"`
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+func (server *HTTPServer) push(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	body := pushBody{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxPushBody)).Decode(&body); err != nil {
+		code := http.StatusBadRequest
+		// MaxBytesReader has no error type.
+		if err.Error() == "http: request body too large" {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, code, err)
+		return
+	}
+	if !validValue(body.Value) {
+		writeError(w, http.StatusBadRequest, ErrInvalidValue)
+		return
+	}
+	var reply string
+	err := server.process(r.Context(), r, fmt.Sprintf("%s %s", client.CmdPush, body.Value), func(message string) error {
+		reply = message
+		return nil
+	})
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	if reply != client.CmdOK {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("unexpected reply %s", reply))
+		return
+	}
+	writeJSON(w, http.StatusOK, pushReply{OK: true})
+}
+
+func (server *HTTPServer) log(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	from, err := parseFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	values := []string{}
+	err = server.process(r.Context(), r, fmt.Sprintf("%s %d", client.CmdGet, from), func(message string) error {
+		values = append(values, message)
+		return nil
+	})
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logReply{Values: values})
+}
+
+// pull streams the log as JSON lines or as Server-Sent Events if the client
+// accepts text/event-stream.
+func (server *HTTPServer) pull(serverCtx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	from, err := parseFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	// Headers are sent with the first value, so the error of the command,
+	// e.g. denied permission, is replied with its status code.
+	started := false
+	start := func() {
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-serverCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	encoder := json.NewEncoder(w)
+	err = server.process(ctx, r, fmt.Sprintf("%s %d", client.CmdPull, from), func(message string) error {
+		if !started {
+			start()
+		}
+		if sse {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+				return err
+			}
+		} else if err := encoder.Encode(valueReply{Value: message}); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && !started {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	if err != nil {
+		server.logger.Warn("error pulling", "address", r.RemoteAddr, "error", err)
+		return
+	}
+	if serverCtx.Err() != nil {
+		// The closing notice tells the subscriber to resume on the other
+		// node.
+		if !started {
+			start()
+		}
+		if sse {
+			fmt.Fprintf(w, "event: closing\ndata: %s\n\n", client.ErrClosing)
+		} else {
+			encoder.Encode(errorReply{Error: client.ErrClosing.Error()})
+		}
+		flusher.Flush()
+	}
+}
+
+// process runs the command of the HTTP request by the handler.
+func (server *HTTPServer) process(ctx context.Context, r *http.Request, message string, each func(message string) error) error {
+	request := &Request{
+		message: message,
+		address: r.RemoteAddr,
+		name:    r.Header.Get(HeaderName),
+		meta:    httpCredentials(r),
+	}
+	if request.name != "" {
+		request.meta[client.MetaKeyName] = request.name
+	}
+	if sc, err := tracing.ParseTraceparent(r.Header.Get(client.MetaKeyTrace)); err == nil {
+		ctx = tracing.ContextWithRemote(ctx, sc)
+	}
+
+	cmd, args := (&client.Response{Message: message}).Cmd()
+	server.logger.Debug("receive", "name", request.Name(), "cmd", cmd, logger.PayloadKey, args)
+	return processRequest(ctx, server.handler, request, each)
+}
+
+// httpCredentials takes the token from the Bearer authorization and the user
+// and the password from the Basic authorization. Tokens of URLs are not
+// accepted, URLs end up in logs and histories.
+func httpCredentials(r *http.Request) map[string]string {
+	meta := map[string]string{}
+	if user, password, ok := r.BasicAuth(); ok {
+		meta[client.MetaKeyUser] = user
+		meta[client.MetaKeyPassword] = password
+	}
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		meta[client.MetaKeyToken] = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return meta
+}
+
+// validValue checks that the line protocol can carry the value.
+func validValue(v string) bool {
+	return v != "" && !strings.ContainsAny(v, " ;\n\r")
+}
+
+func parseFrom(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("from")
+	if raw == "" {
+		return 0, nil
+	}
+	from, err := strconv.Atoi(raw)
+	if err != nil || from < 0 {
+		return 0, fmt.Errorf("invalid from %q", raw)
+	}
+	return from, nil
+}
+
+func statusCode(err error) int {
+	switch stream.ReplyError(err).Code {
+	case client.CodeUnknownCommand, client.CodeBadArgs, client.CodeOutOfRange:
+		return http.StatusBadRequest
+	case client.CodeUnauthorized:
+		return http.StatusUnauthorized
+	case client.CodePermissionDenied:
+		return http.StatusForbidden
+	case client.CodeThrottled:
+		return http.StatusTooManyRequests
+	case client.CodeQuorumFailed, client.CodeNotLeader, client.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(reply)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	if throttled, ok := err.(*stream.ThrottledError); ok {
+		seconds := int64((throttled.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	writeJSON(w, code, errorReply{Error: err.Error()})
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/stream"
+)
+
+func newHTTPServer(t *testing.T, handler *stream.Handler) (*httptest.Server, context.CancelFunc) {
+	server, err := NewHTTPServer("", handler, logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return httptest.NewServer(server.Handler(ctx)), cancel
+}
+
+func push(t *testing.T, url, body string) (int, string) {
+	response, err := http.Post(url+"/push", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	reply := map[string]interface{}{}
+	json.NewDecoder(response.Body).Decode(&reply)
+	message, _ := reply["error"].(string)
+	return response.StatusCode, message
+}
+
+func TestHTTPServer_Push(t *testing.T) {
+	srv, cancel := newHTTPServer(t, newHandler(t))
+	defer srv.Close()
+	defer cancel()
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"value":"a"}`, http.StatusOK},
+		{`{"value":"a b"}`, http.StatusBadRequest},
+		{`{"value":`, http.StatusBadRequest},
+		{`{"value":"fail"}`, http.StatusServiceUnavailable},
+		{`{"value":"` + strings.Repeat("x", MaxPushBody) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		if code, message := push(t, srv.URL, test.body); code != test.code {
+			t.Errorf("%.20s: expected %d, got %d %s", test.body, test.code, code, message)
+		}
+	}
+
+	response, err := http.Get(srv.URL + "/push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected code %d", response.StatusCode)
+	}
+}
+
+func TestHTTPServer_Log(t *testing.T) {
+	srv, cancel := newHTTPServer(t, newHandler(t))
+	defer srv.Close()
+	defer cancel()
+	for _, v := range []string{"a", "b", "c"} {
+		push(t, srv.URL, `{"value":"`+v+`"}`)
+	}
+
+	response, err := http.Get(srv.URL + "/log?from=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	reply := logReply{}
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(reply.Values, ",") != "b,c" {
+		t.Errorf("unexpected values %v", reply.Values)
+	}
+
+	response, err = http.Get(srv.URL + "/log?from=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected code %d", response.StatusCode)
+	}
+}
+
+func TestHTTPServer_Auth(t *testing.T) {
+	handler := newHandler(t)
+	handler.SetAuth(tokenAuth{})
+	srv, cancel := newHTTPServer(t, handler)
+	defer srv.Close()
+	defer cancel()
+
+	tests := []struct {
+		token string
+		query string
+		code  int
+	}{
+		{"", "", http.StatusForbidden},
+		{"wrong", "", http.StatusUnauthorized},
+		{"t", "", http.StatusOK},
+		// Tokens of URLs are ignored.
+		{"", "?token=t", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodPost, srv.URL+"/push"+test.query, strings.NewReader(`{"value":"a"}`))
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.code {
+			t.Errorf("token %q%s: expected %d, got %d", test.token, test.query, test.code, response.StatusCode)
+		}
+	}
+}
+
+type limiter struct{}
+
+func (limiter) Push(user, name string, bytes int) time.Duration {
+	return 1500 * time.Millisecond
+}
+
+func (limiter) Pull(ctx context.Context, user, name string, bytes int) error {
+	return nil
+}
+
+func TestHTTPServer_Throttled(t *testing.T) {
+	handler := newHandler(t)
+	handler.SetLimiter(limiter{})
+	srv, cancel := newHTTPServer(t, handler)
+	defer srv.Close()
+	defer cancel()
+	response, err := http.Post(srv.URL+"/push", "application/json", strings.NewReader(`{"value":"a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") != "2" {
+		t.Errorf("unexpected reply %d, retry after %q", response.StatusCode, response.Header.Get("Retry-After"))
+	}
+}
+
+func TestHTTPServer_Pull(t *testing.T) {
+	srv, cancel := newHTTPServer(t, newHandler(t))
+	defer srv.Close()
+	push(t, srv.URL, `{"value":"a"}`)
+
+	pull := func(accept string) *bufio.Reader {
+		request, _ := http.NewRequest(http.MethodGet, srv.URL+"/pull?from=1", nil)
+		request.Header.Set("Accept", accept)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected code %d", response.StatusCode)
+		}
+		return bufio.NewReader(response.Body)
+	}
+	ndjson := pull("application/x-ndjson")
+	sse := pull("text/event-stream")
+	push(t, srv.URL, `{"value":"b"}`)
+
+	for _, expected := range []string{`{"value":"a"}`, `{"value":"b"}`} {
+		if line, _ := ndjson.ReadString('\n'); strings.TrimSpace(line) != expected {
+			t.Errorf("expected %s, got %q", expected, line)
+		}
+	}
+	for _, expected := range []string{"data: a", "", "data: b", ""} {
+		if line, _ := sse.ReadString('\n'); strings.TrimSpace(line) != expected {
+			t.Errorf("expected %q, got %q", expected, line)
+		}
+	}
+
+	// Subscribers are told to resume on the other node when the server
+	// stops.
+	cancel()
+	if line, _ := ndjson.ReadString('\n'); !strings.Contains(line, client.ErrClosing.Error()) {
+		t.Errorf("expected the closing notice, got %q", line)
+	}
+	if line, _ := sse.ReadString('\n'); strings.TrimSpace(line) != "event: closing" {
+		t.Errorf("expected the closing event, got %q", line)
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/tariel-x/stream/metrics"
+)
+
+var (
+	connectionsOpen = metrics.NewGauge(
+		"stream_connections_open",
+		"Currently open connections.",
+	)
+	connectionsTotal = metrics.NewCounter(
+		"stream_connections_total",
+		"Accepted connections.",
+	)
+	connectionsRejected = metrics.NewCounter(
+		"stream_connections_rejected_total",
+		"Connections rejected by the connection limit.",
+	)
+)
@@ -3,71 +3,278 @@ package server
 import (
 	"bufio"
 	"context"
-	"errors"
-	"log"
+	"crypto/hmac"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
 
 	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
 	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
+)
+
+// signatureWindow is the maximum difference between the time the signed
+// request is sent and received. Nonces of signed requests are kept for the
+// window, so requests are not replayed.
+const signatureWindow = 5 * time.Minute
+
+// nonces are nonces of signed requests received in the signature window.
+type nonces struct {
+	m    sync.Mutex
+	seen map[string]time.Time
+	// pruned is the time when expired nonces were dropped.
+	pruned time.Time
+}
+
+// add remembers the nonce, false is returned if it is already seen.
+func (n *nonces) add(nonce string, now time.Time) bool {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.seen == nil {
+		n.seen = map[string]time.Time{}
+	}
+	// Requests older than two windows are rejected by the time.
+	if now.Sub(n.pruned) > signatureWindow {
+		for seen, at := range n.seen {
+			if now.Sub(at) > 2*signatureWindow {
+				delete(n.seen, seen)
+			}
+		}
+		n.pruned = now
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = now
+	return true
+}
+
+// rejectTimeout limits writing the reply to the rejected connection.
+const rejectTimeout = time.Second
+
+var (
+	ErrTooManyConnections = &client.Error{Code: client.CodeUnavailable, Message: "too many connections"}
+	ErrInvalidMeta        = &client.Error{Code: client.CodeBadArgs, Message: "invalid meta"}
 )
 
 type Server struct {
 	listenAddress string
+	grpcAddress   string
+	tls           *tls.Config
+	secret        []byte
+	nonces        nonces
 	handler       *stream.Handler
+	logger        *logger.Logger
+	// connections are slots of open connections, unlimited if nil.
+	connections  chan struct{}
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	drainTimeout time.Duration
+	// closing is closed when the server stops accepting connections, PULL
+	// subscribers are sent the closing notice.
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// SetTLS enables TLS on the line protocol and gRPC listeners. Clients with
+// the client certificate signed by ClientCAs of the config are peers.
+func (server *Server) SetTLS(config *tls.Config) {
+	server.tls = config
 }
 
-func NewServer(listenAddress string, handler *stream.Handler) (*Server, error) {
+// SetSecret sets the cluster secret. Requests signed by it are peers.
+func (server *Server) SetSecret(secret []byte) {
+	server.secret = secret
+}
+
+// SetGRPCAddress enables the gRPC API on the address, it is served by Run
+// together with the line protocol.
+func (server *Server) SetGRPCAddress(address string) {
+	server.grpcAddress = address
+}
+
+// SetMaxConnections limits open connections of the line protocol, the rest
+// are replied with ErrTooManyConnections. Zero is unlimited.
+func (server *Server) SetMaxConnections(n int) {
+	server.connections = nil
+	if n > 0 {
+		server.connections = make(chan struct{}, n)
+	}
+}
+
+// SetTimeouts sets the time to read the request and to write every reply
+// line, so idle and stuck clients are disconnected. Zero is unlimited.
+func (server *Server) SetTimeouts(read, write time.Duration) {
+	server.readTimeout = read
+	server.writeTimeout = write
+}
+
+// SetDrainTimeout sets the time to finish requests on shutdown, requests
+// still running after it are cancelled.
+func (server *Server) SetDrainTimeout(timeout time.Duration) {
+	server.drainTimeout = timeout
+}
+
+func NewServer(listenAddress string, handler *stream.Handler, lg *logger.Logger) (*Server, error) {
 	return &Server{
 		listenAddress: listenAddress,
 		handler:       handler,
+		logger:        lg.Component("server"),
+		drainTimeout:  10 * time.Second,
+		closing:       make(chan struct{}),
 	}, nil
 }
 
+// Run serves until the context is done, then it stops accepting connections
+// and drains running requests.
 func (server *Server) Run(ctx context.Context) error {
 	socket, err := net.Listen("tcp", server.listenAddress)
 	if err != nil {
 		return err
 	}
+	if server.tls != nil {
+		socket = tls.NewListener(socket, server.tls)
+	}
 	defer func() {
-		if err := socket.Close(); err != nil {
-			log.Println(err)
+		if err := socket.Close(); err != nil && !server.isClosing() {
+			server.logger.Error("error closing socket", "error", err)
 		}
 	}()
 
+	// Requests are not cancelled by the context, so commits in flight are
+	// finished by the drain.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	errc := make(chan error, 1)
 	go func() {
 		defer close(errc)
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
 			conn, err := socket.Accept()
 			if err != nil {
-				errc <- err
+				if !server.isClosing() {
+					errc <- err
+				}
 				return
 			}
-			go server.accept(ctx, conn, errc)
-			//time.Sleep(time.Second)
+			connectionsTotal.Inc()
+			if !server.acquire() {
+				connectionsRejected.Inc()
+				go server.reject(conn)
+				continue
+			}
+			server.wg.Add(1)
+			go func() {
+				defer server.wg.Done()
+				defer server.release()
+				server.accept(requestsCtx, conn)
+			}()
 		}
 	}()
 
-	log.Println("started listen", server.listenAddress)
+	var grpcServer *grpc.Server
+	if server.grpcAddress != "" {
+		grpcSocket, err := net.Listen("tcp", server.grpcAddress)
+		if err != nil {
+			return err
+		}
+		grpcServer = newGRPCServer(server.handler, server.tls, server.closing, server.logger.With("api", "grpc"))
+		defer grpcServer.Stop()
+		go func() {
+			if err := grpcServer.Serve(grpcSocket); err != nil {
+				server.logger.Error("grpc server failed", "address", server.grpcAddress, "error", err)
+			}
+		}()
+		server.logger.Info("started grpc", "address", server.grpcAddress)
+	}
+
+	server.logger.Info("started listen", "address", server.listenAddress)
 	select {
 	case <-ctx.Done():
-		return nil
 	case err := <-errc:
 		return err
 	}
+
+	server.logger.Info("draining", "timeout", server.drainTimeout)
+	close(server.closing)
+	if err := socket.Close(); err != nil {
+		server.logger.Error("error closing socket", "error", err)
+	}
+	// The accept loop adds connections to the wait group, so it exits
+	// before the wait.
+	<-errc
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		server.wg.Wait()
+	}()
+	timer := time.NewTimer(server.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		server.logger.Info("drained")
+	case <-timer.C:
+		server.logger.Warn("drain timed out, cancelling requests")
+		cancelRequests()
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+		<-drained
+	}
+	return nil
+}
+
+func (server *Server) isClosing() bool {
+	select {
+	case <-server.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (server *Server) acquire() bool {
+	if server.connections == nil {
+		return true
+	}
+	select {
+	case server.connections <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (server *Server) release() {
+	if server.connections != nil {
+		<-server.connections
+	}
+}
+
+// reject replies ErrTooManyConnections and closes the connection.
+func (server *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	server.logger.Warn("connection rejected", "address", conn.RemoteAddr().String(), "error", ErrTooManyConnections)
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	conn.Write([]byte(ErrTooManyConnections.Reply() + "\n"))
 }
 
 type Request struct {
 	message string
 	address string
 	name    string
+	peer    bool
+	meta    map[string]string
 }
 
 func (r *Request) Message() string {
@@ -85,6 +292,14 @@ func (r *Request) Name() string {
 	return r.address
 }
 
+func (r *Request) Peer() bool {
+	return r.peer
+}
+
+func (r *Request) Meta() map[string]string {
+	return r.meta
+}
+
 func makeRequest(input, address string) (*Request, error) {
 	message := strings.TrimSpace(input)
 	return &Request{
@@ -105,78 +320,223 @@ func (r *Response) Push(message string) {
 	r.messages <- message
 }
 
-func (server *Server) accept(parent context.Context, conn net.Conn, errc chan error) {
+// processRequest runs the request by the handler and passes every reply
+// line to the callback. If the callback fails the request is cancelled.
+func processRequest(ctx context.Context, handler *stream.Handler, request *Request, each func(message string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	response := NewResponse()
+	errc := make(chan error, 1)
+	go func() {
+		defer close(response.messages)
+		errc <- handler.Process(ctx, request, response)
+	}()
+
+	var eachErr error
+	for message := range response.messages {
+		if eachErr != nil {
+			// Drain replies, so the handler is not blocked.
+			continue
+		}
+		if eachErr = each(message); eachErr != nil {
+			cancel()
+		}
+	}
+	if eachErr != nil {
+		return eachErr
+	}
+	return <-errc
+}
+
+// write writes the reply line within the write timeout.
+func (server *Server) write(conn net.Conn, message string) error {
+	if server.writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(server.writeTimeout))
+	}
+	_, err := conn.Write([]byte(message + "\n"))
+	return err
+}
+
+func (server *Server) accept(parent context.Context, conn net.Conn) {
 	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
+	connectionsOpen.Inc()
+	defer connectionsOpen.Dec()
 
 	closeListen := func() {
+		// The error of the single connection, e.g. TLS close notify to the
+		// gone client, does not stop the server.
 		if err := conn.Close(); err != nil {
-			errc <- err
-			return
+			server.logger.Debug("error closing connection", "address", conn.RemoteAddr().String(), "error", err)
 		}
 	}
 	defer closeListen()
+	// Reads and writes are interrupted when requests are cancelled.
+	go func() {
+		<-ctx.Done()
+		conn.SetDeadline(time.Now())
+	}()
 
-	rawinput, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		if _, err := conn.Write([]byte(err.Error() + "\n")); err != nil {
-			errc <- err
+	if server.readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(server.readTimeout))
+	}
+	verified := false
+	var state tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			server.logger.Warn("tls handshake failed", "address", conn.RemoteAddr().String(), "error", err)
 			return
 		}
-		errc <- err
+		state = tlsConn.ConnectionState()
+		verified = server.verifiedPeer(state)
+	}
+
+	rawinput, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		server.logger.Debug("error reading request", "address", conn.RemoteAddr().String(), "error", err)
+		server.write(conn, stream.ReplyError(err).Reply())
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
 
 	input, meta, err := server.extractMeta(rawinput)
 	if err != nil {
-		if _, err := conn.Write([]byte(err.Error() + "\n")); err != nil {
-			errc <- err
-			return
-		}
-		errc <- err
+		server.write(conn, stream.ReplyError(err).Reply())
 		return
 	}
 	request, err := makeRequest(input, conn.RemoteAddr().String())
 	if err != nil {
-		if _, err := conn.Write([]byte(err.Error() + "\n")); err != nil {
-			log.Printf("error parsing query from %s: %s", conn.RemoteAddr().String(), err)
-			return
+		if err := server.write(conn, stream.ReplyError(err).Reply()); err != nil {
+			server.logger.Error("error parsing query", "address", conn.RemoteAddr().String(), "error", err)
 		}
 		return
 	}
+	request.meta = meta
 	if name, ok := meta[client.MetaKeyName]; ok {
 		request.name = name
 	}
+	signed := server.signed(input, meta)
+	request.peer = verified || signed
+	if address, ok := meta[client.MetaKeyAddress]; ok && verified && !signed && !certifiedAddress(state, address) {
+		// The peer may not move other nodes to its address.
+		server.logger.Warn("peer address is not in the certificate", "address", conn.RemoteAddr().String(), "addr", address)
+		delete(meta, client.MetaKeyAddress)
+	}
+	if traceparent, ok := meta[client.MetaKeyTrace]; ok {
+		if sc, err := tracing.ParseTraceparent(traceparent); err == nil {
+			ctx = tracing.ContextWithRemote(ctx, sc)
+		}
+	}
 
-	log.Printf("this <- %s %s\n", request.Name(), request.Message())
-	response := NewResponse()
-	go func() {
-		defer close(response.messages)
-		if err = server.handler.Process(ctx, request, response); err != nil {
-			if _, err := conn.Write([]byte(err.Error() + "\n")); err != nil {
-				log.Println("error executing query", err)
-				return
+	cmd, args := (&client.Response{Message: request.Message()}).Cmd()
+	server.logger.Debug("receive", "name", request.Name(), "cmd", cmd, logger.PayloadKey, args)
+	handlerCtx := ctx
+	if cmd == client.CmdPull {
+		// Subscriptions are not drained, they are ended by the closing
+		// notice.
+		var cancelPull context.CancelFunc
+		handlerCtx, cancelPull = context.WithCancel(ctx)
+		defer cancelPull()
+		go func() {
+			select {
+			case <-server.closing:
+				cancelPull()
+			case <-handlerCtx.Done():
 			}
-			return
+		}()
+	}
+	writeFailed := false
+	err = processRequest(handlerCtx, server.handler, request, func(message string) error {
+		server.logger.Debug("send", "name", request.Name(), logger.PayloadKey, message)
+		if err := server.write(conn, message); err != nil {
+			writeFailed = true
+			return err
 		}
-	}()
-	for message := range response.messages {
-		log.Printf("this -> %s %s", request.Name(), message)
-		if _, err := conn.Write([]byte(message + "\n")); err != nil {
-			log.Println("error writing to client", err)
-			return
+		return nil
+	})
+	if writeFailed {
+		server.logger.Warn("error writing to client", "name", request.Name(), "error", err)
+		return
+	}
+	if err != nil {
+		if err := server.write(conn, stream.ReplyError(err).Reply()); err != nil {
+			server.logger.Error("error executing query", "name", request.Name(), "cmd", cmd, "error", err)
 		}
+		return
+	}
+	if cmd == client.CmdPull && server.isClosing() {
+		server.write(conn, client.ErrClosing.Reply())
+	}
+}
+
+// verifiedPeer verifies the client certificate by ClientCAs for client
+// authentication. The handshake does not verify it, so clients with
+// certificates of other CAs are not rejected, they are not peers.
+func (server *Server) verifiedPeer(state tls.ConnectionState) bool {
+	if len(state.PeerCertificates) == 0 || server.tls.ClientCAs == nil {
+		return false
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         server.tls.ClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// certifiedAddress checks that the certificate of the peer is valid for the
+// host of the address it sends.
+func certifiedAddress(state tls.ConnectionState, address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	return state.PeerCertificates[0].VerifyHostname(host) == nil
+}
+
+// signed checks the signature of the request by the cluster secret, the
+// signed request is accepted once.
+func (server *Server) signed(input string, meta map[string]string) bool {
+	if server.secret == nil {
+		return false
+	}
+	signature, ok := meta[client.MetaKeySignature]
+	if !ok || meta[client.MetaKeyNonce] == "" {
+		return false
+	}
+	ts, err := strconv.ParseInt(meta[client.MetaKeyTimestamp], 10, 64)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	if age := now.Sub(time.Unix(ts, 0)); age > signatureWindow || age < -signatureWindow {
+		return false
+	}
+	expected := client.Sign(server.secret, input, meta)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return false
+	}
+	if !server.nonces.add(meta[client.MetaKeyNonce], now) {
+		server.logger.Warn("replayed request", "nonce", meta[client.MetaKeyNonce])
+		return false
 	}
+	return true
 }
 
 func (server *Server) extractMeta(rawinput string) (string, map[string]string, error) {
-	inputparts := strings.Split(rawinput, ";")
+	inputparts := strings.Split(strings.TrimSpace(rawinput), ";")
 	input := inputparts[0]
 	meta := map[string]string{}
 	for i := 1; i < len(inputparts); i++ {
-		metaparts := strings.Split(inputparts[i], "=")
+		// Values may contain "=", e.g. base64 tokens.
+		metaparts := strings.SplitN(inputparts[i], "=", 2)
 		if len(metaparts) != 2 {
-			return "", nil, errors.New("invalid meta")
+			return "", nil, ErrInvalidMeta
 		}
 		meta[metaparts[0]] = metaparts[1]
 	}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/cluster"
+	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/stream"
+)
+
+func freeAddress(t *testing.T) string {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	return socket.Addr().String()
+}
+
+func TestServer_Drain(t *testing.T) {
+	lg := logger.New(ioutil.Discard, logger.LevelError, false)
+	log, err := storage.NewLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := stream.NewHandler(log, nil, lg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := freeAddress(t)
+	server, err := NewServer(address, handler, lg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetMaxConnections(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run(ctx)
+	}()
+
+	var subscriber net.Conn
+	for i := 0; i < 50; i++ {
+		if subscriber, err = net.Dial("tcp", address); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	subscriber.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := subscriber.Write([]byte("PULL 0\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	rejected, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := bufio.NewReader(rejected).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(reply) != ErrTooManyConnections.Reply() {
+		t.Errorf("expected %q, got %q", ErrTooManyConnections, reply)
+	}
+
+	cancel()
+	reply, err = bufio.NewReader(subscriber).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := (&client.Response{Message: reply}).Err().(*client.Error); !ok || *err != *client.ErrClosing {
+		t.Errorf("expected the closing notice, got %q", reply)
+	}
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("server is not drained")
+	}
+}
+
+type acceptMessage struct {
+	n  int
+	v  string
+	id string
+}
+
+func (m *acceptMessage) N() int     { return m.n }
+func (m *acceptMessage) ID() string { return m.id }
+func (m *acceptMessage) V() string  { return m.v }
+
+// localPaxos commits values to the next epoch without peers, the value
+// fail is not committed.
+type localPaxos struct {
+	m sync.Mutex
+	n int
+}
+
+func (p *localPaxos) Commit(ctx context.Context, v string) ([]stream.AcceptMessage, error) {
+	if v == "fail" {
+		return nil, client.ErrQuorumFailed
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.n++
+	return []stream.AcceptMessage{&acceptMessage{n: p.n, v: v, id: strconv.Itoa(p.n)}}, nil
+}
+
+func (p *localPaxos) Prepare(n int) (bool, stream.AcceptMessage) { return true, nil }
+func (p *localPaxos) Accept(n int, v, id string) bool            { return true }
+func (p *localPaxos) Set(id string)                              {}
+func (p *localPaxos) Ballot() int                                { return 0 }
+func (p *localPaxos) Proposing() bool                            { return false }
+
+// tokenAuth allows pushes of the user with the token t, anonymous clients
+// may get.
+type tokenAuth struct{}
+
+func (tokenAuth) Authenticate(meta map[string]string) (string, bool) {
+	switch meta[client.MetaKeyToken] {
+	case "":
+		return "anonymous", true
+	case "t":
+		return "u", true
+	}
+	return "", false
+}
+
+func (tokenAuth) Allowed(user, cmd string) bool {
+	return (user == "u" && cmd == client.CmdPush) || (user == "anonymous" && cmd == client.CmdGet)
+}
+
+func newHandler(t *testing.T) *stream.Handler {
+	log, err := storage.NewLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := stream.NewHandler(log, &localPaxos{}, logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestServer_Signed(t *testing.T) {
+	handler := newHandler(t)
+	handler.RequirePeerAuth()
+	address := freeAddress(t)
+	server, err := NewServer(address, handler, logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetSecret([]byte("secret"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+
+	send := func(line string) string {
+		var conn net.Conn
+		for i := 0; i < 50; i++ {
+			if conn, err = net.Dial("tcp", address); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(line + "\n"))
+		reply, _ := bufio.NewReader(conn).ReadString('\n')
+		return strings.TrimSpace(reply)
+	}
+	sign := func(message, ts, nonce, node string) string {
+		meta := map[string]string{
+			client.MetaKeyTimestamp: ts,
+			client.MetaKeyNonce:     nonce,
+			client.MetaKeyNode:      node,
+		}
+		return client.Sign([]byte("secret"), message, meta)
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	signed := "SET 1 id v;ts=" + now + ";nonce=a;node=n1;sig=" + sign("SET 1 id v", now, "a", "n1")
+
+	tests := []struct {
+		name string
+		line string
+		peer bool
+	}{
+		{"signed", signed, true},
+		{"replayed", signed, false},
+		{"changed node", "SET 1 id v;ts=" + now + ";nonce=b;node=n2;sig=" + sign("SET 1 id v", now, "b", "n1"), false},
+		{"no nonce", "SET 1 id v;ts=" + now + ";node=n1;sig=" + sign("SET 1 id v", now, "", "n1"), false},
+		{"old", "SET 1 id v;ts=" + old + ";nonce=c;node=n1;sig=" + sign("SET 1 id v", old, "c", "n1"), false},
+		{"not signed", "SET 1 id v", false},
+	}
+	for _, test := range tests {
+		reply := send(test.line)
+		if peer := reply == client.CmdOK; peer != test.peer {
+			t.Errorf("%s: expected peer %t, got %q", test.name, test.peer, reply)
+		}
+	}
+
+	// Requests of the client with the secret are signed.
+	peer, err := client.New(address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Secret = []byte("secret")
+	peer.SetNode("n1", "localhost:7001")
+	for i := 0; i < 2; i++ {
+		response, err := peer.QueryOne(&client.Set{N: 2, V: "v", ID: "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := response.Ok(); !ok || err != nil {
+			t.Errorf("request of the peer is rejected, %v", err)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	n := &nonces{}
+	now := time.Now()
+	if !n.add("a", now) || n.add("a", now.Add(time.Minute)) {
+		t.Error("nonce is accepted twice")
+	}
+	// Expired nonces are dropped.
+	later := now.Add(3 * signatureWindow)
+	if !n.add("b", later) || len(n.seen) != 1 {
+		t.Errorf("unexpected nonces %v", n.seen)
+	}
+}
+
+// process runs the request through the handler and returns replies.
+func process(handler *stream.Handler, message string, meta map[string]string, peer bool) ([]string, error) {
+	request := &Request{message: message, address: "127.0.0.1:5000", peer: peer, meta: meta}
+	var replies []string
+	err := processRequest(context.Background(), handler, request, func(reply string) error {
+		replies = append(replies, reply)
+		return nil
+	})
+	return replies, err
+}
+
+func TestHandler_LearnAddress(t *testing.T) {
+	handler := newHandler(t)
+	book, err := cluster.NewAddressBook(map[string]string{"n2": "localhost:7002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAddressBook(book)
+
+	tests := []struct {
+		name    string
+		message string
+		peer    bool
+		address string
+	}{
+		{"not peer", "SET 1 id v", false, "localhost:7002"},
+		{"not internal", "STATUS", true, "localhost:7002"},
+		{"peer", "SET 2 id v", true, "10.0.0.2:7002"},
+	}
+	for _, test := range tests {
+		process(handler, test.message, map[string]string{client.MetaKeyNode: "n2", client.MetaKeyAddress: "10.0.0.2:7002"}, test.peer)
+		if address, _ := book.Address("n2"); address != test.address {
+			t.Errorf("%s: expected %s, got %s", test.name, test.address, address)
+		}
+	}
+}
+
+func TestHandler_Handshake(t *testing.T) {
+	handler := newHandler(t)
+	handler.SetCluster("c1")
+	tests := []struct {
+		name string
+		meta map[string]string
+		code string
+	}{
+		{"same cluster", map[string]string{client.MetaKeyCluster: "c1", client.MetaKeyProtocol: "1"}, ""},
+		{"other cluster", map[string]string{client.MetaKeyCluster: "c2"}, client.CodeWrongCluster},
+		{"no cluster", map[string]string{}, client.CodeWrongCluster},
+		{"unsupported version", map[string]string{client.MetaKeyCluster: "c1", client.MetaKeyProtocol: "99"}, client.CodeIncompatible},
+		{"invalid version", map[string]string{client.MetaKeyCluster: "c1", client.MetaKeyProtocol: "v2"}, client.CodeIncompatible},
+	}
+	for i, test := range tests {
+		_, err := process(handler, fmt.Sprintf("SET %d id v", i), test.meta, true)
+		code := ""
+		if err != nil {
+			code = stream.ReplyError(err).Code
+		}
+		if code != test.code {
+			t.Errorf("%s: expected %q, got %v", test.name, test.code, err)
+		}
+	}
+
+	// Clients without the cluster ID may ask for the status.
+	if _, err := process(handler, "STATUS", map[string]string{client.MetaKeyNode: "n2"}, false); err != nil {
+		t.Errorf("status without the cluster ID is rejected, %v", err)
+	}
+}
+
+func TestHandler_Join(t *testing.T) {
+	handler := newHandler(t)
+	handler.RequirePeerAuth()
+	book, err := cluster.NewAddressBook(map[string]string{"n2": "localhost:7002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAddressBook(book)
+	handler.SetName("n1")
+	handler.SetAddress("localhost:7001")
+	join := map[string]string{client.MetaKeyNode: "n3"}
+
+	if _, err := process(handler, "JOIN n3 localhost:7003", join, true); stream.ReplyError(err).Code != client.CodeUnavailable {
+		t.Errorf("expected not initialized, got %v", err)
+	}
+	handler.SetCluster("c1")
+
+	// The joining node does not know the cluster ID, it gets the ID and
+	// nodes if it is the peer.
+	replies, err := process(handler, "JOIN n3 localhost:7003", join, true)
+	if err != nil || len(replies) != 1 {
+		t.Fatalf("unexpected replies %v, %v", replies, err)
+	}
+	info, err := (&client.Response{Message: replies[0]}).ClusterInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := map[string]string{"n1": "localhost:7001", "n2": "localhost:7002"}
+	if info.ClusterID != "c1" || !reflect.DeepEqual(info.Nodes, nodes) {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if _, err := process(handler, "JOIN n3 localhost:7003", join, false); err != stream.ErrNotPeer {
+		t.Errorf("expected %v, got %v", stream.ErrNotPeer, err)
+	}
+
+	// HELLO of other clients has no cluster ID.
+	for _, peer := range []bool{true, false} {
+		replies, err := process(handler, "HELLO 1", map[string]string{}, peer)
+		if err != nil || len(replies) != 1 {
+			t.Fatalf("unexpected replies %v, %v", replies, err)
+		}
+		hello, err := (&client.Response{Message: replies[0]}).HelloReply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[bool]string{true: "c1"}[peer]; hello.ClusterID != expected {
+			t.Errorf("peer %t: expected cluster %q, got %q", peer, expected, hello.ClusterID)
+		}
+	}
+}
+
+func TestHandler_Hello(t *testing.T) {
+	handler := newHandler(t)
+	replies, err := process(handler, fmt.Sprintf("HELLO 1,%d join", client.ProtocolVersion+1), map[string]string{}, false)
+	if err != nil || len(replies) != 1 {
+		t.Fatalf("unexpected replies %v, %v", replies, err)
+	}
+	hello, err := (&client.Response{Message: replies[0]}).HelloReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.Version != client.ProtocolVersion || !hello.Has(client.FeatureJoin) {
+		t.Errorf("unexpected reply %+v", hello)
+	}
+	_, err = process(handler, fmt.Sprintf("HELLO %d", client.ProtocolVersion+1), map[string]string{}, false)
+	if code := stream.ReplyError(err).Code; code != client.CodeIncompatible {
+		t.Errorf("expected %s, got %v", client.CodeIncompatible, err)
+	}
+}
+
+func TestHandler_Dump(t *testing.T) {
+	log, err := storage.NewLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Set(context.Background(), 1, "a")
+	log.Set(context.Background(), 9, "c")
+	log.Set(context.Background(), 4, "b")
+	handler, err := stream.NewHandler(log, &localPaxos{}, logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are replied with epochs from the epoch and counted by OK.
+	replies, err := process(handler, "DUMP 2", map[string]string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"4 b", "9 c", "OK 2"}; !reflect.DeepEqual(replies, expected) {
+		t.Errorf("expected %v, got %v", expected, replies)
+	}
+	if _, err := process(handler, "DUMP -1", map[string]string{}, false); err == nil {
+		t.Error("negative epoch is accepted")
+	}
+}
+
+func TestHandler_LogHash(t *testing.T) {
+	handler := newHandler(t)
+	replies := make(chan []string, 1)
+	go func() {
+		reply, err := process(handler, "LOGHASH 1 9223372036854775807 1", map[string]string{}, false)
+		if err != nil {
+			t.Error(err)
+		}
+		replies <- reply
+	}()
+	select {
+	case reply := <-replies:
+		if len(reply) != 1 || !strings.Contains(reply[0], `"from":1,"to":9223372036854775807`) {
+			t.Errorf("unexpected reply %v", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LOGHASH of the widest range does not return")
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/cluster"
+	"github.com/tariel-x/stream/logger"
+)
+
+// issue makes the certificate signed by the parent, the certificate is the
+// CA if the parent is nil.
+func issue(t *testing.T, name string, parent *tls.Certificate, usages ...x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  usages,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServer_TLSPeer(t *testing.T) {
+	ca := issue(t, "ca", nil)
+	peerCA := issue(t, "peer ca", nil)
+	node := issue(t, "node", &ca, x509.ExtKeyUsageServerAuth)
+	peer := issue(t, "peer", &peerCA, x509.ExtKeyUsageClientAuth)
+	serverOnly := issue(t, "server only", &peerCA, x509.ExtKeyUsageServerAuth)
+	user := issue(t, "user", &ca, x509.ExtKeyUsageClientAuth)
+
+	roots, peerRoots := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	peerRoots.AddCert(peerCA.Leaf)
+
+	handler := newHandler(t)
+	handler.RequirePeerAuth()
+	book, err := cluster.NewAddressBook(map[string]string{"n2": "localhost:7002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAddressBook(book)
+	address := freeAddress(t)
+	server, err := NewServer(address, handler, logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetTLS(&tls.Config{
+		Certificates: []tls.Certificate{node},
+		ClientCAs:    peerRoots,
+		ClientAuth:   tls.RequestClientCert,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+
+	set := func(certificates []tls.Certificate, line string) string {
+		var conn *tls.Conn
+		for i := 0; i < 50; i++ {
+			if conn, err = tls.Dial("tcp", address, &tls.Config{RootCAs: roots, Certificates: certificates}); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(reply)
+	}
+
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		peer         bool
+	}{
+		{"peer", []tls.Certificate{peer}, true},
+		{"client certificate of the CA", []tls.Certificate{user}, false},
+		{"server certificate of the peer CA", []tls.Certificate{serverOnly}, false},
+		{"no certificate", nil, false},
+	}
+	for _, test := range tests {
+		reply := set(test.certificates, "SET 1 id v")
+		if peer := reply == "OK"; peer != test.peer {
+			t.Errorf("%s: expected peer %t, got %q", test.name, test.peer, reply)
+		}
+	}
+
+	// Addresses are learned only if the certificate is valid for them.
+	for _, test := range []struct {
+		addr     string
+		expected string
+	}{
+		{"10.0.0.2:7002", "localhost:7002"},
+		{"127.0.0.1:7012", "127.0.0.1:7012"},
+	} {
+		if reply := set([]tls.Certificate{peer}, "SET 2 id v;node=n2;addr="+test.addr); reply != "OK" {
+			t.Errorf("%s: unexpected reply %q", test.addr, reply)
+		}
+		if address, _ := book.Address("n2"); address != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.addr, test.expected, address)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/stream"
+)
+
+// The minimal server side of RFC 6455, enough to send text messages and
+// receive small control messages from browsers.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	closeNormal     = 1000
+	closeGoingAway  = 1001
+	closeProtocol   = 1002
+	closePolicy     = 1008
+	closeInternal   = 1011
+	maxClientFrame  = 64 * 1024
+	maxClientFrames = 16
+)
+
+var (
+	ErrNotWebsocket    = errors.New("not a websocket handshake")
+	ErrOriginForbidden = errors.New("origin is not allowed")
+	ErrWebsocketFrame  = errors.New("invalid websocket frame")
+	ErrWebsocketClosed = errors.New("websocket closed")
+)
+
+type websocketConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeTimeout time.Duration
+	m            sync.Mutex
+}
+
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request, writeTimeout time.Duration) (*websocketConn, error) {
+	if r.Method != http.MethodGet ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		return nil, ErrNotWebsocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can not be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+	if writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, reader: rw.Reader, writeTimeout: writeTimeout}, nil
+}
+
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *websocketConn) WriteText(message string) error {
+	return c.writeFrame(opText, []byte(message))
+}
+
+// WriteClose sends the close frame with the status code.
+func (c *websocketConn) WriteClose(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	return c.writeFrame(opClose, append(payload, reason...))
+}
+
+func (c *websocketConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7F)
+	// Frames of clients are always masked.
+	if !masked {
+		return false, 0, nil, ErrWebsocketFrame
+	}
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > maxClientFrame {
+		return false, 0, nil, ErrWebsocketFrame
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage reads the next data message. Pings are answered, the close
+// frame is answered and reported by ErrWebsocketClosed.
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	frames := 0
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.WriteClose(closeNormal, "")
+			return nil, ErrWebsocketClosed
+		case opText, opBinary:
+			// The data frame starts the message.
+			if frames > 0 {
+				return nil, ErrWebsocketFrame
+			}
+		case opContinuation:
+			if frames == 0 {
+				return nil, ErrWebsocketFrame
+			}
+		default:
+			return nil, ErrWebsocketFrame
+		}
+		frames++
+		if frames > maxClientFrames {
+			return nil, ErrWebsocketFrame
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// allowedOrigin checks the origin of the page which opens the websocket.
+// Browsers always send the origin, so requests without it are not sent by
+// pages.
+func (server *HTTPServer) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || server.origins["*"] || server.origins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+type resumeMessage struct {
+	From *int `json:"from"`
+}
+
+// subscribe performs PULL over the websocket. Every entry is sent as the
+// text message {"value":"a"}. The client may send {"from":n} to restart
+// the subscription from the epoch n.
+func (server *HTTPServer) subscribe(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	from, err := parseFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !server.allowedOrigin(r) {
+		server.logger.Warn("websocket origin is not allowed", "address", r.RemoteAddr, "origin", r.Header.Get("Origin"))
+		writeError(w, http.StatusForbidden, ErrOriginForbidden)
+		return
+	}
+	conn, err := upgradeWebsocket(w, r, server.writeTimeout)
+	if err == ErrNotWebsocket {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		server.logger.Warn("error upgrading to websocket", "address", r.RemoteAddr, "error", err)
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	server.logger.Debug("websocket opened", "address", r.RemoteAddr, "from", from)
+
+	// The browser messages are read in background, the read error means
+	// the browser has gone.
+	positions := make(chan int)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			resume := resumeMessage{}
+			if err := json.Unmarshal(message, &resume); err != nil || resume.From == nil || *resume.From < 0 {
+				conn.WriteClose(closeProtocol, `expected {"from":n}`)
+				readErr <- fmt.Errorf("invalid resume message %q", message)
+				return
+			}
+			select {
+			case positions <- *resume.From:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		pullCtx, cancel := context.WithCancel(ctx)
+		pullErr := make(chan error, 1)
+		go func(from int) {
+			pullErr <- server.process(pullCtx, r, fmt.Sprintf("%s %d", client.CmdPull, from), func(message string) error {
+				encoded, err := json.Marshal(valueReply{Value: message})
+				if err != nil {
+					return err
+				}
+				return conn.WriteText(string(encoded))
+			})
+		}(from)
+
+		select {
+		case from = <-positions:
+			cancel()
+			<-pullErr
+			server.logger.Debug("websocket resumed", "address", r.RemoteAddr, "from", from)
+			continue
+		case err := <-readErr:
+			cancel()
+			<-pullErr
+			if err != ErrWebsocketClosed && err != io.EOF {
+				server.logger.Debug("websocket read failed", "address", r.RemoteAddr, "error", err)
+			}
+		case <-ctx.Done():
+			cancel()
+			<-pullErr
+			conn.WriteClose(closeGoingAway, client.ErrClosing.Error())
+		case err := <-pullErr:
+			cancel()
+			if err == nil {
+				conn.WriteClose(closeNormal, "")
+			} else if code := stream.ReplyError(err).Code; code == client.CodeUnauthorized || code == client.CodePermissionDenied {
+				conn.WriteClose(closePolicy, err.Error())
+			} else {
+				server.logger.Warn("error pulling", "address", r.RemoteAddr, "error", err)
+				conn.WriteClose(closeInternal, "")
+			}
+		}
+		server.logger.Debug("websocket closed", "address", r.RemoteAddr)
+		return
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/logger"
+)
+
+// clientFrame makes the masked frame as browsers send.
+func clientFrame(fin bool, opcode byte, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebsocketConn_ReadMessage(t *testing.T) {
+	server, browser := net.Pipe()
+	defer server.Close()
+	defer browser.Close()
+	conn := &websocketConn{conn: server, reader: bufio.NewReader(server)}
+
+	go func() {
+		browser.Write(clientFrame(false, opText, `{"from":`))
+		browser.Write(clientFrame(true, opPing, "hi"))
+		browser.Write(clientFrame(true, opContinuation, `5}`))
+		browser.Write(clientFrame(true, opClose, ""))
+	}()
+	replies := make(chan []byte, 2)
+	go func() {
+		reader := bufio.NewReader(browser)
+		for i := 0; i < 2; i++ {
+			header := make([]byte, 2)
+			reader.Read(header)
+			payload := make([]byte, header[1])
+			reader.Read(payload)
+			replies <- append(header, payload...)
+		}
+	}()
+
+	message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != `{"from":5}` {
+		t.Errorf("unexpected message %q", message)
+	}
+	if pong := <-replies; pong[0] != 0x80|opPong || string(pong[2:]) != "hi" {
+		t.Errorf("unexpected pong %v", pong)
+	}
+	if _, err := conn.ReadMessage(); err != ErrWebsocketClosed {
+		t.Errorf("expected close, got %v", err)
+	}
+	if reply := <-replies; reply[0] != 0x80|opClose {
+		t.Errorf("unexpected close reply %v", reply)
+	}
+}
+
+func TestWebsocketConn_ReadMessage_Continuation(t *testing.T) {
+	server, browser := net.Pipe()
+	defer server.Close()
+	defer browser.Close()
+	conn := &websocketConn{conn: server, reader: bufio.NewReader(server)}
+	go browser.Write(clientFrame(true, opContinuation, "5}"))
+	if _, err := conn.ReadMessage(); err != ErrWebsocketFrame {
+		t.Errorf("expected %v, got %v", ErrWebsocketFrame, err)
+	}
+
+	go func() {
+		browser.Write(clientFrame(false, opText, `{"from":`))
+		browser.Write(clientFrame(true, opText, `5}`))
+	}()
+	if _, err := conn.ReadMessage(); err != ErrWebsocketFrame {
+		t.Errorf("expected %v, got %v", ErrWebsocketFrame, err)
+	}
+}
+
+func TestWebsocketConn_WriteTimeout(t *testing.T) {
+	server, browser := net.Pipe()
+	defer server.Close()
+	defer browser.Close()
+	conn := &websocketConn{conn: server, reader: bufio.NewReader(server), writeTimeout: 10 * time.Millisecond}
+	// The browser does not read, so the frame is not written in time.
+	err := conn.WriteText("a")
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+// dialWebsocket performs the handshake with the origin and returns the
+// connection and the reply.
+func dialWebsocket(t *testing.T, address, path, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + address + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response
+}
+
+// readServerFrame reads the unmasked frame of the server.
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func TestHTTPServer_Subscribe(t *testing.T) {
+	server, err := NewHTTPServer("", newHandler(t), logger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAllowedOrigins([]string{"https://dashboard.example.com/"})
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(server.Handler(ctx))
+	defer srv.Close()
+	defer cancel()
+	address := strings.TrimPrefix(srv.URL, "http://")
+	for _, v := range []string{"a", "b"} {
+		push(t, srv.URL, `{"value":"`+v+`"}`)
+	}
+
+	for origin, code := range map[string]int{
+		"https://evil.example.com":      http.StatusForbidden,
+		"https://dashboard.example.com": http.StatusSwitchingProtocols,
+		"http://" + address:             http.StatusSwitchingProtocols,
+		"":                              http.StatusSwitchingProtocols,
+	} {
+		conn, _, response := dialWebsocket(t, address, "/ws/pull", origin)
+		conn.Close()
+		if response.StatusCode != code {
+			t.Errorf("origin %q: expected %d, got %d", origin, code, response.StatusCode)
+		}
+	}
+	response, err := http.Get(srv.URL + "/ws/pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected code %d of the plain request", response.StatusCode)
+	}
+
+	conn, reader, response := dialWebsocket(t, address, "/ws/pull?from=2", "")
+	defer conn.Close()
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected accept %q", accept)
+	}
+	if opcode, payload := readServerFrame(t, reader); opcode != opText || string(payload) != `{"value":"b"}` {
+		t.Errorf("unexpected frame %d %q", opcode, payload)
+	}
+	// The subscription is restarted from the epoch of the browser.
+	conn.Write(clientFrame(true, opText, `{"from":1}`))
+	for _, expected := range []string{`{"value":"a"}`, `{"value":"b"}`} {
+		if opcode, payload := readServerFrame(t, reader); opcode != opText || string(payload) != expected {
+			t.Errorf("expected %s, got %d %q", expected, opcode, payload)
+		}
+	}
+
+	cancel()
+	opcode, payload := readServerFrame(t, reader)
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != closeGoingAway {
+		t.Errorf("unexpected frame %d %q", opcode, payload)
+	}
+}
@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tariel-x/stream/client"
+	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/paxos"
+	"github.com/tariel-x/stream/stream"
+)
+
+// Cluster is the set of stream nodes running in the single process and
+// talking to each other through the simulated network.
+type Cluster struct {
+	Network  *Network
+	names    []string
+	handlers map[string]*stream.Handler
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewCluster(seed int64, names []string, faults Faults) (*Cluster, error) {
+	network := NewNetwork(seed, faults)
+	c := &Cluster{
+		Network:  network,
+		names:    names,
+		handlers: map[string]*stream.Handler{},
+	}
+	for _, name := range names {
+		nodes := make([]paxos.Node, 0, len(names)-1)
+		peers := make([]stream.Peer, 0, len(names)-1)
+		for _, other := range names {
+			if other != name {
+				nodes = append(nodes, network.Node(name, other))
+				peers = append(peers, network.Node(name, other))
+			}
+		}
+		pxs, err := paxos.NewPaxosWithNodes(nodes, network.Random(), logger.Nop())
+		if err != nil {
+			return nil, err
+		}
+		pxs.SetGroup(network.Group)
+		lg, err := storage.NewLog()
+		if err != nil {
+			return nil, err
+		}
+		hndlr, err := stream.NewHandler(lg, pxs, logger.Nop())
+		if err != nil {
+			return nil, err
+		}
+		hndlr.SetName(name)
+		hndlr.SetPeers(peers)
+		c.handlers[name] = hndlr
+		network.Register(name, hndlr)
+	}
+	return c, nil
+}
+
+// Start runs the scheduler of the network.
+func (c *Cluster) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.Network.Run(ctx)
+	}()
+}
+
+// Stop stops the scheduler and waits for it.
+func (c *Cluster) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+func (c *Cluster) Names() []string {
+	return c.names
+}
+
+// Push sends the value to the node as an external client does.
+func (c *Cluster) Push(ctx context.Context, name, v string) error {
+	messages, err := c.process(ctx, name, &client.Push{V: v})
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 || messages[0] != client.CmdOK {
+		return fmt.Errorf("push %s to %s: unexpected reply %v", v, name, messages)
+	}
+	return nil
+}
+
+// Get reads the whole log of the node.
+func (c *Cluster) Get(ctx context.Context, name string) ([]string, error) {
+	return c.process(ctx, name, &client.Get{N: 0})
+}
+
+func (c *Cluster) process(ctx context.Context, name string, r client.Request) ([]string, error) {
+	h, ok := c.handlers[name]
+	if !ok {
+		return nil, ErrUnknownNode
+	}
+	// The client request is the work of the simulation until it is replied.
+	c.Network.acquire()
+	defer c.Network.release()
+	response := &Response{}
+	if err := h.Process(ctx, &Request{message: r.String(), name: "client"}, response); err != nil {
+		return nil, err
+	}
+	return response.Messages(), nil
+}
@@ -0,0 +1,212 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/stream"
+)
+
+// run pushes values to nodes one by one and returns the trace of the
+// network and logs of nodes.
+func run(t *testing.T, seed int64, faults Faults) ([]Event, map[string][]string) {
+	c, err := NewCluster(seed, []string{"a", "b", "c"}, faults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.Start(ctx)
+	defer c.Stop()
+
+	for i, name := range []string{"a", "b", "c", "a", "b"} {
+		if err := c.Push(ctx, name, fmt.Sprintf("v%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// SET messages are not waited by the proposer.
+	if err := c.Network.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	logs := map[string][]string{}
+	for _, name := range c.Names() {
+		if logs[name], err = c.Get(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c.Network.Trace(), logs
+}
+
+func TestCluster_Push(t *testing.T) {
+	_, logs := run(t, 1, Faults{MinDelay: 1, MaxDelay: 10, ReorderRate: 0.2})
+	if len(logs["a"]) != 5 {
+		t.Errorf("expected 5 values, got %v", logs["a"])
+	}
+	for _, name := range []string{"b", "c"} {
+		if !reflect.DeepEqual(logs[name], logs["a"]) {
+			t.Errorf("%s: %v != %v", name, logs[name], logs["a"])
+		}
+	}
+}
+
+// Lost SET messages are not sent again, so logs of nodes may differ, but
+// they differ the same way for the same seed.
+func TestCluster_Reproducible(t *testing.T) {
+	faults := Faults{MinDelay: 1, MaxDelay: 10, DropRate: 0.05, ReorderRate: 0.2}
+	trace, logs := run(t, 7, faults)
+	again, againLogs := run(t, 7, faults)
+	if !reflect.DeepEqual(again, trace) {
+		t.Errorf("traces of the same seed differ:\n%v\n%v", trace, again)
+	}
+	if !reflect.DeepEqual(againLogs, logs) {
+		t.Errorf("logs of the same seed differ: %v != %v", againLogs, logs)
+	}
+	if other, _ := run(t, 8, faults); reflect.DeepEqual(other, trace) {
+		t.Error("traces of different seeds are equal")
+	}
+}
+
+func newNetwork(t *testing.T, faults Faults) (*Network, context.Context, func()) {
+	c, err := NewCluster(1, []string{"a", "b"}, faults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	c.Start(ctx)
+	return c.Network, ctx, func() {
+		c.Stop()
+		cancel()
+	}
+}
+
+func TestNetwork_Drop(t *testing.T) {
+	network, ctx, stop := newNetwork(t, Faults{MinDelay: 1, MaxDelay: 1, DropRate: 1})
+	defer stop()
+	status := &client.Status{Scope: client.StatusNode}
+	if _, err := network.Node("a", "b").QueryOne(status); err != ErrDropped {
+		t.Errorf("expected %v, got %v", ErrDropped, err)
+	}
+
+	network.SetFaults(Faults{MinDelay: 1, MaxDelay: 1})
+	if _, err := network.Node("a", "b").QueryOne(status); err != nil {
+		t.Error(err)
+	}
+	if err := network.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	trace := network.Trace()
+	if len(trace) != 3 || !trace[0].Dropped || trace[1].Dropped || trace[2].Dropped {
+		t.Errorf("unexpected trace %v", trace)
+	}
+}
+
+func TestNetwork_Reorder(t *testing.T) {
+	delivered := func(faults Faults) []string {
+		network, ctx, stop := newNetwork(t, faults)
+		defer stop()
+		node := network.Node("a", "b")
+		for i := 10; i < 30; i++ {
+			node.Exec(&client.Set{N: i, ID: "id", V: "v"})
+		}
+		if err := network.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var messages []string
+		for _, event := range network.Trace() {
+			if event.To == "b" {
+				messages = append(messages, event.Message)
+			}
+		}
+		return messages
+	}
+
+	ordered := delivered(Faults{MinDelay: 1, MaxDelay: 1})
+	if len(ordered) != 20 || !sort.StringsAreSorted(ordered) {
+		t.Errorf("messages are reordered without faults: %v", ordered)
+	}
+	reordered := delivered(Faults{MinDelay: 1, MaxDelay: 1, ReorderRate: 0.5})
+	if len(reordered) != 20 || sort.StringsAreSorted(reordered) {
+		t.Errorf("messages are not reordered: %v", reordered)
+	}
+}
+
+func TestNetwork_Partition(t *testing.T) {
+	network, _, stop := newNetwork(t, Faults{MinDelay: 1, MaxDelay: 1})
+	defer stop()
+	status := &client.Status{Scope: client.StatusNode}
+	network.Partition("a", "b")
+	if _, err := network.Node("a", "b").QueryOne(status); err != ErrPartitioned {
+		t.Errorf("expected %v, got %v", ErrPartitioned, err)
+	}
+	if _, err := network.Node("b", "a").QueryOne(status); err != ErrPartitioned {
+		t.Errorf("expected %v, got %v", ErrPartitioned, err)
+	}
+	network.Heal()
+	if _, err := network.Node("a", "b").QueryOne(status); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCluster_Status(t *testing.T) {
+	c, err := NewCluster(1, []string{"a", "b", "c"}, Faults{MinDelay: 1, MaxDelay: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	c.Start(ctx)
+	defer c.Stop()
+
+	if err := c.Push(ctx, "a", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Network.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c.Network.Isolate("c")
+
+	messages, err := c.process(ctx, "a", &client.Status{Scope: client.StatusCluster})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := (&client.Response{Message: messages[0]}).ClusterStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Size != 3 || status.Reachable != 2 || !status.Quorum {
+		t.Errorf("unexpected cluster status %+v", status)
+	}
+	for _, node := range status.Nodes {
+		if node.Error == "" && node.Entries != 1 {
+			t.Errorf("%s: expected 1 entry, got %d", node.Name, node.Entries)
+		}
+		if node.Name == "c" && node.Error == "" {
+			t.Errorf("isolated node is reachable")
+		}
+	}
+}
+
+func TestCluster_PeerAuth(t *testing.T) {
+	c, err := NewCluster(1, []string{"a", "b", "c"}, Faults{MinDelay: 1, MaxDelay: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range c.handlers {
+		h.RequirePeerAuth()
+	}
+	ctx := context.Background()
+	c.Start(ctx)
+	defer c.Stop()
+
+	// Requests between nodes are peers, so consensus works.
+	if err := c.Push(ctx, "a", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.process(ctx, "b", &client.Set{N: 100, ID: "evil", V: "evil"}); err != stream.ErrNotPeer {
+		t.Errorf("expected %v, got %v", stream.ErrNotPeer, err)
+	}
+}
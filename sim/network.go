@@ -0,0 +1,540 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/paxos"
+	"github.com/tariel-x/stream/stream"
+)
+
+var (
+	ErrDropped     = errors.New("message dropped")
+	ErrPartitioned = errors.New("nodes partitioned")
+	ErrUnknownNode = errors.New("unknown node")
+	ErrStopped     = errors.New("network stopped")
+)
+
+// Faults describes how the network misbehaves. Delays are measured in
+// virtual ticks of the scheduler, not in real time.
+type Faults struct {
+	MinDelay int64
+	MaxDelay int64
+	// DropRate is the probability to lose a message or a reply.
+	DropRate float64
+	// ReorderRate is the probability to hold a message for an extra
+	// MaxDelay ticks, so later ones overtake it.
+	ReorderRate float64
+}
+
+type link struct {
+	from string
+	to   string
+}
+
+type envelope struct {
+	at      int64
+	seq     uint64
+	from    string
+	to      string
+	message string
+	dropped bool
+	deliver func(delivered bool)
+}
+
+// Event is the message delivered or lost by the network.
+type Event struct {
+	At      int64
+	From    string
+	To      string
+	Message string
+	Dropped bool
+}
+
+// Network is the fake network between simulated nodes. The pending
+// messages are delivered one by one by the scheduler, the next message is
+// the one with the least virtual delivery time. The scheduler delivers the
+// next message only when no work of the simulation is running, i.e. every
+// handler and paxos goroutine returned or waits for the network, and delays and losses of new messages are drawn in the order of their
+// content from the single seeded source, so the order of delivery depends
+// only on the seed and not on the scheduling of goroutines. Clients of the
+// simulation send requests one by one to keep it reproducible.
+type Network struct {
+	m          sync.Mutex
+	rand       *rand.Rand
+	faults     Faults
+	handlers   map[string]*stream.Handler
+	partitions map[link]struct{}
+	// pending are scheduled since the last delivery, delays are not drawn
+	// for them yet.
+	pending []*envelope
+	queue   []*envelope
+	trace   []Event
+	idle    []chan struct{}
+	now     int64
+	seq     uint64
+	wake    chan struct{}
+	stopped bool
+	// busy counts running handlers and goroutines of them which do not
+	// wait for the network.
+	busy int
+}
+
+func NewNetwork(seed int64, faults Faults) *Network {
+	return &Network{
+		rand:       rand.New(rand.NewSource(seed)),
+		faults:     faults,
+		handlers:   map[string]*stream.Handler{},
+		partitions: map[link]struct{}{},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (n *Network) Register(name string, handler *stream.Handler) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.handlers[name] = handler
+}
+
+func (n *Network) SetFaults(faults Faults) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.faults = faults
+}
+
+// Partition breaks the link between nodes in both directions.
+func (n *Network) Partition(a, b string) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.partitions[link{from: a, to: b}] = struct{}{}
+	n.partitions[link{from: b, to: a}] = struct{}{}
+}
+
+// Isolate breaks links between the node and all other nodes.
+func (n *Network) Isolate(name string) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	for other := range n.handlers {
+		if other == name {
+			continue
+		}
+		n.partitions[link{from: name, to: other}] = struct{}{}
+		n.partitions[link{from: other, to: name}] = struct{}{}
+	}
+}
+
+// Heal restores all broken links.
+func (n *Network) Heal() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.partitions = map[link]struct{}{}
+}
+
+// Random returns the random source which shares the seed of the network.
+// It is safe for concurrent use.
+func (n *Network) Random() *Random {
+	return &Random{network: n}
+}
+
+type Random struct {
+	network *Network
+}
+
+func (r *Random) Read(p []byte) (int, error) {
+	r.network.m.Lock()
+	defer r.network.m.Unlock()
+	return r.network.rand.Read(p)
+}
+
+func (n *Network) Now() int64 {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.now
+}
+
+// Trace returns messages delivered or lost so far in the order of
+// delivery.
+func (n *Network) Trace() []Event {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return append([]Event{}, n.trace...)
+}
+
+// Wait blocks until all messages are delivered and no work of the
+// simulation is running, e.g. until SET messages which are not waited by
+// the proposer reach all nodes.
+func (n *Network) Wait(ctx context.Context) error {
+	idle := make(chan struct{})
+	n.m.Lock()
+	if n.stopped {
+		n.m.Unlock()
+		return ErrStopped
+	}
+	n.idle = append(n.idle, idle)
+	n.m.Unlock()
+	n.poke()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-idle:
+	}
+	if n.isStopped() {
+		return ErrStopped
+	}
+	return nil
+}
+
+func (n *Network) isStopped() bool {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.stopped
+}
+
+func (n *Network) poke() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers messages until the context is done.
+func (n *Network) Run(ctx context.Context) {
+	defer func() {
+		n.m.Lock()
+		defer n.m.Unlock()
+		n.stopped = true
+		for _, e := range append(n.queue, n.pending...) {
+			go e.deliver(false)
+		}
+		n.queue, n.pending = nil, nil
+		for _, idle := range n.idle {
+			close(idle)
+		}
+		n.idle = nil
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.wake:
+		}
+		for {
+			if !n.settle(ctx) {
+				return
+			}
+			e := n.next()
+			if e == nil {
+				n.notifyIdle()
+				break
+			}
+			e.deliver(!e.dropped)
+		}
+	}
+}
+
+// settle waits until no work is running, so all messages caused by the last
+// delivery are scheduled, and draws delays and losses of them.
+func (n *Network) settle(ctx context.Context) bool {
+	n.m.Lock()
+	for n.busy > 0 {
+		n.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-n.wake:
+		}
+		n.m.Lock()
+	}
+	defer n.m.Unlock()
+	// Goroutines schedule messages in any order, the content orders them.
+	sort.SliceStable(n.pending, func(i, j int) bool {
+		a, b := n.pending[i], n.pending[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		if a.to != b.to {
+			return a.to < b.to
+		}
+		return a.message < b.message
+	})
+	for _, e := range n.pending {
+		delay := n.faults.MinDelay
+		if n.faults.MaxDelay > n.faults.MinDelay {
+			delay += n.rand.Int63n(n.faults.MaxDelay - n.faults.MinDelay + 1)
+		}
+		if n.faults.ReorderRate > 0 && n.rand.Float64() < n.faults.ReorderRate {
+			delay += n.faults.MaxDelay
+		}
+		e.dropped = n.faults.DropRate > 0 && n.rand.Float64() < n.faults.DropRate
+		e.at = n.now + delay
+		n.seq++
+		e.seq = n.seq
+		n.queue = append(n.queue, e)
+	}
+	n.pending = nil
+	return true
+}
+
+// acquire counts the work which starts or resumes running.
+func (n *Network) acquire() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.busy++
+}
+
+// release counts the work which returns or starts waiting for the network,
+// the scheduler is woken up when nothing runs.
+func (n *Network) release() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.releaseLocked()
+}
+
+func (n *Network) releaseLocked() {
+	n.busy--
+	if n.busy == 0 {
+		n.poke()
+	}
+}
+
+func (n *Network) notifyIdle() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	for _, idle := range n.idle {
+		close(idle)
+	}
+	n.idle = nil
+}
+
+func (n *Network) next() *envelope {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if len(n.queue) == 0 {
+		return nil
+	}
+	sort.Slice(n.queue, func(i, j int) bool {
+		a, b := n.queue[i], n.queue[j]
+		if a.at != b.at {
+			return a.at < b.at
+		}
+		return a.seq < b.seq
+	})
+	e := n.queue[0]
+	n.queue = n.queue[1:]
+	if e.at > n.now {
+		n.now = e.at
+	}
+	n.trace = append(n.trace, Event{At: e.at, From: e.from, To: e.to, Message: e.message, Dropped: e.dropped})
+	return e
+}
+
+// schedule puts the message to the queue. The lost message is delivered
+// too, but with the false flag, so the sender learns about the loss.
+func (n *Network) schedule(from, to, message string, deliver func(delivered bool)) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.stopped {
+		return ErrStopped
+	}
+	if _, ok := n.partitions[link{from: from, to: to}]; ok {
+		return ErrPartitioned
+	}
+	n.pending = append(n.pending, &envelope{
+		from:    from,
+		to:      to,
+		message: message,
+		deliver: deliver,
+	})
+	n.poke()
+	return nil
+}
+
+func (n *Network) handler(name string) (*stream.Handler, bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	h, ok := n.handlers[name]
+	return h, ok
+}
+
+type reply struct {
+	messages []string
+	err      error
+}
+
+// send delivers the message to the node and the node's reply back. If the
+// sender waits for the reply, the work of it resumes with the reply.
+func (n *Network) send(ctx context.Context, from, to, message string, wait bool) chan reply {
+	replies := make(chan reply, 1)
+	push := func(r reply) {
+		if wait {
+			n.acquire()
+		}
+		replies <- r
+	}
+	err := n.schedule(from, to, message, func(delivered bool) {
+		if !delivered {
+			push(reply{err: ErrDropped})
+			return
+		}
+		h, ok := n.handler(to)
+		if !ok {
+			push(reply{err: ErrUnknownNode})
+			return
+		}
+		response := &Response{}
+		err := h.Process(ctx, &Request{message: message, name: from, peer: true}, response)
+		replyMessage := strings.Join(response.Messages(), "\n")
+		if err != nil {
+			replyMessage = err.Error()
+		}
+		scheduleErr := n.schedule(to, from, replyMessage, func(delivered bool) {
+			switch {
+			case !delivered:
+				push(reply{err: ErrDropped})
+			case err != nil:
+				push(reply{err: err})
+			default:
+				push(reply{messages: response.Messages()})
+			}
+		})
+		if scheduleErr != nil {
+			push(reply{err: scheduleErr})
+		}
+	})
+	if err != nil {
+		push(reply{err: err})
+	}
+	return replies
+}
+
+// Node is the paxos node which sends messages through the network.
+type Node struct {
+	network *Network
+	from    string
+	to      string
+}
+
+func (n *Network) Node(from, to string) *Node {
+	return &Node{
+		network: n,
+		from:    from,
+		to:      to,
+	}
+}
+
+func (n *Node) String() string {
+	return n.to
+}
+
+func (n *Node) QueryOne(r client.Request) (*client.Response, error) {
+	replies := n.network.send(context.Background(), n.from, n.to, r.String(), true)
+	n.network.release()
+	reply := <-replies
+	if reply.err != nil {
+		return nil, reply.err
+	}
+	if len(reply.messages) == 0 {
+		return nil, ErrDropped
+	}
+	return &client.Response{Message: reply.messages[0]}, nil
+}
+
+func (n *Node) Exec(r client.Request) error {
+	n.network.send(context.Background(), n.from, n.to, r.String(), false)
+	return nil
+}
+
+// Group counts goroutines of paxos as the running work until they return.
+// The goroutine waiting for the group passes the work to the last of them.
+type Group struct {
+	network *Network
+	running int
+	waiting bool
+	done    chan struct{}
+}
+
+func (n *Network) Group() paxos.Group {
+	return &Group{
+		network: n,
+		done:    make(chan struct{}),
+	}
+}
+
+func (g *Group) Go(f func()) {
+	g.network.m.Lock()
+	g.network.busy++
+	g.running++
+	g.network.m.Unlock()
+	go func() {
+		f()
+		g.network.m.Lock()
+		defer g.network.m.Unlock()
+		g.running--
+		if g.running == 0 && g.waiting {
+			// The waiting goroutine resumes with the work of this one.
+			close(g.done)
+			return
+		}
+		g.network.releaseLocked()
+	}()
+}
+
+func (g *Group) Wait() {
+	g.network.m.Lock()
+	if g.running == 0 {
+		g.network.m.Unlock()
+		return
+	}
+	g.waiting = true
+	g.network.releaseLocked()
+	g.network.m.Unlock()
+	<-g.done
+}
+
+type Request struct {
+	message string
+	name    string
+	peer    bool
+}
+
+func (r *Request) Message() string {
+	return r.message
+}
+
+func (r *Request) Address() string {
+	return r.name
+}
+
+func (r *Request) Name() string {
+	return r.name
+}
+
+func (r *Request) Peer() bool {
+	return r.peer
+}
+
+func (r *Request) Meta() map[string]string {
+	return nil
+}
+
+type Response struct {
+	m        sync.Mutex
+	messages []string
+}
+
+func (r *Response) Push(message string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.messages = append(r.messages, message)
+}
+
+func (r *Response) Messages() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]string{}, r.messages...)
+}
@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tariel-x/stream/client"
+)
+
+// ErrNotInitialized is replied to JOIN if the node has no cluster ID.
+var ErrNotInitialized = &client.Error{Code: client.CodeUnavailable, Message: "cluster is not initialized"}
+
+// handshake checks the cluster ID and the protocol version of requests of
+// peers, they are sent in the meta of every request. PREPARE, ACCEPT and SET
+// must have the cluster ID of the node. The version is any supported one,
+// requests without the version are of the first version, HELLO negotiates
+// it.
+func (h *Handler) handshake(cmd string, message ServerRequest) error {
+	meta := message.Meta()
+	_, internal := internalCmds[cmd]
+	if !internal && meta[client.MetaKeyNode] == "" {
+		return nil
+	}
+	if proto := meta[client.MetaKeyProtocol]; proto != "" && cmd != client.CmdHello {
+		version, err := strconv.Atoi(proto)
+		if err != nil || !client.SupportedVersion(version) {
+			handshakeFailuresTotal.Inc(client.CodeIncompatible)
+			h.logger.Warn("incompatible peer", "name", message.Name(), "address", message.Address(), "protocol", proto)
+			return &client.Error{
+				Code:    client.CodeIncompatible,
+				Message: fmt.Sprintf("protocol version %s is not supported, expected %s", proto, versionsString(client.ProtocolVersions())),
+			}
+		}
+	}
+	cluster := meta[client.MetaKeyCluster]
+	switch {
+	case h.clusterID == "" || cluster == h.clusterID:
+		return nil
+	case cluster == "" && (cmd == client.CmdJoin || !internal):
+		// The joining node does not know the ID yet, nodes without the ID
+		// may ask for the status.
+		return nil
+	}
+	handshakeFailuresTotal.Inc(client.CodeWrongCluster)
+	h.logger.Warn("peer of other cluster", "name", message.Name(), "address", message.Address(), "cluster", cluster)
+	return &client.Error{
+		Code:    client.CodeWrongCluster,
+		Message: fmt.Sprintf("cluster %q is not %s", cluster, h.clusterID),
+	}
+}
+
+// Join replies to the node joining the cluster with the cluster ID and
+// nodes. JOIN is the peer command, so only authenticated peers get them if
+// peers are authenticated. Nodes of the cluster are not changed, the
+// joining node is added to their nodes by the operator.
+func (h *Handler) Join(request *JoinRequest, response ServerResponse) error {
+	if h.clusterID == "" {
+		return ErrNotInitialized
+	}
+	nodes := map[string]string{}
+	if h.book != nil {
+		nodes = h.book.Addresses()
+	}
+	if h.name != "" {
+		nodes[h.name] = h.address
+	}
+	if _, ok := nodes[request.id]; ok {
+		h.logger.Info("node joins", "node", request.id, "address", request.address)
+	} else {
+		h.logger.Warn("node joins, it is not a member until nodes are restarted with it", "node", request.id, "address", request.address)
+	}
+	encoded, err := json.Marshal(client.ClusterInfo{
+		ClusterID: h.clusterID,
+		Protocol:  client.ProtocolVersion,
+		Nodes:     nodes,
+	})
+	if err != nil {
+		return err
+	}
+	response.Push(fmt.Sprintf("%s %s", client.CmdOK, encoded))
+	return nil
+}
+
+// Hello replies with the highest protocol version supported by both nodes
+// and features of the node.
+func (h *Handler) Hello(request *HelloRequest, response ServerResponse) error {
+	version, ok := client.CommonVersion(request.versions)
+	if !ok {
+		handshakeFailuresTotal.Inc(client.CodeIncompatible)
+		return &client.Error{
+			Code: client.CodeIncompatible,
+			Message: fmt.Sprintf("no common protocol version, offered %s, supported %s",
+				versionsString(request.versions), versionsString(client.ProtocolVersions())),
+		}
+	}
+	h.logger.Debug("hello", "version", version, "versions", versionsString(request.versions), "features", strings.Join(request.features, ","))
+	// The cluster ID is sent only to peers if peers are authenticated.
+	clusterID := h.clusterID
+	if h.peerAuth && !request.peer {
+		clusterID = ""
+	}
+	encoded, err := json.Marshal(client.HelloReply{
+		Version:   version,
+		Versions:  client.ProtocolVersions(),
+		Features:  client.Features,
+		Node:      h.name,
+		ClusterID: clusterID,
+	})
+	if err != nil {
+		return err
+	}
+	response.Push(fmt.Sprintf("%s %s", client.CmdOK, encoded))
+	return nil
+}
+
+func versionsString(versions []int) string {
+	parts := make([]string, 0, len(versions))
+	for _, version := range versions {
+		parts = append(parts, strconv.Itoa(version))
+	}
+	return strings.Join(parts, ",")
+}
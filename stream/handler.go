@@ -2,16 +2,26 @@ package stream
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tariel-x/stream/client"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/tracing"
 )
 
+// Errors are replied as ERR <code> <message>.
 var (
-	ErrUnknownCmd   = errors.New("unknown cmd")
-	ErrIncorrectCmd = errors.New("incorrect cmd")
+	ErrUnknownCmd   = &client.Error{Code: client.CodeUnknownCommand, Message: "unknown cmd"}
+	ErrIncorrectCmd = &client.Error{Code: client.CodeBadArgs, Message: "incorrect cmd"}
+	ErrNotPeer      = &client.Error{Code: client.CodeUnauthorized, Message: "peer authentication required"}
+	// ErrUnauthorized is replied to wrong credentials.
+	ErrUnauthorized = &client.Error{Code: client.CodeUnauthorized, Message: "unauthorized"}
+	// ErrPermissionDenied is replied if the user may not run the command.
+	ErrPermissionDenied = &client.Error{Code: client.CodePermissionDenied, Message: "permission denied"}
 
 	ResponseOK = "ok"
 
@@ -23,6 +33,29 @@ var (
 		client.CmdPrepare: {},
 		client.CmdAccept:  {},
 		client.CmdSet:     {},
+		client.CmdJoin:    {},
+		client.CmdHello:   {},
+		client.CmdDump:    {},
+		client.CmdLogHash: {},
+	}
+
+	// internalCmds are sent by nodes to each other.
+	internalCmds = map[string]struct{}{
+		client.CmdPrepare: {},
+		client.CmdAccept:  {},
+		client.CmdSet:     {},
+		client.CmdJoin:    {},
+	}
+
+	// aclCmds are checked by the authenticator, they are allowed by the
+	// permission of the command.
+	aclCmds = map[string]string{
+		client.CmdPush:    client.CmdPush,
+		client.CmdGet:     client.CmdGet,
+		client.CmdPull:    client.CmdPull,
+		client.CmdDump:    client.CmdGet,
+		client.CmdLogHash: client.CmdGet,
+		client.CmdStatus:  client.CmdStatus,
 	}
 )
 
@@ -30,6 +63,61 @@ type ServerRequest interface {
 	Message() string
 	Address() string
 	Name() string
+	// Peer returns true if the request is authenticated as sent by the
+	// other node of the cluster.
+	Peer() bool
+	// Meta returns the meta of the request, e.g. credentials.
+	Meta() map[string]string
+}
+
+// Authenticator checks credentials of clients and their permissions.
+type Authenticator interface {
+	Authenticate(meta map[string]string) (string, bool)
+	Allowed(user, cmd string) bool
+}
+
+// Limiter throttles PUSH and PULL of clients by the authenticated user or by
+// the name if the user is empty.
+type Limiter interface {
+	Push(user, name string, bytes int) time.Duration
+	Pull(ctx context.Context, user, name string, bytes int) error
+}
+
+// ThrottledError is replied to PUSH over the rate limit of the client with
+// the THROTTLED code.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	// Round up, so the client retrying after the reply is not throttled
+	// again.
+	retryAfter := (e.RetryAfter + time.Millisecond - 1).Truncate(time.Millisecond)
+	return fmt.Sprintf("%s%s", client.ThrottledPrefix, retryAfter)
+}
+
+// ReplyError returns the error with the code to reply to the client.
+// Unknown errors are INTERNAL.
+func ReplyError(err error) *client.Error {
+	switch e := err.(type) {
+	case *client.Error:
+		return e
+	case *ThrottledError:
+		return &client.Error{Code: client.CodeThrottled, Message: e.Error()}
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return &client.Error{Code: client.CodeUnavailable, Message: err.Error()}
+	}
+	return &client.Error{Code: client.CodeInternal, Message: err.Error()}
+}
+
+// parseEpoch parses the epoch argument.
+func parseEpoch(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, &client.Error{Code: client.CodeBadArgs, Message: fmt.Sprintf("invalid epoch %q", arg)}
+	}
+	return n, nil
 }
 
 type ServerResponse interface {
@@ -40,6 +128,10 @@ type Log interface {
 	Set(context.Context, int, string) error
 	Get(context.Context, int) ([]string, error)
 	Pull(context.Context, int) (chan string, error)
+	Entries(ctx context.Context, from, to int, each func(n int, v string) error) error
+	Count() uint64
+	Size() uint64
+	Last() int
 }
 
 type AcceptMessage interface {
@@ -49,28 +141,105 @@ type AcceptMessage interface {
 }
 
 type Paxos interface {
-	Commit(string) ([]AcceptMessage, error)
+	Commit(context.Context, string) ([]AcceptMessage, error)
 	Prepare(n int) (bool, AcceptMessage)
 	Accept(n int, v, id string) bool
 	Set(id string)
+	Ballot() int
+	Proposing() bool
+}
+
+// AddressBook maps IDs of nodes to their addresses.
+type AddressBook interface {
+	Update(id, address string) (string, bool)
+	Addresses() map[string]string
+}
+
+// Peer is the other node of the cluster, it is asked for its status.
+type Peer interface {
+	String() string
+	QueryOne(r client.Request) (*client.Response, error)
 }
 
 type Handler struct {
-	paxos Paxos
-	log   Log
+	paxos   Paxos
+	log     Log
+	logger  *logger.Logger
+	name    string
+	address string
+	peers   []Peer
+	started time.Time
+	// peerAuth rejects internal commands of not authenticated requests.
+	peerAuth bool
+	auth     Authenticator
+	limiter  Limiter
+	book     AddressBook
+	// clusterID is checked in requests of peers if it is set.
+	clusterID string
 }
 
-func NewHandler(log Log, paxos Paxos) (*Handler, error) {
+func NewHandler(log Log, paxos Paxos, lg *logger.Logger) (*Handler, error) {
 	return &Handler{
-		log:   log,
-		paxos: paxos,
+		log:     log,
+		paxos:   paxos,
+		logger:  lg.Component("stream"),
+		started: time.Now(),
 	}, nil
 }
 
+// SetName sets the name of the node reported by STATUS.
+func (h *Handler) SetName(name string) {
+	h.name = name
+}
+
+// SetAddress sets the address of the node reported by STATUS.
+func (h *Handler) SetAddress(address string) {
+	h.address = address
+}
+
+// RequirePeerAuth rejects PREPARE, ACCEPT and SET unless the request is
+// authenticated as sent by the peer.
+func (h *Handler) RequirePeerAuth() {
+	h.peerAuth = true
+}
+
+// SetAuth enables authentication of clients, PUSH, GET and PULL are
+// allowed by permissions of the user.
+func (h *Handler) SetAuth(auth Authenticator) {
+	h.auth = auth
+}
+
+// SetLimiter enables rate limits of PUSH and PULL, requests of peers are
+// not limited.
+func (h *Handler) SetLimiter(limiter Limiter) {
+	h.limiter = limiter
+}
+
+// SetAddressBook enables learning addresses of peers from their requests.
+func (h *Handler) SetAddressBook(book AddressBook) {
+	h.book = book
+}
+
+// SetCluster sets the cluster ID, requests of peers from other clusters
+// are rejected.
+func (h *Handler) SetCluster(id string) {
+	h.clusterID = id
+}
+
+// SetPeers sets the other nodes of the cluster reported by STATUS.
+func (h *Handler) SetPeers(peers []Peer) {
+	h.peers = peers
+}
+
 type Request struct {
 	ctx  context.Context
 	cmd  string
 	args []string
+	// user is the authenticated user and name is the name meta or the host
+	// of the client, they are keys of rate limits.
+	user string
+	name string
+	peer bool
 }
 
 func (h *Handler) Process(ctx context.Context, message ServerRequest, response ServerResponse) error {
@@ -78,7 +247,87 @@ func (h *Handler) Process(ctx context.Context, message ServerRequest, response S
 	if err != nil {
 		return err
 	}
+	ctx, span := tracing.Start(ctx, "stream."+strings.ToLower(parsed.cmd))
+	span.SetAttribute("name", message.Name())
 	parsed.ctx = ctx
+	parsed.name = clientName(message)
+	parsed.peer = message.Peer()
+	if parsed.user, err = h.authorize(parsed.cmd, message); err == nil {
+		if err = h.handshake(parsed.cmd, message); err == nil {
+			h.learnAddress(parsed.cmd, message)
+			err = h.process(parsed, response)
+		}
+	}
+	span.Finish(err)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		h.logger.Debug("request failed", "name", message.Name(), "cmd", parsed.cmd, "error", err)
+	}
+	requestsTotal.Inc(parsed.cmd, result)
+	return err
+}
+
+// authorize checks that the request may run the command and returns the
+// user if the request has credentials.
+func (h *Handler) authorize(cmd string, message ServerRequest) (string, error) {
+	if _, ok := internalCmds[cmd]; ok && h.peerAuth && !message.Peer() {
+		h.logger.Warn("internal command from not authenticated client", "name", message.Name(), "address", message.Address(), "cmd", cmd)
+		return "", ErrNotPeer
+	}
+	permission, ok := aclCmds[cmd]
+	if !ok || h.auth == nil || message.Peer() {
+		return "", nil
+	}
+	meta := message.Meta()
+	user, ok := h.auth.Authenticate(meta)
+	if !ok {
+		h.logger.Warn("wrong credentials", "name", message.Name(), "address", message.Address(), "cmd", cmd)
+		return "", ErrUnauthorized
+	}
+	if !h.auth.Allowed(user, permission) {
+		h.logger.Warn("permission denied", "user", user, "name", message.Name(), "cmd", cmd)
+		return "", ErrPermissionDenied
+	}
+	if meta[client.MetaKeyUser] == "" && meta[client.MetaKeyToken] == "" {
+		// Anonymous requests are limited by names.
+		return "", nil
+	}
+	return user, nil
+}
+
+// learnAddress updates the address of the peer sending its ID and its
+// address. Addresses are learned only from internal commands of
+// authenticated peers, the server keeps the address only if it is signed or
+// the certificate of the peer is valid for it.
+func (h *Handler) learnAddress(cmd string, message ServerRequest) {
+	if _, internal := internalCmds[cmd]; h.book == nil || !internal || !message.Peer() {
+		return
+	}
+	meta := message.Meta()
+	id, address := meta[client.MetaKeyNode], meta[client.MetaKeyAddress]
+	if id == "" || address == "" {
+		return
+	}
+	if previous, ok := h.book.Update(id, address); ok {
+		h.logger.Info("peer address changed", "node", id, "previous", previous, "address", address)
+	}
+}
+
+// clientName returns the name meta or the host of the client, the port
+// differs for every connection.
+func clientName(message ServerRequest) string {
+	if name := message.Meta()[client.MetaKeyName]; name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(message.Address())
+	if err != nil {
+		return message.Address()
+	}
+	return host
+}
+
+func (h *Handler) process(parsed *Request, response ServerResponse) error {
 	switch parsed.cmd {
 	case client.CmdPush:
 		request, err := NewPushRequest(*parsed)
@@ -99,7 +348,11 @@ func (h *Handler) Process(ctx context.Context, message ServerRequest, response S
 		}
 		return h.Pull(*request, response)
 	case client.CmdStatus:
-		return h.Status(response)
+		request, err := NewStatusRequest(*parsed)
+		if err != nil {
+			return err
+		}
+		return h.Status(request, response)
 	case client.CmdSet:
 		request, err := NewSetRequest(*parsed)
 		if err != nil {
@@ -118,6 +371,30 @@ func (h *Handler) Process(ctx context.Context, message ServerRequest, response S
 			return err
 		}
 		return h.Accept(request, response)
+	case client.CmdJoin:
+		request, err := NewJoinRequest(*parsed)
+		if err != nil {
+			return err
+		}
+		return h.Join(request, response)
+	case client.CmdDump:
+		request, err := NewDumpRequest(*parsed)
+		if err != nil {
+			return err
+		}
+		return h.Dump(*request, response)
+	case client.CmdLogHash:
+		request, err := NewLogHashRequest(*parsed)
+		if err != nil {
+			return err
+		}
+		return h.LogHash(*request, response)
+	case client.CmdHello:
+		request, err := NewHelloRequest(*parsed)
+		if err != nil {
+			return err
+		}
+		return h.Hello(request, response)
 	default:
 		return ErrUnknownCmd
 	}
@@ -135,7 +412,7 @@ func parseRawMessage(message string) (*Request, error) {
 	}
 
 	if _, ok := availableCmds[cmd]; !ok {
-		return nil, ErrIncorrectCmd
+		return nil, ErrUnknownCmd
 	}
 	args := strings.Split(rawArgs, " ")
 	return &Request{
@@ -156,10 +433,13 @@ func NewGetRequest(request Request) (*GetRequest, error) {
 	if len(request.args) == 0 {
 		return nil, ErrIncorrectCmd
 	}
-	n, err := strconv.Atoi(request.args[0])
+	n, err := parseEpoch(request.args[0])
 	if err != nil {
 		return nil, err
 	}
+	if n < 0 {
+		return nil, client.ErrOutOfRange
+	}
 	return &GetRequest{
 		Request: request,
 		n:       n,
@@ -178,16 +458,108 @@ func NewPullRequest(request Request) (*PullRequest, error) {
 	if len(request.args) == 0 {
 		return nil, ErrIncorrectCmd
 	}
-	n, err := strconv.Atoi(request.args[0])
+	n, err := parseEpoch(request.args[0])
 	if err != nil {
 		return nil, err
 	}
+	if n < 0 {
+		return nil, client.ErrOutOfRange
+	}
 	return &PullRequest{
 		Request: request,
 		n:       n,
 	}, nil
 }
 
+type DumpRequest struct {
+	Request
+	n int
+}
+
+func NewDumpRequest(request Request) (*DumpRequest, error) {
+	if request.cmd != client.CmdDump {
+		return nil, ErrIncorrectCmd
+	}
+	if len(request.args) == 0 {
+		return nil, ErrIncorrectCmd
+	}
+	n, err := parseEpoch(request.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, client.ErrOutOfRange
+	}
+	return &DumpRequest{
+		Request: request,
+		n:       n,
+	}, nil
+}
+
+type LogHashRequest struct {
+	Request
+	from   int
+	to     int
+	ranges int
+}
+
+func NewLogHashRequest(request Request) (*LogHashRequest, error) {
+	if request.cmd != client.CmdLogHash {
+		return nil, ErrIncorrectCmd
+	}
+	if len(request.args) != 3 {
+		return nil, ErrIncorrectCmd
+	}
+	from, err := parseEpoch(request.args[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseEpoch(request.args[1])
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 || to < from {
+		return nil, client.ErrOutOfRange
+	}
+	ranges, err := strconv.Atoi(request.args[2])
+	if err != nil || ranges < 1 || ranges > client.MaxLogHashRanges {
+		return nil, &client.Error{
+			Code:    client.CodeBadArgs,
+			Message: fmt.Sprintf("ranges must be from 1 to %d", client.MaxLogHashRanges),
+		}
+	}
+	return &LogHashRequest{
+		Request: request,
+		from:    from,
+		to:      to,
+		ranges:  ranges,
+	}, nil
+}
+
+type StatusRequest struct {
+	Request
+	scope string
+}
+
+func NewStatusRequest(request Request) (*StatusRequest, error) {
+	if request.cmd != client.CmdStatus {
+		return nil, ErrIncorrectCmd
+	}
+	scope := ""
+	if len(request.args) > 0 {
+		scope = strings.ToUpper(request.args[0])
+	}
+	switch scope {
+	case "", client.StatusNode, client.StatusCluster:
+	default:
+		return nil, ErrIncorrectCmd
+	}
+	return &StatusRequest{
+		Request: request,
+		scope:   scope,
+	}, nil
+}
+
 type PushRequest struct {
 	Request
 	v string
@@ -197,7 +569,7 @@ func NewPushRequest(request Request) (*PushRequest, error) {
 	if request.cmd != client.CmdPush {
 		return nil, ErrIncorrectCmd
 	}
-	if len(request.args) == 0 {
+	if len(request.args) == 0 || request.args[0] == "" {
 		return nil, ErrIncorrectCmd
 	}
 	return &PushRequest{
@@ -218,7 +590,7 @@ func NewPrepareRequest(request Request) (*PrepareRequest, error) {
 	if len(request.args) == 0 {
 		return nil, ErrIncorrectCmd
 	}
-	n, err := strconv.Atoi(request.args[0])
+	n, err := parseEpoch(request.args[0])
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +614,7 @@ func NewAcceptRequest(request Request) (*AcceptRequest, error) {
 	if len(request.args) != 3 {
 		return nil, ErrIncorrectCmd
 	}
-	n, err := strconv.Atoi(request.args[0])
+	n, err := parseEpoch(request.args[0])
 	if err != nil {
 		return nil, err
 	}
@@ -268,7 +640,7 @@ func NewSetRequest(request Request) (*SetRequest, error) {
 	if len(request.args) != 3 {
 		return nil, ErrIncorrectCmd
 	}
-	n, err := strconv.Atoi(request.args[0])
+	n, err := parseEpoch(request.args[0])
 	if err != nil {
 		return nil, err
 	}
@@ -279,3 +651,55 @@ func NewSetRequest(request Request) (*SetRequest, error) {
 		v:       request.args[2],
 	}, nil
 }
+
+type JoinRequest struct {
+	Request
+	id      string
+	address string
+}
+
+func NewJoinRequest(request Request) (*JoinRequest, error) {
+	if request.cmd != client.CmdJoin {
+		return nil, ErrIncorrectCmd
+	}
+	if len(request.args) != 2 || request.args[0] == "" || request.args[1] == "" {
+		return nil, ErrIncorrectCmd
+	}
+	return &JoinRequest{
+		Request: request,
+		id:      request.args[0],
+		address: request.args[1],
+	}, nil
+}
+
+type HelloRequest struct {
+	Request
+	versions []int
+	features []string
+}
+
+func NewHelloRequest(request Request) (*HelloRequest, error) {
+	if request.cmd != client.CmdHello {
+		return nil, ErrIncorrectCmd
+	}
+	if len(request.args) == 0 || request.args[0] == "" || len(request.args) > 2 {
+		return nil, ErrIncorrectCmd
+	}
+	var versions []int
+	for _, arg := range strings.Split(request.args[0], ",") {
+		version, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, &client.Error{Code: client.CodeBadArgs, Message: fmt.Sprintf("invalid protocol version %q", arg)}
+		}
+		versions = append(versions, version)
+	}
+	var features []string
+	if len(request.args) == 2 && request.args[1] != "" {
+		features = strings.Split(request.args[1], ",")
+	}
+	return &HelloRequest{
+		Request:  request,
+		versions: versions,
+		features: features,
+	}, nil
+}
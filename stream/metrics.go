@@ -0,0 +1,28 @@
+package stream
+
+import (
+	"github.com/tariel-x/stream/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter(
+		"stream_requests_total",
+		"Processed requests.",
+		"cmd", "result",
+	)
+	pushDuration = metrics.NewHistogram(
+		"stream_push_duration_seconds",
+		"Time to commit pushed value.",
+		nil,
+	)
+	throttledTotal = metrics.NewCounter(
+		"stream_throttled_total",
+		"Requests throttled by rate limits.",
+		"cmd",
+	)
+	handshakeFailuresTotal = metrics.NewCounter(
+		"stream_handshake_failures_total",
+		"Requests of peers rejected by the cluster ID or the protocol version.",
+		"code",
+	)
+)
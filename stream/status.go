@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+)
+
+// Version is the version of the node reported by STATUS.
+var Version = "0.1"
+
+// Roles of the node. There is no stable leader, every node proposes
+// values pushed to it, so the node is the proposer while it commits.
+const (
+	RoleProposer = "proposer"
+	RoleAcceptor = "acceptor"
+)
+
+const statusTimeout = 2 * time.Second
+
+// Status replies with the JSON status of the node, of the node with its
+// peers or of the whole cluster.
+func (h *Handler) Status(request *StatusRequest, response ServerResponse) error {
+	var status interface{}
+	switch request.scope {
+	case client.StatusNode:
+		status = h.nodeStatus()
+	case client.StatusCluster:
+		status = h.clusterStatus(request.ctx)
+	default:
+		nodeStatus := h.nodeStatus()
+		nodeStatus.Peers = h.peerStatuses(request.ctx, nodeStatus)
+		status = nodeStatus
+	}
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	response.Push(fmt.Sprintf("%s %s", client.CmdOK, encoded))
+	return nil
+}
+
+func (h *Handler) nodeStatus() client.NodeStatus {
+	role := RoleAcceptor
+	if h.paxos.Proposing() {
+		role = RoleProposer
+	}
+	return client.NodeStatus{
+		Name:      h.name,
+		Address:   h.address,
+		Role:      role,
+		Ballot:    h.paxos.Ballot(),
+		Committed: h.log.Last(),
+		Entries:   h.log.Count(),
+		Bytes:     h.log.Size(),
+		Uptime:    time.Since(h.started).Seconds(),
+		Version:   Version,
+		Protocols: client.ProtocolVersions(),
+	}
+}
+
+// queryPeers asks all peers for their node status concurrently. Peers not
+// replied in time are returned with the error.
+func (h *Handler) queryPeers(ctx context.Context) []client.NodeStatus {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	type reply struct {
+		i      int
+		status client.NodeStatus
+	}
+	replies := make(chan reply, len(h.peers))
+	for i, peer := range h.peers {
+		go func(i int, peer Peer) {
+			status := client.NodeStatus{Name: peer.String()}
+			response, err := peer.QueryOne(&client.Status{Scope: client.StatusNode})
+			if err == nil {
+				var replied *client.NodeStatus
+				replied, err = response.NodeStatus()
+				if err == nil {
+					status = *replied
+				}
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			replies <- reply{i: i, status: status}
+		}(i, peer)
+	}
+
+	statuses := make([]client.NodeStatus, len(h.peers))
+	for i, peer := range h.peers {
+		statuses[i] = client.NodeStatus{Name: peer.String(), Error: "status timeout"}
+	}
+	for range h.peers {
+		select {
+		case <-ctx.Done():
+			return statuses
+		case r := <-replies:
+			statuses[r.i] = r.status
+		}
+	}
+	return statuses
+}
+
+func (h *Handler) peerStatuses(ctx context.Context, self client.NodeStatus) []client.PeerStatus {
+	peers := []client.PeerStatus{}
+	for _, status := range h.queryPeers(ctx) {
+		peer := client.PeerStatus{
+			Name:      status.Name,
+			Address:   status.Address,
+			Reachable: status.Error == "",
+			Error:     status.Error,
+			Committed: status.Committed,
+			Entries:   status.Entries,
+		}
+		if peer.Reachable && self.Entries > status.Entries {
+			peer.Lag = self.Entries - status.Entries
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (h *Handler) clusterStatus(ctx context.Context) client.ClusterStatus {
+	nodes := append([]client.NodeStatus{h.nodeStatus()}, h.queryPeers(ctx)...)
+	cluster := client.ClusterStatus{
+		Nodes: nodes,
+		Size:  len(nodes),
+	}
+	var minEntries, maxEntries uint64
+	for _, node := range nodes {
+		if node.Error != "" {
+			continue
+		}
+		if cluster.Reachable == 0 || node.Entries < minEntries {
+			minEntries = node.Entries
+		}
+		if node.Entries > maxEntries {
+			maxEntries = node.Entries
+		}
+		if node.Committed > cluster.Committed {
+			cluster.Committed = node.Committed
+		}
+		cluster.Reachable++
+	}
+	cluster.Quorum = cluster.Reachable > cluster.Size/2
+	cluster.MaxLag = maxEntries - minEntries
+	return cluster
+}
@@ -1,16 +1,28 @@
 package stream
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/tariel-x/stream/client"
 )
 
 func (h *Handler) Push(request *PushRequest, response ServerResponse) error {
-	acceptedMessages, err := h.paxos.Commit(request.v)
+	if h.limiter != nil && !request.peer {
+		if retryAfter := h.limiter.Push(request.user, request.name, len(request.v)); retryAfter > 0 {
+			throttledTotal.Inc(client.CmdPush)
+			return &ThrottledError{RetryAfter: retryAfter}
+		}
+	}
+	started := time.Now()
+	acceptedMessages, err := h.paxos.Commit(request.ctx, request.v)
 	if err != nil {
 		return err
 	}
+	pushDuration.Observe(time.Since(started).Seconds())
+	h.logger.Debug("push committed", "slots", len(acceptedMessages), "duration", time.Since(started))
 	for _, acceptedMessage := range acceptedMessages {
 		if err := h.log.Set(request.ctx, acceptedMessage.N(), acceptedMessage.V()); err != nil {
 			return err
@@ -29,11 +41,6 @@ func (h *Handler) Set(request *SetRequest, response ServerResponse) error {
 	return nil
 }
 
-func (h *Handler) Status(response ServerResponse) error {
-	response.Push(client.CmdOK)
-	return nil
-}
-
 func (h *Handler) Get(request GetRequest, response ServerResponse) error {
 	results, err := h.log.Get(request.ctx, request.n)
 	if err != nil {
@@ -45,6 +52,42 @@ func (h *Handler) Get(request GetRequest, response ServerResponse) error {
 	return nil
 }
 
+// Dump replies with entries of the snapshot of the log as "<epoch> <value>"
+// lines and ends with "OK <entries>", so the reader knows the dump is
+// complete.
+func (h *Handler) Dump(request DumpRequest, response ServerResponse) error {
+	entries := 0
+	err := h.log.Entries(request.ctx, request.n, math.MaxInt64, func(n int, v string) error {
+		response.Push(fmt.Sprintf("%d %s", n, v))
+		entries++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	response.Push(fmt.Sprintf("%s %d", client.CmdOK, entries))
+	return nil
+}
+
+// LogHash replies with rolling hashes of entries in ranges of epochs, nodes
+// with equal hashes have equal entries in the range.
+func (h *Handler) LogHash(request LogHashRequest, response ServerResponse) error {
+	hasher := client.NewRangeHasher(request.from, request.to, request.ranges)
+	err := h.log.Entries(request.ctx, request.from, request.to, func(n int, v string) error {
+		hasher.Add(n, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(client.LogHashReply{Ranges: hasher.Ranges()})
+	if err != nil {
+		return err
+	}
+	response.Push(fmt.Sprintf("%s %s", client.CmdOK, encoded))
+	return nil
+}
+
 func (h *Handler) Pull(request PullRequest, response ServerResponse) error {
 	results, err := h.log.Pull(request.ctx, request.n)
 	if err != nil {
@@ -59,6 +102,11 @@ readCycle:
 			if !ok {
 				break readCycle
 			}
+			if h.limiter != nil && !request.peer {
+				if err := h.limiter.Pull(request.ctx, request.user, request.name, len(result)); err != nil {
+					return nil
+				}
+			}
 			response.Push(result)
 		}
 	}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+)
+
+const (
+	OpGet  = "get"
+	OpPull = "pull"
+	OpE2E  = "publish-to-pull"
+)
+
+// Latencies collects latencies of the single operation.
+type Latencies struct {
+	m         sync.Mutex
+	latencies []time.Duration
+	errors    int
+}
+
+func (l *Latencies) Add(latency time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.latencies = append(l.latencies, latency)
+}
+
+func (l *Latencies) Fail() {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.errors++
+}
+
+// Percentile returns the latency below which the given fraction of
+// latencies falls. Latencies must be sorted.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(latencies))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return latencies[i]
+}
+
+func (l *Latencies) Report(op string, duration time.Duration) string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	latencies := append([]time.Duration{}, l.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	throughput := float64(len(latencies)) / duration.Seconds()
+	return fmt.Sprintf("%-16s %8d %6d %10.1f %12s %12s %12s %12s",
+		op, len(latencies), l.errors, throughput,
+		percentile(latencies, 0.5), percentile(latencies, 0.99), percentile(latencies, 0.999), percentile(latencies, 1))
+}
+
+// Mix is the weighted set of operations made by producers.
+type Mix struct {
+	ops     []string
+	weights []int
+	total   int
+}
+
+func parseMix(raw string) (*Mix, error) {
+	mix := &Mix{}
+	for _, part := range strings.Split(raw, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid mix %s", part)
+		}
+		switch kv[0] {
+		case OpPush, OpGet, OpPull:
+		default:
+			return nil, fmt.Errorf("unknown operation %s", kv[0])
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight of %s", kv[0])
+		}
+		mix.ops = append(mix.ops, kv[0])
+		mix.weights = append(mix.weights, weight)
+		mix.total += weight
+	}
+	if mix.total == 0 {
+		return nil, errors.New("mix is empty")
+	}
+	return mix, nil
+}
+
+func (m *Mix) Pick(r *rand.Rand) string {
+	n := r.Intn(m.total)
+	for i, weight := range m.weights {
+		if n < weight {
+			return m.ops[i]
+		}
+		n -= weight
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+type Bench struct {
+	nodes     []*client.Client
+	mix       *Mix
+	size      int
+	interval  time.Duration
+	started   time.Time
+	latencies map[string]*Latencies
+}
+
+func RunBench(c *cli.Context) error {
+	nodesListString := c.String("nodes")
+	if nodesListString == "" {
+		return errors.New("invalid nodes list")
+	}
+	mix, err := parseMix(c.String("mix"))
+	if err != nil {
+		return err
+	}
+	producers, consumers := c.Int("producers"), c.Int("consumers")
+	if producers <= 0 {
+		return errors.New("at least one producer is required")
+	}
+
+	b := &Bench{
+		mix:  mix,
+		size: c.Int("size"),
+		latencies: map[string]*Latencies{
+			OpPush: {}, OpGet: {}, OpPull: {}, OpE2E: {},
+		},
+	}
+	// Rate is shared between all producers.
+	if rate := c.Float64("rate"); rate > 0 {
+		b.interval = time.Duration(float64(time.Second) * float64(producers) / rate)
+	}
+	for _, node := range strings.Split(nodesListString, ",") {
+		nodeClient, err := client.New(node, nil)
+		if err != nil {
+			return err
+		}
+		b.nodes = append(b.nodes, nodeClient)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Duration("duration"))
+	defer cancel()
+	b.started = time.Now()
+
+	consumersWg := &sync.WaitGroup{}
+	for i := 0; i < consumers; i++ {
+		consumersWg.Add(1)
+		go b.consume(ctx, consumersWg, b.nodes[i%len(b.nodes)])
+	}
+	producersWg := &sync.WaitGroup{}
+	for i := 0; i < producers; i++ {
+		producersWg.Add(1)
+		go b.produce(ctx, producersWg, i, b.nodes[i%len(b.nodes)])
+	}
+	producersWg.Wait()
+	consumersWg.Wait()
+	elapsed := time.Since(b.started)
+
+	fmt.Printf("%-16s %8s %6s %10s %12s %12s %12s %12s\n", "operation", "count", "errors", "ops/s", "p50", "p99", "p999", "max")
+	for _, op := range []string{OpPush, OpGet, OpPull, OpE2E} {
+		fmt.Println(b.latencies[op].Report(op, elapsed))
+	}
+	return nil
+}
+
+// value makes the value of the configured size which carries the time of
+// publishing, so consumers measure the publish-to-pull latency.
+func (b *Bench) value(producer, seq int) string {
+	v := fmt.Sprintf("%d-%d-%d-", time.Now().UnixNano(), producer, seq)
+	if len(v) < b.size {
+		v += strings.Repeat("x", b.size-len(v))
+	}
+	return v
+}
+
+func published(v string) (time.Time, bool) {
+	parts := strings.SplitN(strings.TrimSpace(v), "-", 2)
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func (b *Bench) produce(ctx context.Context, wg *sync.WaitGroup, producer int, node *client.Client) {
+	defer wg.Done()
+	r := rand.New(rand.NewSource(int64(producer)))
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for seq := 0; ; seq++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		op := b.mix.Pick(r)
+		started := time.Now()
+		var err error
+		switch op {
+		case OpPush:
+			err = b.push(node, b.value(producer, seq))
+		case OpGet:
+			_, err = node.QueryMany(&client.Get{N: 0})
+		case OpPull:
+			err = b.pullOne(ctx, node)
+		}
+		// The operation is interrupted by the end of the run.
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.latencies[op].Fail()
+			continue
+		}
+		b.latencies[op].Add(time.Since(started))
+	}
+}
+
+func (b *Bench) push(node *client.Client, v string) error {
+	response, err := node.QueryOne(&client.Push{V: v})
+	if err != nil {
+		return err
+	}
+	ok, err := response.Ok()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("push refused")
+	}
+	return nil
+}
+
+// pullOne starts pulling and waits for the first value. The connection is
+// closed when the run ends, the empty log never replies PULL.
+func (b *Bench) pullOne(ctx context.Context, node *client.Client) error {
+	connection, err := node.Connect()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		connection.Close()
+	}()
+	responses, err := connection.QueryMany(&client.Pull{N: 0})
+	if err != nil {
+		return err
+	}
+	if responses.Next() == nil {
+		if err := responses.Err(); err != nil {
+			return err
+		}
+		return errors.New("pull closed")
+	}
+	return nil
+}
+
+func (b *Bench) consume(ctx context.Context, wg *sync.WaitGroup, node *client.Client) {
+	defer wg.Done()
+	connection, err := node.Connect()
+	if err != nil {
+		log.Println("error", err)
+		b.latencies[OpE2E].Fail()
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		connection.Close()
+	}()
+	responses, err := connection.QueryMany(&client.Pull{N: 0})
+	if err != nil {
+		log.Println("error", err)
+		b.latencies[OpE2E].Fail()
+		return
+	}
+	for response := responses.Next(); response != nil; response = responses.Next() {
+		at, ok := published(response.Message)
+		// Skip values left by the previous runs.
+		if !ok || at.Before(b.started) {
+			continue
+		}
+		b.latencies[OpE2E].Add(time.Since(at))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tariel-x/stream/client"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("push=3, get=1,pull=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mix.total != 4 || len(mix.ops) != 3 {
+		t.Errorf("unexpected mix %+v", mix)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		if op := mix.Pick(r); op == OpPull {
+			t.Fatal("operation without weight is picked")
+		}
+	}
+
+	for _, raw := range []string{"push", "put=1", "push=-1", "push=0"} {
+		if _, err := parseMix(raw); err == nil {
+			t.Errorf("%q is valid", raw)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i))
+	}
+	for p, expected := range map[float64]time.Duration{0.5: 50, 0.99: 99, 0.999: 100, 1: 100, 0: 1} {
+		if actual := percentile(latencies, p); actual != expected {
+			t.Errorf("p%v: expected %d, got %d", p, expected, actual)
+		}
+	}
+	if percentile(nil, 0.5) != 0 {
+		t.Error("percentile of no latencies")
+	}
+}
+
+func TestPublished(t *testing.T) {
+	b := &Bench{size: 64}
+	v := b.value(1, 2)
+	if len(v) != 64 {
+		t.Errorf("unexpected size %d", len(v))
+	}
+	at, ok := published(v)
+	if !ok || time.Since(at) > time.Minute {
+		t.Errorf("unexpected time %s of %q", at, v)
+	}
+	if _, ok := published("value"); ok {
+		t.Error("value without time is published")
+	}
+}
+
+func TestBench_Pull(t *testing.T) {
+	// The node never replies, like PULL of the empty log.
+	silent := make(chan struct{})
+	defer close(silent)
+	node, stop := fakeNode(t, func(string) []string {
+		<-silent
+		return nil
+	})
+	defer stop()
+	nodeClient, err := client.New(node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix, _ := parseMix("pull=1")
+	b := &Bench{mix: mix, latencies: map[string]*Latencies{OpPull: {}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		b.produce(ctx, wg, 0, nodeClient)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pull is not stopped by the end of the run")
+	}
+	// The interrupted pull is not an error.
+	if b.latencies[OpPull].errors != 0 {
+		t.Errorf("%d errors", b.latencies[OpPull].errors)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Chaos is the client of the proxy admin API.
+type Chaos struct {
+	admin string
+}
+
+func (c *Chaos) call(path string, query url.Values) error {
+	u := url.URL{Scheme: "http", Host: c.admin, Path: path, RawQuery: query.Encode()}
+	response, err := http.Post(u.String(), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("proxy admin %s: %s", path, body)
+	}
+	return nil
+}
+
+func (c *Chaos) Delay(from, to, cmd, delay string) error {
+	return c.call("/delay", url.Values{"from": {from}, "to": {to}, "cmd": {cmd}, "delay": {delay}})
+}
+
+func (c *Chaos) Drop(from, to, cmd string) error {
+	return c.call("/drop", url.Values{"from": {from}, "to": {to}, "cmd": {cmd}})
+}
+
+func (c *Chaos) Partition(a, b string) error {
+	return c.call("/partition", url.Values{"a": {a}, "b": {b}})
+}
+
+func (c *Chaos) Isolate(node string) error {
+	return c.call("/isolate", url.Values{"node": {node}})
+}
+
+func (c *Chaos) Heal() error {
+	return c.call("/heal", nil)
+}
+
+// Scenario breaks the network before the pushes. The network is healed
+// before the reads.
+type Scenario func(chaos *Chaos, nodes []string) error
+
+var scenarios = map[string]Scenario{
+	"latency": func(chaos *Chaos, nodes []string) error {
+		return chaos.Delay("", "", "", "50ms")
+	},
+	"partition": func(chaos *Chaos, nodes []string) error {
+		if len(nodes) < 2 {
+			return fmt.Errorf("at least 2 nodes required")
+		}
+		return chaos.Partition(nodes[0], nodes[1])
+	},
+	// The first node proposes its values, but nobody receives its ACCEPT.
+	"isolate-proposer-accept": func(chaos *Chaos, nodes []string) error {
+		if len(nodes) < 1 {
+			return fmt.Errorf("at least 1 node required")
+		}
+		return chaos.Drop(nodes[0], "", "ACCEPT")
+	},
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Violation is the single broken guarantee found in the history.
+type Violation struct {
+	Check   string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Check, v.Message)
+}
+
+// Check verifies that the last reads of all nodes are prefixes of one
+// agreed sequence, every acknowledged push is in the sequence exactly once
+// and pushes that do not overlap in time are ordered as they were made.
+func Check(history *History) ([]string, []Violation) {
+	operations := history.Operations()
+	reads := map[string][]string{}
+	var nodes []string
+	for _, operation := range operations {
+		if operation.Op != OpRead || !operation.Ok {
+			continue
+		}
+		if _, ok := reads[operation.Node]; !ok {
+			nodes = append(nodes, operation.Node)
+		}
+		reads[operation.Node] = operation.Values
+	}
+
+	var violations []Violation
+	var agreed []string
+	agreedNode := ""
+	for _, node := range nodes {
+		values := reads[node]
+		if len(values) > len(agreed) {
+			agreed, agreedNode = values, node
+		}
+	}
+
+	for _, node := range nodes {
+		if node == agreedNode {
+			continue
+		}
+		values := reads[node]
+		if i := divergence(agreed, values); i >= 0 {
+			violations = append(violations, Violation{
+				Check:   "agreement",
+				Message: fmt.Sprintf("log of %s is not a prefix of log of %s\n%s", node, agreedNode, diff(agreedNode, agreed, node, values, i)),
+			})
+		}
+	}
+
+	positions := map[string]int{}
+	for i, value := range agreed {
+		if previous, ok := positions[value]; ok {
+			violations = append(violations, Violation{
+				Check:   "duplicate",
+				Message: fmt.Sprintf("value %s is at positions %d and %d", value, previous, i),
+			})
+			continue
+		}
+		positions[value] = i
+	}
+
+	var acknowledged []*Operation
+	for _, operation := range operations {
+		if operation.Op != OpPush || !operation.Ok {
+			continue
+		}
+		if _, ok := positions[operation.Value]; !ok {
+			violations = append(violations, Violation{
+				Check:   "lost",
+				Message: fmt.Sprintf("value %s acknowledged by %s is not in the log", operation.Value, operation.Node),
+			})
+			continue
+		}
+		acknowledged = append(acknowledged, operation)
+	}
+
+	for _, a := range acknowledged {
+		for _, b := range acknowledged {
+			if !a.Completed.Before(b.Invoked) {
+				continue
+			}
+			if positions[a.Value] > positions[b.Value] {
+				violations = append(violations, Violation{
+					Check: "linearizability",
+					Message: fmt.Sprintf("value %s was pushed after %s completed, but is at position %d before %d",
+						b.Value, a.Value, positions[b.Value], positions[a.Value]),
+				})
+			}
+		}
+	}
+
+	return agreed, violations
+}
+
+// divergence returns the first position where the values differ from the
+// agreed sequence or -1 if the values are its prefix.
+func divergence(agreed, values []string) int {
+	for i := range values {
+		if i >= len(agreed) || agreed[i] != values[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func diff(leftName string, left []string, rightName string, right []string, from int) string {
+	lines := []string{fmt.Sprintf("  %-6s %-20s %-20s", "pos", leftName, rightName)}
+	for i := from; i < len(left) || i < len(right); i++ {
+		l, r := "-", "-"
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		mark := " "
+		if l != r {
+			mark = "!"
+		}
+		lines = append(lines, fmt.Sprintf("%s %-6d %-20s %-20s", mark, i, l, r))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func report(violations []Violation) string {
+	lines := make([]string, 0, len(violations)+1)
+	lines = append(lines, fmt.Sprintf("%d violations found", len(violations)))
+	for _, violation := range violations {
+		lines = append(lines, violation.String())
+	}
+	return strings.Join(lines, "\n")
+}
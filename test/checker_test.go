@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func push(h *History, node, value string, ok bool) {
+	h.Complete(h.Invoke(OpPush, node, value), ok, nil, nil)
+}
+
+func read(h *History, node string, values ...string) {
+	h.Complete(h.Invoke(OpRead, node, ""), true, values, nil)
+}
+
+func checks(violations []Violation) map[string]int {
+	found := map[string]int{}
+	for _, violation := range violations {
+		found[violation.Check]++
+	}
+	return found
+}
+
+func TestCheck(t *testing.T) {
+	h := NewHistory()
+	push(h, "n1", "a", true)
+	push(h, "n2", "b", true)
+	push(h, "n1", "c", false)
+	read(h, "n1", "a", "b")
+	read(h, "n2", "a")
+
+	agreed, violations := Check(h)
+	if len(violations) != 0 {
+		t.Errorf("unexpected violations %v", violations)
+	}
+	if len(agreed) != 2 {
+		t.Errorf("unexpected agreed sequence %v", agreed)
+	}
+}
+
+func TestCheck_Violations(t *testing.T) {
+	h := NewHistory()
+	push(h, "n1", "a", true)
+	push(h, "n2", "b", true)
+	push(h, "n2", "c", true)
+	read(h, "n1", "b", "a", "b")
+	read(h, "n2", "b", "c")
+
+	_, violations := Check(h)
+	found := checks(violations)
+	for _, check := range []string{"agreement", "duplicate", "lost", "linearizability"} {
+		if found[check] == 0 {
+			t.Errorf("%s violation is not found in %v", check, violations)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	OpPush = "push"
+	OpRead = "read"
+)
+
+// Operation is the single client call recorded from invocation to
+// completion.
+type Operation struct {
+	Op        string
+	Node      string
+	Value     string
+	Values    []string
+	Invoked   time.Time
+	Completed time.Time
+	Ok        bool
+	Err       error
+}
+
+// History is the list of operations made by all testers.
+type History struct {
+	m          sync.Mutex
+	operations []*Operation
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+// Invoke records the beginning of the operation.
+func (h *History) Invoke(op, node, value string) *Operation {
+	h.m.Lock()
+	defer h.m.Unlock()
+	operation := &Operation{
+		Op:      op,
+		Node:    node,
+		Value:   value,
+		Invoked: time.Now(),
+	}
+	h.operations = append(h.operations, operation)
+	return operation
+}
+
+// Complete records the result of the operation.
+func (h *History) Complete(operation *Operation, ok bool, values []string, err error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	operation.Completed = time.Now()
+	operation.Ok = ok
+	operation.Values = values
+	operation.Err = err
+}
+
+func (h *History) Operations() []*Operation {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return append([]*Operation{}, h.operations...)
+}
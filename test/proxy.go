@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/client"
+)
+
+const (
+	ActionDrop  = "drop"
+	ActionDelay = "delay"
+)
+
+// Rule is the fault applied to the connection which matches it. Empty
+// From, To or Cmd matches any value.
+type Rule struct {
+	Action string        `json:"action"`
+	From   string        `json:"from,omitempty"`
+	To     string        `json:"to,omitempty"`
+	Cmd    string        `json:"cmd,omitempty"`
+	Delay  time.Duration `json:"delay,omitempty"`
+}
+
+func (r Rule) match(from, to, cmd string) bool {
+	return (r.From == "" || r.From == from) &&
+		(r.To == "" || r.To == to) &&
+		(r.Cmd == "" || r.Cmd == cmd)
+}
+
+// Faults is the set of rules shared by all proxies.
+type Faults struct {
+	m     sync.RWMutex
+	rules []Rule
+}
+
+func (f *Faults) Add(rules ...Rule) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.rules = append(f.rules, rules...)
+}
+
+func (f *Faults) Heal() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.rules = nil
+}
+
+func (f *Faults) Rules() []Rule {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	return append([]Rule{}, f.rules...)
+}
+
+// Apply returns the delay for the connection and false if the connection
+// must be dropped.
+func (f *Faults) Apply(from, to, cmd string) (time.Duration, bool) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	var delay time.Duration
+	for _, rule := range f.rules {
+		if !rule.match(from, to, cmd) {
+			continue
+		}
+		switch rule.Action {
+		case ActionDrop:
+			return 0, false
+		case ActionDelay:
+			delay += rule.Delay
+		}
+	}
+	return delay, true
+}
+
+// Proxy forwards connections from the listen address to the node. The
+// source node is recognized by the advertised address in the request meta,
+// or by the name if the address is not sent, so nodes must advertise the
+// proxy addresses and rules name nodes by them on both sides.
+type Proxy struct {
+	listen string
+	node   string
+	faults *Faults
+}
+
+func (p *Proxy) Run(ctx context.Context) error {
+	socket, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ctx, socket)
+}
+
+// Serve forwards connections accepted by the socket until the context is
+// done.
+func (p *Proxy) Serve(ctx context.Context, socket net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		if err := socket.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+	log.Println("proxy", p.listen, "->", p.node)
+	for {
+		conn, err := socket.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return err
+			}
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *Proxy) forward(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	request, err := reader.ReadString('\n')
+	if err != nil {
+		log.Println("proxy", p.listen, "can not read request", err)
+		return
+	}
+	from, cmd := parseProxiedRequest(request)
+	delay, ok := p.faults.Apply(from, p.listen, cmd)
+	if !ok {
+		log.Printf("proxy %s drop %s from %s", p.listen, cmd, from)
+		return
+	}
+	time.Sleep(delay)
+
+	node, err := net.Dial("tcp", p.node)
+	if err != nil {
+		log.Println("proxy", p.listen, "can not connect to node", err)
+		return
+	}
+	defer node.Close()
+	if _, err := io.WriteString(node, request); err != nil {
+		log.Println("proxy", p.listen, "can not write request", err)
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(node, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, node)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func parseProxiedRequest(request string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(request), ";")
+	cmd := strings.SplitN(parts[0], " ", 2)[0]
+	meta := map[string]string{}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			meta[kv[0]] = kv[1]
+		}
+	}
+	// The name is the node ID, the address is the proxy address as To.
+	if address, ok := meta[client.MetaKeyAddress]; ok {
+		return address, cmd
+	}
+	return meta[client.MetaKeyName], cmd
+}
+
+// Admin is the HTTP API to manage faults:
+//
+//	GET  /rules                            - list rules;
+//	POST /delay?from=&to=&cmd=&delay=100ms - add latency;
+//	POST /drop?from=&to=&cmd=              - drop connections;
+//	POST /partition?a=&b=                  - drop connections between a and b;
+//	POST /isolate?node=                    - drop connections from and to the node;
+//	POST /heal                             - remove all rules.
+type Admin struct {
+	faults *Faults
+}
+
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rules", a.rules)
+	mux.HandleFunc("/delay", a.post(a.delay))
+	mux.HandleFunc("/drop", a.post(a.drop))
+	mux.HandleFunc("/partition", a.post(a.partition))
+	mux.HandleFunc("/isolate", a.post(a.isolate))
+	mux.HandleFunc("/heal", a.post(a.heal))
+	return mux
+}
+
+func (a *Admin) post(f func(*http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := f(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.rules(w, r)
+	}
+}
+
+func (a *Admin) rules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.faults.Rules()); err != nil {
+		log.Println(err)
+	}
+}
+
+func ruleFromQuery(r *http.Request, action string) Rule {
+	query := r.URL.Query()
+	return Rule{
+		Action: action,
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+		Cmd:    query.Get("cmd"),
+	}
+}
+
+func (a *Admin) delay(r *http.Request) error {
+	rule := ruleFromQuery(r, ActionDelay)
+	delay, err := time.ParseDuration(r.URL.Query().Get("delay"))
+	if err != nil {
+		return err
+	}
+	rule.Delay = delay
+	a.faults.Add(rule)
+	return nil
+}
+
+func (a *Admin) drop(r *http.Request) error {
+	a.faults.Add(ruleFromQuery(r, ActionDrop))
+	return nil
+}
+
+func (a *Admin) partition(r *http.Request) error {
+	query := r.URL.Query()
+	nodeA, nodeB := query.Get("a"), query.Get("b")
+	if nodeA == "" || nodeB == "" {
+		return errors.New("both a and b are required")
+	}
+	a.faults.Add(
+		Rule{Action: ActionDrop, From: nodeA, To: nodeB},
+		Rule{Action: ActionDrop, From: nodeB, To: nodeA},
+	)
+	return nil
+}
+
+func (a *Admin) isolate(r *http.Request) error {
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		return errors.New("node is required")
+	}
+	a.faults.Add(
+		Rule{Action: ActionDrop, From: node},
+		Rule{Action: ActionDrop, To: node},
+	)
+	return nil
+}
+
+func (a *Admin) heal(r *http.Request) error {
+	a.faults.Heal()
+	return nil
+}
+
+func RunProxy(c *cli.Context) error {
+	nodesListString := c.String("nodes")
+	if nodesListString == "" {
+		return errors.New("invalid nodes list")
+	}
+	listenListString := c.String("listen")
+	if listenListString == "" {
+		return errors.New("invalid listen list")
+	}
+	nodes := strings.Split(nodesListString, ",")
+	listens := strings.Split(listenListString, ",")
+	if len(nodes) != len(listens) {
+		return errors.New("nodes and listen lists must have the same length")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	faults := &Faults{}
+	errc := make(chan error, len(nodes)+1)
+	for i := range nodes {
+		proxy := &Proxy{
+			listen: listens[i],
+			node:   nodes[i],
+			faults: faults,
+		}
+		go func() {
+			errc <- proxy.Run(ctx)
+		}()
+	}
+
+	admin := &Admin{faults: faults}
+	go func() {
+		log.Println("proxy admin", c.String("admin"))
+		errc <- http.ListenAndServe(c.String("admin"), admin.Handler())
+	}()
+	return <-errc
+}
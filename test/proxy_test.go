@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaults_Apply(t *testing.T) {
+	faults := &Faults{}
+	faults.Add(
+		Rule{Action: ActionDelay, To: "p2", Delay: 10 * time.Millisecond},
+		Rule{Action: ActionDelay, Cmd: "ACCEPT", Delay: 5 * time.Millisecond},
+		Rule{Action: ActionDrop, From: "n1", Cmd: "SET"},
+	)
+	tests := []struct {
+		from, to, cmd string
+		delay         time.Duration
+		ok            bool
+	}{
+		{"n1", "p1", "PREPARE", 0, true},
+		{"n1", "p2", "PREPARE", 10 * time.Millisecond, true},
+		{"n2", "p2", "ACCEPT", 15 * time.Millisecond, true},
+		{"n1", "p2", "SET", 0, false},
+		{"n2", "p2", "SET", 10 * time.Millisecond, true},
+	}
+	for _, test := range tests {
+		delay, ok := faults.Apply(test.from, test.to, test.cmd)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("%s %s %s: expected %s %t, got %s %t", test.from, test.to, test.cmd, test.delay, test.ok, delay, ok)
+		}
+	}
+	faults.Heal()
+	if _, ok := faults.Apply("n1", "p2", "SET"); !ok || len(faults.Rules()) != 0 {
+		t.Error("faults are not healed")
+	}
+}
+
+func TestParseProxiedRequest(t *testing.T) {
+	from, cmd := parseProxiedRequest("ACCEPT 5 v id;name=n1;trace=x\n")
+	if from != "n1" || cmd != "ACCEPT" {
+		t.Errorf("unexpected %q %q", from, cmd)
+	}
+	from, cmd = parseProxiedRequest("ACCEPT 5 v id;name=n1;node=n1;addr=localhost:7011\n")
+	if from != "localhost:7011" || cmd != "ACCEPT" {
+		t.Errorf("unexpected %q %q", from, cmd)
+	}
+	if from, cmd := parseProxiedRequest("PUSH a\n"); from != "" || cmd != "PUSH" {
+		t.Errorf("unexpected %q %q", from, cmd)
+	}
+}
+
+// fakeNode replies requests by the command, one request per connection.
+func fakeNode(t *testing.T, reply func(cmd string) []string) (string, func()) {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := socket.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				cmd := strings.SplitN(strings.TrimSpace(line), ";", 2)[0]
+				for _, message := range reply(cmd) {
+					conn.Write([]byte(message + "\n"))
+				}
+			}()
+		}
+	}()
+	return socket.Addr().String(), func() { socket.Close() }
+}
+
+func query(t *testing.T, address, request string) (string, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte(request + "\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestProxy(t *testing.T) {
+	node, stop := fakeNode(t, func(cmd string) []string { return []string{"OK " + cmd} })
+	defer stop()
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults := &Faults{}
+	proxy := &Proxy{listen: socket.Addr().String(), node: node, faults: faults}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.Serve(ctx, socket)
+
+	if reply, err := query(t, proxy.listen, "PREPARE 1;name=n1"); err != nil || reply != "OK PREPARE 1\n" {
+		t.Errorf("unexpected reply %q, %v", reply, err)
+	}
+
+	faults.Add(Rule{Action: ActionDrop, From: "n1", To: proxy.listen, Cmd: "ACCEPT"})
+	if reply, err := query(t, proxy.listen, "ACCEPT 1 v id;name=n1"); err == nil {
+		t.Errorf("dropped request is replied %q", reply)
+	}
+	if _, err := query(t, proxy.listen, "ACCEPT 1 v id;name=n2"); err != nil {
+		t.Errorf("request of other node is dropped: %v", err)
+	}
+
+	faults.Add(Rule{Action: ActionDelay, Cmd: "SET", Delay: 50 * time.Millisecond})
+	started := time.Now()
+	if _, err := query(t, proxy.listen, "SET 1 v id;name=n2"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("request is not delayed, %s", elapsed)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	faults := &Faults{}
+	admin := httptest.NewServer((&Admin{faults: faults}).Handler())
+	defer admin.Close()
+	u, err := url.Parse(admin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chaos := &Chaos{admin: u.Host}
+	nodes := []string{"n1", "n2", "n3"}
+
+	expected := map[string][]Rule{
+		"latency": {{Action: ActionDelay, Delay: 50 * time.Millisecond}},
+		"partition": {
+			{Action: ActionDrop, From: "n1", To: "n2"},
+			{Action: ActionDrop, From: "n2", To: "n1"},
+		},
+		"isolate-proposer-accept": {{Action: ActionDrop, From: "n1", Cmd: "ACCEPT"}},
+	}
+	for name, scenario := range scenarios {
+		if err := chaos.Heal(); err != nil {
+			t.Fatal(err)
+		}
+		if err := scenario(chaos, nodes); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if rules := faults.Rules(); !reflect.DeepEqual(rules, expected[name]) {
+			t.Errorf("%s: unexpected rules %+v", name, rules)
+		}
+	}
+
+	for _, name := range []string{"partition", "isolate-proposer-accept"} {
+		if err := scenarios[name](chaos, nil); err == nil {
+			t.Errorf("%s: no error without nodes", name)
+		}
+	}
+	if err := chaos.Partition("n1", ""); err == nil || !strings.Contains(err.Error(), "both a and b") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
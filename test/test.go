@@ -4,10 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/urfave/cli"
 
@@ -32,6 +32,76 @@ func main() {
 					Name:  "nodes, n",
 					Usage: "List of nodes separated by comma ','.",
 				},
+				cli.StringFlag{
+					Name:  "admin",
+					Usage: "Address of the proxy admin API, required by scenario.",
+				},
+				cli.StringFlag{
+					Name:  "scenario, s",
+					Usage: "Network faults made by the proxy during pushes: latency, partition, isolate-proposer-accept.",
+				},
+			},
+		},
+		{
+			Name:    "bench",
+			Aliases: []string{"b"},
+			Usage:   "measure throughput and latency of the cluster",
+			Action:  RunBench,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "nodes, n",
+					Usage: "List of nodes separated by comma ','.",
+				},
+				cli.IntFlag{
+					Name:  "producers, p",
+					Value: 1,
+					Usage: "Number of producers making operations from the mix",
+				},
+				cli.IntFlag{
+					Name:  "consumers, c",
+					Value: 1,
+					Usage: "Number of consumers pulling the log to measure publish-to-pull latency",
+				},
+				cli.IntFlag{
+					Name:  "size",
+					Value: 64,
+					Usage: "Size of pushed values in bytes",
+				},
+				cli.Float64Flag{
+					Name:  "rate, r",
+					Usage: "Total operations per second of all producers, 0 is unlimited",
+				},
+				cli.DurationFlag{
+					Name:  "duration, d",
+					Value: 10 * time.Second,
+					Usage: "Duration of the benchmark",
+				},
+				cli.StringFlag{
+					Name:  "mix, m",
+					Value: "push=1",
+					Usage: "Weights of producer operations, e.g. push=8,get=1,pull=1",
+				},
+			},
+		},
+		{
+			Name:    "proxy",
+			Aliases: []string{"p"},
+			Usage:   "run fault-injecting proxy in front of each node",
+			Action:  RunProxy,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "nodes, n",
+					Usage: "List of nodes separated by comma ','.",
+				},
+				cli.StringFlag{
+					Name:  "listen, l",
+					Usage: "List of proxy addresses separated by comma ',', one for each node. Nodes must advertise these addresses.",
+				},
+				cli.StringFlag{
+					Name:  "admin",
+					Value: "localhost:7100",
+					Usage: "Listen interface:port of the admin API",
+				},
 			},
 		},
 	}
@@ -48,15 +118,36 @@ func Run(c *cli.Context) error {
 		return errors.New("invalid nodes list")
 	}
 	nodeAddresses := strings.Split(nodesListString, ",")
+
+	var chaos *Chaos
+	var scenario Scenario
+	if name := c.String("scenario"); name != "" {
+		var ok bool
+		if scenario, ok = scenarios[name]; !ok {
+			return fmt.Errorf("unknown scenario %s", name)
+		}
+		if c.String("admin") == "" {
+			return errors.New("proxy admin address is required by scenario")
+		}
+		chaos = &Chaos{admin: c.String("admin")}
+	}
+
+	history := NewHistory()
 	tosts := []*Toster{}
-	for _, node := range nodeAddresses {
-		toster, err := newToster(node)
+	for i, node := range nodeAddresses {
+		toster, err := newToster(node, i, history)
 		if err != nil {
 			return err
 		}
 		tosts = append(tosts, toster)
 	}
 
+	if scenario != nil {
+		if err := scenario(chaos, nodeAddresses); err != nil {
+			return err
+		}
+	}
+
 	wg := &sync.WaitGroup{}
 	for _, tost := range tosts {
 		wg.Add(1)
@@ -64,6 +155,12 @@ func Run(c *cli.Context) error {
 	}
 	wg.Wait()
 
+	if chaos != nil {
+		if err := chaos.Heal(); err != nil {
+			return err
+		}
+	}
+
 	wg = &sync.WaitGroup{}
 	for _, tost := range tosts {
 		wg.Add(1)
@@ -72,8 +169,15 @@ func Run(c *cli.Context) error {
 	wg.Wait()
 
 	for _, tost := range tosts {
-		log.Println(tost.results)
+		log.Println(tost.node, tost.results)
+	}
+
+	agreed, violations := Check(history)
+	log.Println("agreed", agreed)
+	if len(violations) > 0 {
+		return cli.NewExitError(report(violations), 1)
 	}
+	log.Println("no violations found")
 	return nil
 }
 
@@ -82,16 +186,19 @@ type Toster struct {
 	client  *client.Client
 	prefix  string
 	results []string
+	history *History
 }
 
-func newToster(node string) (*Toster, error) {
+func newToster(node string, i int, history *History) (*Toster, error) {
 	client, err := client.New(node, nil)
-	prefix := string(byte(97 + rand.Intn(26)))
+	// The prefix is unique for every toster, so all pushed values differ.
+	prefix := fmt.Sprintf("%c%d-", byte(97+i%26), i/26)
 	return &Toster{
 		node:    node,
 		client:  client,
 		prefix:  prefix,
 		results: []string{},
+		history: history,
 	}, err
 }
 
@@ -101,12 +208,15 @@ func (t *Toster) Begin(wg *sync.WaitGroup) {
 	for i := 0; i < 5; i++ {
 		msg := fmt.Sprintf("%s%d", t.prefix, i)
 		log.Println("send PUSH", msg, "to", t.node)
+		operation := t.history.Invoke(OpPush, t.node, msg)
 		response, err := t.client.QueryOne(&client.Push{V: msg})
 		if err != nil {
+			t.history.Complete(operation, false, nil, err)
 			log.Println("error", err)
 			continue
 		}
 		ok, err := response.Ok()
+		t.history.Complete(operation, ok, nil, err)
 		if err != nil {
 			log.Println("error", err)
 			continue
@@ -121,12 +231,16 @@ func (t *Toster) Read(wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	log.Printf("GET 0 from %s", t.node)
+	operation := t.history.Invoke(OpRead, t.node, "")
 	responses, err := t.client.QueryMany(&client.Get{N: 0})
 	if err != nil {
+		t.history.Complete(operation, false, nil, err)
 		log.Println("error", err)
+		return
 	}
 	t.results = []string{}
 	for _, response := range responses {
-		t.results = append(t.results, response.Message)
+		t.results = append(t.results, strings.TrimSpace(response.Message))
 	}
+	t.history.Complete(operation, true, t.results, nil)
 }
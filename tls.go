@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/urfave/cli"
+
+	"github.com/tariel-x/stream/config"
+)
+
+var tlsFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "PEM certificate file",
+	},
+	cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "PEM key file of the certificate",
+	},
+	cli.StringFlag{
+		Name:  "tls-ca",
+		Usage: "PEM file of the CA which signs certificates of nodes",
+	},
+}
+
+// tlsPeerCAFlag is the flag of the node only, clients do not verify peers.
+var tlsPeerCAFlag = cli.StringFlag{
+	Name:  "tls-peer-ca",
+	Usage: "PEM file of the CA which signs client certificates of nodes only, connections with them are peers",
+}
+
+func loadCA(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return pool, nil
+}
+
+// nodeTLS makes TLS configs of the node listener and of connections to
+// peers. The node certificate is the client certificate for peers, they
+// verify it by the peer CA, and the server certificate verified by the CA.
+// Nil configs are returned if TLS is not configured.
+func nodeTLS(conf config.TLS) (*tls.Config, *tls.Config, error) {
+	certFile, keyFile, caFile, peerCAFile := conf.Cert, conf.Key, conf.CA, conf.PeerCA
+	if certFile == "" && keyFile == "" && caFile == "" && peerCAFile == "" {
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" || caFile == "" || peerCAFile == "" {
+		return nil, nil, errors.New("tls-cert, tls-key, tls-ca and tls-peer-ca are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := loadCA(caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	peerCA, err := loadCA(peerCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    peerCA,
+		// Clients may connect without certificates or with certificates of
+		// other CAs, the server verifies peers by ClientCAs itself.
+		ClientAuth: tls.RequestClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return serverConfig, clientConfig, nil
+}
+
+// clientTLS makes the TLS config of client commands. The server is
+// verified by the CA or by system roots, the certificate is optional.
+func clientTLS(c *cli.Context) (*tls.Config, error) {
+	certFile, keyFile, caFile := c.String("tls-cert"), c.String("tls-key"), c.String("tls-ca")
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		ca, err := loadCA(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = ca
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,165 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tariel-x/stream/client"
+	storage "github.com/tariel-x/stream/log"
+	"github.com/tariel-x/stream/logger"
+	"github.com/tariel-x/stream/paxos"
+	"github.com/tariel-x/stream/stream"
+	"github.com/tariel-x/stream/tracing"
+)
+
+type request struct {
+	message string
+	name    string
+	peer    bool
+}
+
+func (r *request) Message() string         { return r.message }
+func (r *request) Address() string         { return r.name }
+func (r *request) Name() string            { return r.name }
+func (r *request) Peer() bool              { return r.peer }
+func (r *request) Meta() map[string]string { return nil }
+
+type response struct {
+	messages []string
+}
+
+func (r *response) Push(message string) {
+	r.messages = append(r.messages, message)
+}
+
+// peer passes requests to the handler of the other node and continues the
+// trace from the traceparent meta as the server does.
+type peer struct {
+	t        *testing.T
+	from     string
+	to       string
+	handlers map[string]*stream.Handler
+	sets     chan struct{}
+}
+
+func (p *peer) QueryOne(r client.Request) (*client.Response, error) {
+	ctx := context.Background()
+	var traceparent string
+	if metaRequest, ok := r.(*client.MetaRequest); ok {
+		traceparent = metaRequest.Meta[client.MetaKeyTrace]
+	}
+	sc, err := tracing.ParseTraceparent(traceparent)
+	if err != nil {
+		p.t.Errorf("%s to %s: %v", r, p.to, err)
+	} else {
+		ctx = tracing.ContextWithRemote(ctx, sc)
+	}
+	reply := &response{}
+	if err := p.handlers[p.to].Process(ctx, &request{message: r.String(), name: p.from, peer: true}, reply); err != nil {
+		return nil, err
+	}
+	return &client.Response{Message: reply.messages[0]}, nil
+}
+
+func (p *peer) Exec(r client.Request) error {
+	defer func() { p.sets <- struct{}{} }()
+	_, err := p.QueryOne(r)
+	return err
+}
+
+type exportedSpan struct {
+	Name     string `json:"name"`
+	TraceID  string `json:"trace_id"`
+	SpanID   string `json:"span_id"`
+	ParentID string `json:"parent_id"`
+}
+
+func TestTracer_Peers(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	tracer := tracing.NewTracer(tracing.NewWriterExporter(buffer), nil)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	names := []string{"n1", "n2", "n3"}
+	handlers := map[string]*stream.Handler{}
+	sets := make(chan struct{}, len(names))
+	for _, name := range names {
+		var nodes []paxos.Node
+		for _, other := range names {
+			if other != name {
+				nodes = append(nodes, &peer{t: t, from: name, to: other, handlers: handlers, sets: sets})
+			}
+		}
+		pxs, err := paxos.NewPaxosWithNodes(nodes, rand.Reader, logger.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lg, err := storage.NewLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := stream.NewHandler(lg, pxs, logger.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.SetName(name)
+		handlers[name] = handler
+	}
+
+	reply := &response{}
+	push := &request{message: (&client.Push{V: "a"}).String(), name: "client"}
+	if err := handlers["n1"].Process(context.Background(), push, reply); err != nil {
+		t.Fatal(err)
+	}
+	// SET requests are not waited by the proposer.
+	for range names[1:] {
+		<-sets
+	}
+	tracer.Shutdown()
+
+	spans := map[string]exportedSpan{}
+	var root exportedSpan
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var span exportedSpan
+		if err := json.Unmarshal([]byte(line), &span); err != nil {
+			t.Fatal(err)
+		}
+		spans[span.SpanID] = span
+		if span.Name == "stream.push" {
+			root = span
+		}
+	}
+	if root.SpanID == "" || root.ParentID != "" {
+		t.Fatalf("no root push span in %v", spans)
+	}
+	// Spans of peers are children of spans of requests to them.
+	parents := map[string]string{
+		"stream.prepare": "paxos.send_prepare",
+		"stream.accept":  "paxos.send_accept",
+		"stream.set":     "paxos.set",
+	}
+	remote := map[string]int{}
+	for _, span := range spans {
+		if span.TraceID != root.TraceID {
+			t.Errorf("span %+v is not in the trace %s", span, root.TraceID)
+		}
+		parentName, ok := parents[span.Name]
+		if !ok {
+			continue
+		}
+		remote[span.Name]++
+		if parent := spans[span.ParentID]; parent.Name != parentName {
+			t.Errorf("span %+v has the parent %+v", span, parent)
+		}
+	}
+	// Rounds may be retried with the greater proposal.
+	for name := range parents {
+		if remote[name] < len(names)-1 {
+			t.Errorf("expected at least %d %s spans, got %d", len(names)-1, name, remote[name])
+		}
+	}
+}
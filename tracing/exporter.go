@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type spanLine struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	DurationMs float64           `json:"duration_ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// WriterExporter writes spans as JSON lines, e.g. to stdout or to the file.
+type WriterExporter struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func (e *WriterExporter) Export(spans []*Span) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		line := spanLine{
+			Name:       span.Name,
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Start:      span.Start,
+			DurationMs: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Attributes: span.Attributes,
+			Error:      span.Err,
+		}
+		if span.Parent != (SpanID{}) {
+			line.ParentID = span.Parent.String()
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to the OpenTelemetry collector by OTLP/HTTP
+// in the JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter creates the exporter, the endpoint is the full URL, e.g.
+// http://localhost:4318/v1/traces.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+func attributes(values map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, otlpAttribute{Key: key, Value: otlpValue{StringValue: values[key]}})
+	}
+	return result
+}
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "github.com/tariel-x/stream"
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        attributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = span.Parent.String()
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = attributes(map[string]string{"service.name": e.service})
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{resource}})
+	if err != nil {
+		return err
+	}
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("otlp export: %s: %s", response.Status, message)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Package tracing records spans of requests and propagates the trace
+// context between nodes in the W3C traceparent format.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies the span across nodes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as the traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.Valid() {
+		return sc, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// Span is the timed operation.
+type Span struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        string
+
+	m      sync.Mutex
+	tracer *Tracer
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.Attributes[key] = fmt.Sprint(value)
+}
+
+// Finish ends the span and exports it, the error marks the span failed.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	s.End = time.Now()
+	if err != nil {
+		s.Err = err.Error()
+	}
+	s.m.Unlock()
+	s.tracer.export(s)
+}
+
+type spanKey struct{}
+
+// ContextWithRemote puts the span context received from another node to
+// the context, so local spans continue the trace.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// FromContext returns the context of the current span.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok && sc.Valid()
+}
+
+// Exporter sends finished spans to the storage.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer batches finished spans and sends them to the exporter.
+type Tracer struct {
+	exporter Exporter
+	spans    chan *Span
+	stop     chan struct{}
+	done     chan struct{}
+	onError  func(error)
+}
+
+const (
+	batchSize     = 100
+	queueSize     = 4096
+	flushInterval = time.Second
+)
+
+// NewTracer starts the tracer. Errors of the exporter are passed to
+// onError.
+func NewTracer(exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		spans:    make(chan *Span, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		onError:  onError,
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracer) export(span *Span) {
+	// Spans are dropped rather than slowing requests down.
+	select {
+	case t.spans <- span:
+	default:
+	}
+}
+
+// Shutdown exports queued spans and stops the tracer. Spans finished
+// later are dropped.
+func (t *Tracer) Shutdown() {
+	close(t.stop)
+	<-t.done
+}
+
+// Start starts the span, it is the child of the span in the context.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+		tracer:     t,
+	}
+	if parent, ok := FromContext(ctx); ok {
+		span.Context.TraceID = parent.TraceID
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+	rand.Read(span.Context.SpanID[:])
+	return ContextWithRemote(ctx, span.Context), span
+}
+
+var (
+	defaultM      sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// SetTracer sets the tracer used by Start. Nil disables tracing.
+func SetTracer(t *Tracer) {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+	defaultTracer = t
+}
+
+// Start starts the span by the tracer set by SetTracer. If tracing is
+// disabled the nil span is returned, all its methods do nothing.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	defaultM.RLock()
+	t := defaultTracer
+	defaultM.RUnlock()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name)
+}
+
+// Traceparent returns the traceparent of the current span or the empty
+// string.
+func Traceparent(ctx context.Context) string {
+	if sc, ok := FromContext(ctx); ok {
+		return sc.Traceparent()
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Traceparent() != traceparent {
+		t.Errorf("%s != %s", sc.Traceparent(), traceparent)
+	}
+	for _, invalid := range []string{"", "00-xyz-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("%q is parsed", invalid)
+		}
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	tracer := NewTracer(NewWriterExporter(buffer), nil)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("n", 5)
+	child.Finish(errors.New("quorum failed"))
+	parent.Finish(nil)
+	tracer.Shutdown()
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 spans, got %v", lines)
+	}
+	var exported spanLine
+	if err := json.Unmarshal([]byte(lines[0]), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Name != "child" || exported.Error != "quorum failed" || exported.Attributes["n"] != "5" {
+		t.Errorf("unexpected span %+v", exported)
+	}
+	if exported.TraceID != parent.Context.TraceID.String() || exported.ParentID != parent.Context.SpanID.String() {
+		t.Errorf("span %+v is not the child of %s", exported, parent.Context.Traceparent())
+	}
+}
+
+func TestOTLPExporter_Export(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var request otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		requests <- request
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(NewOTLPExporter(server.URL+"/v1/traces", "stream"), func(err error) { t.Error(err) })
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("n", 5)
+	child.Finish(errors.New("quorum failed"))
+	parent.Finish(nil)
+	tracer.Shutdown()
+
+	request := <-requests
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	resource := request.ResourceSpans[0]
+	if attrs := resource.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "stream" {
+		t.Errorf("unexpected resource %+v", resource.Resource)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	exported := spans[0]
+	if exported.Name != "child" || exported.TraceID != parent.Context.TraceID.String() || exported.ParentSpanID != parent.Context.SpanID.String() {
+		t.Errorf("span %+v is not the child of %s", exported, parent.Context.Traceparent())
+	}
+	if exported.Status.Code != otlpStatusError || exported.Status.Message != "quorum failed" {
+		t.Errorf("unexpected status %+v", exported.Status)
+	}
+	if len(exported.Attributes) != 1 || exported.Attributes[0].Key != "n" || exported.Attributes[0].Value.StringValue != "5" {
+		t.Errorf("unexpected attributes %+v", exported.Attributes)
+	}
+	if spans[1].ParentSpanID != "" || spans[1].Status.Code != otlpStatusOK {
+		t.Errorf("unexpected root span %+v", spans[1])
+	}
+
+	// The collector errors are returned.
+	status = http.StatusBadRequest
+	if err := NewOTLPExporter(server.URL+"/v1/traces", "stream").Export([]*Span{parent}); err == nil {
+		t.Error("the error of the collector is not returned")
+	}
+	<-requests
+}